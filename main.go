@@ -1,20 +1,31 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"html/template"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/psvmcc/hub/pkg/bundle"
 	"github.com/psvmcc/hub/pkg/handlers"
+	"github.com/psvmcc/hub/pkg/index"
 	"github.com/psvmcc/hub/pkg/logging"
+	"github.com/psvmcc/hub/pkg/misc"
+	"github.com/psvmcc/hub/pkg/quota"
+	"github.com/psvmcc/hub/pkg/storage"
+	"github.com/psvmcc/hub/pkg/sumdb"
 	"github.com/psvmcc/hub/pkg/templates"
 	"github.com/psvmcc/hub/pkg/types"
 	"github.com/psvmcc/hub/pkg/victoriametrics"
+	"github.com/psvmcc/hub/pkg/warm"
 
 	"github.com/VictoriaMetrics/metrics"
 	"github.com/labstack/echo/v4"
@@ -64,6 +75,11 @@ func main() {
 					Value:   "config.yaml",
 					EnvVars: []string{"HUB_CONFIG"},
 				},
+				&cli.BoolFlag{
+					Name:    "offline",
+					Usage:   "Serve from cache only, never contact upstream",
+					EnvVars: []string{"HUB_OFFLINE"},
+				},
 				&cli.StringFlag{
 					Name:    "real-ip-trust-range",
 					Usage:   "Real IP trust range",
@@ -73,6 +89,150 @@ func main() {
 			},
 			Action: startServer,
 		},
+		{
+			Name:      "scrub",
+			Usage:     "Hashes cached artifacts again and drops the ones that changed",
+			ArgsUsage: "[prefix]",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "verbose",
+					Usage:   "Verbose logging",
+					EnvVars: []string{"HUB_VERBOSE"},
+				},
+				&cli.StringFlag{
+					Name:    "config",
+					Usage:   "Config path",
+					Value:   "config.yaml",
+					EnvVars: []string{"HUB_CONFIG"},
+				},
+			},
+			Action: scrubCache,
+		},
+		{
+			Name:      "warm",
+			Usage:     "Fetches every artifact of lockfiles through a running server",
+			ArgsUsage: "lockfile...",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "verbose",
+					Usage:   "Verbose logging",
+					EnvVars: []string{"HUB_VERBOSE"},
+				},
+				&cli.StringFlag{
+					Name:    "server",
+					Usage:   "URL of the running server",
+					Value:   "http://127.0.0.1:6587",
+					EnvVars: []string{"HUB_SERVER"},
+				},
+				&cli.IntFlag{
+					Name:  "concurrency",
+					Usage: "Artifacts fetched at the same time",
+					Value: 8,
+				},
+				&cli.DurationFlag{
+					Name:  "timeout",
+					Usage: "Time limit of each request to the server, including the body",
+					Value: warm.DefaultTimeout,
+				},
+				&cli.StringFlag{
+					Name:  "pypi",
+					Usage: "PyPI repo or group for requirements.txt and pylock.toml",
+				},
+				&cli.StringFlag{
+					Name:  "npm",
+					Usage: "npm repo or group for package-lock.json and pnpm-lock.yaml",
+				},
+				&cli.StringFlag{
+					Name:  "goproxy",
+					Usage: "GOPROXY repo for go.sum",
+				},
+				&cli.StringFlag{
+					Name:  "rubygems",
+					Usage: "RubyGems repo for Gemfile.lock",
+				},
+				&cli.StringFlag{
+					Name:  "galaxy",
+					Usage: "Galaxy repo for requirements.yml",
+				},
+			},
+			Action: warmCache,
+		},
+		{
+			Name:      "keygen",
+			Usage:     "Creates the key pair that signs and checks bundles",
+			ArgsUsage: "name",
+			Action:    generateBundleKey,
+		},
+		{
+			Name:      "export",
+			Usage:     "Packs cached artifacts into a signed bundle",
+			ArgsUsage: "bundle.tar.gz",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "verbose",
+					Usage:   "Verbose logging",
+					EnvVars: []string{"HUB_VERBOSE"},
+				},
+				&cli.StringFlag{
+					Name:    "config",
+					Usage:   "Config path",
+					Value:   "config.yaml",
+					EnvVars: []string{"HUB_CONFIG"},
+				},
+				&cli.StringFlag{
+					Name:     "key",
+					Usage:    "Private key that signs the bundle",
+					Required: true,
+					EnvVars:  []string{"HUB_BUNDLE_KEY"},
+				},
+				&cli.StringSliceFlag{
+					Name:  "repo",
+					Usage: "Repo to export like pypi/pypi, all repos if not set",
+				},
+				&cli.StringSliceFlag{
+					Name:  "package",
+					Usage: "Package to export like requests, requests==2.32.3 or npm:left-pad@1.3.0",
+				},
+				&cli.StringSliceFlag{
+					Name:  "lockfile",
+					Usage: "Lockfile whose packages are exported",
+				},
+				&cli.StringFlag{
+					Name:  "since",
+					Usage: "Only artifacts fetched since a date, time or duration ago like 72h",
+				},
+				&cli.StringFlag{
+					Name:  "until",
+					Usage: "Only artifacts fetched until a date, time or duration ago",
+				},
+			},
+			Action: exportBundle,
+		},
+		{
+			Name:      "import",
+			Usage:     "Merges signed bundles into the cache",
+			ArgsUsage: "bundle.tar.gz...",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "verbose",
+					Usage:   "Verbose logging",
+					EnvVars: []string{"HUB_VERBOSE"},
+				},
+				&cli.StringFlag{
+					Name:    "config",
+					Usage:   "Config path",
+					Value:   "config.yaml",
+					EnvVars: []string{"HUB_CONFIG"},
+				},
+				&cli.StringFlag{
+					Name:     "pubkey",
+					Usage:    "Public key bundles must be signed with",
+					Required: true,
+					EnvVars:  []string{"HUB_BUNDLE_PUBKEY"},
+				},
+			},
+			Action: importBundle,
+		},
 	}
 	err := app.Run(os.Args)
 	if err != nil {
@@ -83,9 +243,17 @@ func main() {
 func startServer(c *cli.Context) error {
 	metrics.GetOrCreateCounter(fmt.Sprintf("hub_app_version{version=%q,commit=%q}", version, commit)).Inc()
 	cfg.Load(c.String("config"))
+	if c.Bool("offline") {
+		cfg.SetOffline()
+	}
 	logger := logging.Build(c.Bool("verbose"))
 	zap.ReplaceGlobals(logger)
 
+	store, err := storage.New(cfg)
+	if err != nil {
+		log.Fatalf("[STORAGE] Wrong config definition: %v", err)
+	}
+
 	echo.NotFoundHandler = func(c echo.Context) error {
 		return c.String(http.StatusNotFound, "404 page not found")
 	}
@@ -106,6 +274,7 @@ func startServer(c *cli.Context) error {
 		return func(c echo.Context) error {
 			c.Set("cfg", cfg)
 			c.Set("logger", httpLogger)
+			c.Set("storage", store)
 			c.Response().Header().Set("Server", fmt.Sprintf("hub/%s (%s)", version, commit))
 			return next(c)
 		}
@@ -154,7 +323,7 @@ func startServer(c *cli.Context) error {
 	})
 
 	e.Renderer = &templates.TemplateRegistry{
-		Templates: template.Must(template.New("pypi").Funcs(template.FuncMap{"kindIs": templates.KindIs}).Parse(templates.PypiHTML)),
+		Templates: template.Must(template.New("pypi").Funcs(template.FuncMap{"kindIs": templates.KindIs, "coreMetadata": templates.CoreMetadata}).Parse(templates.PypiHTML)),
 	}
 	e.GET("/*", func(c echo.Context) error {
 		return c.String(http.StatusNotFound, "")
@@ -163,23 +332,111 @@ func startServer(c *cli.Context) error {
 		return c.String(http.StatusOK, "pong")
 	}).Name = "global::ping"
 
-	for k := range cfg.Server.PYPI {
+	if cfg.Admin.Enabled() {
+		tokens, err := readTokens(cfg.Admin.Tokens)
+		if err != nil {
+			log.Fatalf("[ADMIN] Wrong config definition: %s", err)
+		}
+		a := e.Group("/-/admin", handlers.BearerAuth("hub admin", tokens))
+		a.GET("/repos", handlers.AdminRepos()).Name = "admin::repos"
+		a.GET("/repos/:ecosystem/:repo/packages", handlers.AdminPackages()).Name = "admin::packages"
+		a.GET("/repos/:ecosystem/:repo/packages/*", handlers.AdminPackage()).Name = "admin::package"
+		a.DELETE("/repos/:ecosystem/:repo/packages/*", handlers.AdminPurgePackage()).Name = "admin::package::purge"
+		a.GET("/objects/*", handlers.AdminObject()).Name = "admin::object"
+		a.DELETE("/objects/*", handlers.AdminPurgeObject()).Name = "admin::object::purge"
+		a.DELETE("/objects", handlers.AdminPurgeGlob()).Name = "admin::objects::purge"
+		a.GET("/mirrors", handlers.MirrorStatus()).Name = "admin::mirrors"
+		a.POST("/index/reconcile", handlers.AdminReconcile()).Name = "admin::index::reconcile"
+	}
+
+	validateRules("pypi", cfg.Server.Rules.PYPI, repoKeys(cfg.Server.PYPI))
+	validateRules("npm", cfg.Server.Rules.NPM, repoKeys(cfg.Server.NPM))
+	validateRules("goproxy", cfg.Server.Rules.GOPROXY, repoKeys(cfg.Server.GOPROXY))
+	validateRules("rubygems", cfg.Server.Rules.RUBYGEMS, repoKeys(cfg.Server.RUBYGEMS))
+
+	for k, v := range cfg.Server.PYPI {
 		p := e.Group(fmt.Sprintf("/pypi/%s", k))
+		if err := v.Validate(); err != nil {
+			log.Fatalf("[PYPI] Wrong config definition for [%s]: %s", k, err)
+		}
+		registerRepo("pypi", k, v)
+		if v.IsHosted() {
+			log.Fatalf("[PYPI] Wrong config definition for [%s], hosted mode is not supported.", k)
+		}
+		if v.IsDir() {
+			p.GET("/simple/:name/", handlers.PypiLocalSimple(k)).Name = fmt.Sprintf("pypi::%s::simple", k)
+			p.GET("/packages/:name/:filename", handlers.PypiLocalPackages(k)).Name = fmt.Sprintf("pypi::%s::packages", k)
+			continue
+		}
 		p.GET("/simple/:name/", handlers.PypiSimple(k)).Name = fmt.Sprintf("pypi::%s::simple", k)
 		p.GET("/packages/:name/:filename", handlers.PypiPackages(k)).Name = fmt.Sprintf("pypi::%s::packages", k)
 	}
 
-	for k := range cfg.Server.RUBYGEMS {
+	for k, v := range cfg.Server.Groups.PYPI {
+		if _, ok := cfg.Server.PYPI[k]; ok {
+			log.Fatalf("[PYPI] Group [%s] clashes with a repo of the same name.", k)
+		}
+		if err := v.Validate(repoKeys(cfg.Server.PYPI)); err != nil {
+			log.Fatalf("[PYPI] Wrong group definition for [%s]: %s", k, err)
+		}
+		p := e.Group(fmt.Sprintf("/pypi/%s", k))
+		p.GET("/simple/:name/", handlers.PypiGroupSimple(k)).Name = fmt.Sprintf("pypi::%s::simple", k)
+		p.GET("/packages/:name/:filename", handlers.PypiGroupPackages(k)).Name = fmt.Sprintf("pypi::%s::packages", k)
+	}
+
+	for k, v := range cfg.Server.RUBYGEMS {
+		if err := v.Validate(); err != nil {
+			log.Fatalf("[RUBYGEMS] Wrong config definition for [%s]: %s", k, err)
+		}
+		registerRepo("rubygems", k, v)
+		if v.IsHosted() || v.IsDir() {
+			log.Fatalf("[RUBYGEMS] Wrong config definition for [%s], only url param is supported.", k)
+		}
 		r := e.Group(fmt.Sprintf("/rubygems/%s", k))
 		r.GET("/*", handlers.RubyGems(k)).Name = fmt.Sprintf("rubygems::%s", k)
 	}
 
-	for k := range cfg.Server.Static {
+	for k, v := range cfg.Server.Static {
 		s := e.Group(fmt.Sprintf("/static/%s", k))
+		if err := v.Validate(); err != nil {
+			log.Fatalf("[STATIC] Wrong config definition for [%s]: %s", k, err)
+		}
+		registerRepo("static", k, v)
+		if v.IsDir() {
+			log.Fatalf("[STATIC] Wrong config definition for [%s], dir param is not supported.", k)
+		}
+		if v.IsHosted() {
+			s.GET("/get/*", handlers.StaticHostedGet(k)).Name = fmt.Sprintf("static::%s::get", k)
+			s.GET("/list", handlers.StaticHostedList(k)).Name = fmt.Sprintf("static::%s::list", k)
+			if len(v.UploadTokens) == 0 {
+				zap.S().Warnf("[STATIC] Hosted repo [%s] has no upload_tokens, uploads and deletes are disabled.", k)
+				continue
+			}
+			tokens, err := readTokens(v.UploadTokens)
+			if err != nil {
+				log.Fatalf("[STATIC] Wrong config definition for [%s]: %s", k, err)
+			}
+			auth := handlers.BearerAuth(fmt.Sprintf("hub static %s", k), tokens)
+			s.PUT("/get/*", handlers.StaticHostedPut(k), auth).Name = fmt.Sprintf("static::%s::put", k)
+			s.DELETE("/get/*", handlers.StaticHostedDelete(k), auth).Name = fmt.Sprintf("static::%s::delete", k)
+			continue
+		}
 		s.GET("/get/*", handlers.Static(k)).Name = fmt.Sprintf("static::%s", k)
 	}
 
-	for k := range cfg.Server.GOPROXY {
+	for k, v := range cfg.Server.GOPROXY {
+		if err := v.Validate(); err != nil {
+			log.Fatalf("[GOPROXY] Wrong config definition for [%s]: %s", k, err)
+		}
+		registerRepo("goproxy", k, v)
+		if !v.SumDB.Disabled {
+			if _, err := sumdb.ParseVerifier(v.SumDB.VerifierKey()); err != nil {
+				log.Fatalf("[GOPROXY] Wrong sumdb definition for [%s]: %s", k, err)
+			}
+		}
+		if v.IsHosted() || v.IsDir() {
+			log.Fatalf("[GOPROXY] Wrong config definition for [%s], only url param is supported.", k)
+		}
 		g := e.Group(fmt.Sprintf("/goproxy/%s", k))
 		g.GET("/*", func(c echo.Context) error {
 			path := c.Param("*")
@@ -200,15 +457,41 @@ func startServer(c *cli.Context) error {
 		}).Name = fmt.Sprintf("goproxy::%s", k)
 	}
 
-	for k := range cfg.Server.NPM {
+	for k, v := range cfg.Server.NPM {
 		n := e.Group(fmt.Sprintf("/npm/%s", k))
+		if err := v.Validate(); err != nil {
+			log.Fatalf("[NPM] Wrong config definition for [%s]: %s", k, err)
+		}
+		registerRepo("npm", k, v)
+		if v.IsHosted() {
+			log.Fatalf("[NPM] Wrong config definition for [%s], hosted mode is not supported.", k)
+		}
+		if v.IsDir() {
+			n.GET("/*", handlers.NpmLocal(k)).Name = fmt.Sprintf("npm::%s", k)
+			continue
+		}
 		n.GET("/*", handlers.NpmProxy(k)).Name = fmt.Sprintf("npm::%s", k)
 	}
 
+	for k, v := range cfg.Server.Groups.NPM {
+		if _, ok := cfg.Server.NPM[k]; ok {
+			log.Fatalf("[NPM] Group [%s] clashes with a repo of the same name.", k)
+		}
+		if err := v.Validate(repoKeys(cfg.Server.NPM)); err != nil {
+			log.Fatalf("[NPM] Wrong group definition for [%s]: %s", k, err)
+		}
+		n := e.Group(fmt.Sprintf("/npm/%s", k))
+		n.GET("/*", handlers.NpmGroup(k)).Name = fmt.Sprintf("npm::%s", k)
+	}
+
 	for k, v := range cfg.Server.Galaxy {
 		g := e.Group(fmt.Sprintf("/galaxy/%s", k))
-		if v.URL != "" && v.Dir != "" {
-			log.Fatalf("[GALAXY] Wrong config definition for [%s], please don't use url and dir params together.", k)
+		if err := v.Validate(); err != nil {
+			log.Fatalf("[GALAXY] Wrong config definition for [%s]: %s", k, err)
+		}
+		registerRepo("galaxy", k, v)
+		if v.IsHosted() {
+			log.Fatalf("[GALAXY] Wrong config definition for [%s], hosted mode is not supported.", k)
 		}
 		g.Any("", func(c echo.Context) error {
 			return c.String(http.StatusOK, "")
@@ -233,8 +516,351 @@ func startServer(c *cli.Context) error {
 		}
 	}
 
+	if err := index.Open(cfg.Cache.IndexPath(cfg.Dir)); err != nil {
+		log.Fatalf("[INDEX] Opening cache index error: %v", err)
+	}
+	repos := []string{}
+	for _, prefix := range handlers.IndexPrefixes(cfg) {
+		repos = append(repos, strings.TrimSuffix(prefix, "/"))
+	}
+	index.RegisterMetrics(repos)
+	evictor := quota.New(store, cfg, zap.S().Named("quota"))
+	go func() {
+		if cfg.Cache.ReconcileOnStart() {
+			reconcileIndex(store, handlers.IndexPrefixes(cfg))
+		}
+		if evictor != nil {
+			evictor.Run(cfg.Cache.Interval())
+		}
+	}()
+
+	server := selfURL(c.String("bind"))
+	for ecosystem, list := range map[string]map[string]types.Repo{
+		"pypi":    cfg.Server.PYPI,
+		"npm":     cfg.Server.NPM,
+		"goproxy": cfg.Server.GOPROXY,
+	} {
+		for k, v := range list {
+			if !v.Mirror.Enabled() {
+				continue
+			}
+			if v.Offline {
+				zap.S().Named("mirror").Warnf("Not mirroring %s/%s, the repo is offline", ecosystem, k)
+				continue
+			}
+			if err := warm.StartMirror(server, ecosystem, k, v.Mirror, zap.S().Named("mirror")); err != nil {
+				log.Fatalf("[%s] Wrong mirror definition for [%s]: %s", strings.ToUpper(ecosystem), k, err)
+			}
+		}
+	}
+
 	go func() {
 		log.Fatal(e.Start(c.String("bind")))
 	}()
 	return victoriametrics.ListenMetricsServer(c.String("self-exporter-bind"))
 }
+
+// reconcileIndex picks up the objects below prefixes that the cache index
+// doesn't know of and drops the entries of removed ones.
+func reconcileIndex(store storage.Backend, prefixes []string) {
+	indexLogger := zap.S().Named("index")
+	added, removed, err := misc.ReconcileIndex(store, prefixes)
+	if err != nil {
+		indexLogger.Errorf("Reconciling cache index error: %s", err)
+		return
+	}
+	indexLogger.Infof("Cache index reconciled, %d entries added, %d removed", added, removed)
+}
+
+// scrubCache checks every cached artifact below the optional prefix against
+// its recorded digests. Artifacts without digests get them recorded.
+func scrubCache(c *cli.Context) error {
+	cfg.Load(c.String("config"))
+	logger := logging.Build(c.Bool("verbose"))
+	zap.ReplaceGlobals(logger)
+
+	store, err := storage.New(cfg)
+	if err != nil {
+		log.Fatalf("[STORAGE] Wrong config definition: %v", err)
+	}
+	result, err := misc.Scrub(store, storage.CleanKey(c.Args().First()), scrubScope(cfg))
+	if err != nil {
+		return err
+	}
+	zap.S().Named("scrub").Infof("Checked %d artifacts, recorded digests of %d, removed %d corrupt, kept %d corrupt", result.Checked, result.Recorded, len(result.Corrupt), len(result.Kept))
+	failures := []string{}
+	if len(result.Corrupt) > 0 {
+		failures = append(failures, fmt.Sprintf("corrupt artifacts removed: %s", strings.Join(result.Corrupt, ", ")))
+	}
+	if len(result.Kept) > 0 {
+		failures = append(failures, fmt.Sprintf("corrupt artifacts without upstream kept: %s", strings.Join(result.Kept, ", ")))
+	}
+	if len(failures) > 0 {
+		return cli.Exit(strings.Join(failures, "; "), 1)
+	}
+	return nil
+}
+
+// scrubScope lets scrub remove corrupt objects of proxy repos only. Hosted
+// uploads have no other copy, and the directories of dir mode repos inside
+// the cache are not cache content at all.
+func scrubScope(cfg types.ConfigFile) misc.ScrubScope {
+	scope := misc.ScrubScope{}
+	for _, repo := range quota.Repos(cfg) {
+		scope.Refetch = append(scope.Refetch, repo.Prefix+"/")
+	}
+	for _, list := range []map[string]types.Repo{cfg.Server.PYPI, cfg.Server.NPM, cfg.Server.Static, cfg.Server.GOPROXY, cfg.Server.RUBYGEMS, cfg.Server.Galaxy} {
+		for _, v := range list {
+			if !v.IsDir() {
+				continue
+			}
+			rel, err := filepath.Rel(cfg.Dir, v.Dir)
+			if err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+				scope.Skip = append(scope.Skip, filepath.ToSlash(rel)+"/")
+			}
+		}
+	}
+	return scope
+}
+
+// warmCache fetches the artifacts of the lockfiles given as arguments
+// through the repos named by the ecosystem flags.
+func warmCache(c *cli.Context) error {
+	logger := logging.Build(c.Bool("verbose"))
+	zap.ReplaceGlobals(logger)
+	if c.NArg() == 0 {
+		return cli.Exit("no lockfiles given", 2)
+	}
+
+	repos := map[string]string{}
+	for _, ecosystem := range []string{"pypi", "npm", "goproxy", "rubygems", "galaxy"} {
+		repos[ecosystem] = c.String(ecosystem)
+	}
+	warmLogger := zap.S().Named("warm")
+	report, err := warm.Run(warm.Options{
+		Server:      c.String("server"),
+		Repos:       repos,
+		Concurrency: c.Int("concurrency"),
+		Timeout:     c.Duration("timeout"),
+		Logger:      warmLogger,
+	}, c.Args().Slice())
+	if err != nil {
+		return err
+	}
+	for _, s := range report.Skipped {
+		warmLogger.Warnf("Skipped %s", s)
+	}
+	warmLogger.Info(report.String())
+	if len(report.Failed) > 0 {
+		return cli.Exit(fmt.Sprintf("failed to fetch: %s", strings.Join(report.Failed, ", ")), 1)
+	}
+	return nil
+}
+
+// generateBundleKey writes the key pair of bundles to name.key and
+// name.pub.
+func generateBundleKey(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return cli.Exit("give the name of the key files", 2)
+	}
+	if err := bundle.GenerateKey(c.Args().First()); err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	return nil
+}
+
+// exportBundle writes the selected artifacts to the bundle given as
+// argument, along with its sha256 in a .sha256 file next to it.
+func exportBundle(c *cli.Context) error {
+	cfg.Load(c.String("config"))
+	logger := logging.Build(c.Bool("verbose"))
+	zap.ReplaceGlobals(logger)
+	if c.NArg() != 1 {
+		return cli.Exit("give the path of the bundle", 2)
+	}
+	exportLogger := zap.S().Named("export")
+
+	key, err := bundle.LoadPrivateKey(c.String("key"))
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	sel := bundle.Selection{Repos: c.StringSlice("repo")}
+	for _, s := range c.StringSlice("package") {
+		p, err := bundle.ParsePackage(s)
+		if err != nil {
+			return cli.Exit(err.Error(), 2)
+		}
+		sel.Packages = append(sel.Packages, p)
+	}
+	if lockfiles := c.StringSlice("lockfile"); len(lockfiles) > 0 {
+		packages, skipped, err := warm.Packages(lockfiles)
+		if err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+		for _, s := range skipped {
+			exportLogger.Warnf("Skipped %s", s)
+		}
+		if len(packages) == 0 {
+			return cli.Exit("no packages in the lockfiles", 1)
+		}
+		sel.Packages = append(sel.Packages, packages...)
+	}
+	now := time.Now()
+	for flag, t := range map[string]*time.Time{"since": &sel.Since, "until": &sel.Until} {
+		if c.String(flag) == "" {
+			continue
+		}
+		if *t, err = bundle.ParseTime(c.String(flag), now); err != nil {
+			return cli.Exit(fmt.Sprintf("--%s: %s", flag, err), 2)
+		}
+	}
+
+	store, err := storage.New(cfg)
+	if err != nil {
+		log.Fatalf("[STORAGE] Wrong config definition: %v", err)
+	}
+	if err := index.Load(cfg.Cache.IndexPath(cfg.Dir)); err != nil {
+		exportLogger.Warnf("Reading the cache index error, upstream URLs are not exported: %s", err)
+	}
+
+	path := c.Args().First()
+	f, err := os.Create(filepath.Clean(path))
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	h := sha256.New()
+	manifest, err := bundle.Export(store, io.MultiWriter(f, h), sel, key)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return cli.Exit(fmt.Sprintf("export failed: %s", err), 1)
+	}
+	if len(manifest.Objects) == 0 {
+		exportLogger.Warn("No cached artifacts match the selection")
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if err := os.WriteFile(path+".sha256", fmt.Appendf(nil, "%s  %s\n", sum, filepath.Base(path)), 0o644); err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	var size int64
+	for _, o := range manifest.Objects {
+		size += o.Size
+	}
+	exportLogger.Infof("Exported %d artifacts (%d bytes) to %s, sha256 %s", len(manifest.Objects), size, path, sum)
+	return nil
+}
+
+// importBundle merges the bundles given as arguments into the cache.
+func importBundle(c *cli.Context) error {
+	cfg.Load(c.String("config"))
+	logger := logging.Build(c.Bool("verbose"))
+	zap.ReplaceGlobals(logger)
+	if c.NArg() == 0 {
+		return cli.Exit("no bundles given", 2)
+	}
+	importLogger := zap.S().Named("import")
+
+	key, err := bundle.LoadPublicKey(c.String("pubkey"))
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	store, err := storage.New(cfg)
+	if err != nil {
+		log.Fatalf("[STORAGE] Wrong config definition: %v", err)
+	}
+	if err := index.Open(cfg.Cache.IndexPath(cfg.Dir)); err != nil {
+		return cli.Exit(fmt.Sprintf("opening the cache index: %s", err), 1)
+	}
+	defer func() {
+		if err := index.Close(); err != nil {
+			importLogger.Errorf("Writing the cache index error: %s", err)
+		}
+	}()
+
+	var corrupt []string
+	for _, path := range c.Args().Slice() {
+		f, err := os.Open(filepath.Clean(path))
+		if err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+		result, err := bundle.Import(store, f, key)
+		f.Close()
+		if err != nil {
+			return cli.Exit(fmt.Sprintf("%s: %s", path, err), 1)
+		}
+		for _, k := range result.Kept {
+			importLogger.Warnf("Kept %s, the cached copy is newer", k)
+		}
+		importLogger.Infof("%s: imported %d artifacts, %d duplicates, %d kept, %d corrupt", path, result.Imported, result.Duplicates, len(result.Kept), len(result.Corrupt))
+		corrupt = append(corrupt, result.Corrupt...)
+	}
+	if len(corrupt) > 0 {
+		return cli.Exit(fmt.Sprintf("corrupt artifacts not imported: %s", strings.Join(corrupt, ", ")), 1)
+	}
+	return nil
+}
+
+// selfURL returns the URL the server listening on bind is reached at
+// locally.
+func selfURL(bind string) string {
+	host, port, err := net.SplitHostPort(bind)
+	if err != nil {
+		return "http://" + bind
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// readTokens reads the secrets of bearer tokens, none of them may be empty.
+func readTokens(secrets []types.Secret) ([]string, error) {
+	tokens := []string{}
+	for _, secret := range secrets {
+		token, err := secret.Read()
+		if err != nil {
+			return nil, err
+		}
+		if token == "" {
+			return nil, fmt.Errorf("tokens can't be empty")
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// registerRepo passes the per-repo upstream settings to misc.
+func registerRepo(ecosystem, k string, v types.Repo) {
+	repo := fmt.Sprintf("%s/%s", ecosystem, k)
+	if v.Mirror.Enabled() && ecosystem != "pypi" && ecosystem != "npm" && ecosystem != "goproxy" {
+		log.Fatalf("[%s] Wrong config definition for [%s], only pypi, npm and goproxy repos can be mirrored.", strings.ToUpper(ecosystem), k)
+	}
+	if err := misc.SetClient(repo, v.Client); err != nil {
+		log.Fatalf("[%s] Wrong client definition for [%s]: %s", strings.ToUpper(ecosystem), k, err)
+	}
+	if err := misc.SetAuth(repo, v.Upstreams(), v.Auth); err != nil {
+		log.Fatalf("[%s] Wrong auth definition for [%s]: %s", strings.ToUpper(ecosystem), k, err)
+	}
+	misc.SetNegativeTTL(repo, v.Policy.NegativeCacheTTL())
+	misc.SetOffline(repo, v.Offline)
+	misc.SetUpstreams(repo, v.Upstreams())
+	if len(v.Upstreams()) > 1 && !v.Offline {
+		go misc.CheckUpstreams(repo, v.HealthCheck.Path, v.HealthCheck.Every())
+	}
+}
+
+func repoKeys[V any](repos map[string]V) map[string]bool {
+	keys := make(map[string]bool, len(repos))
+	for k := range repos {
+		keys[k] = true
+	}
+	return keys
+}
+
+func validateRules(ecosystem string, rules types.RoutingRules, repos map[string]bool) {
+	if err := rules.Validate(repos); err != nil {
+		log.Fatalf("[RULES] Wrong %s rules definition: %s", ecosystem, err)
+	}
+}
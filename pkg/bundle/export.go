@@ -0,0 +1,107 @@
+package bundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/psvmcc/hub/pkg/index"
+	"github.com/psvmcc/hub/pkg/misc"
+	"github.com/psvmcc/hub/pkg/storage"
+)
+
+var errChanged = errors.New("changed while exporting")
+
+// Export writes a bundle of the objects sel picks to w, signed with key.
+// Digests recorded by the cache are used for the manifest, every object is
+// hashed again while it's written, so an object that changed meanwhile
+// fails the export instead of producing a bundle that can't be imported.
+func Export(store storage.Backend, w io.Writer, sel Selection, key ed25519.PrivateKey) (Manifest, error) {
+	manifest := Manifest{Version: formatVersion, Created: time.Now().UTC(), Objects: []Object{}}
+	seen := map[string]bool{}
+	for _, prefix := range sel.prefixes() {
+		err := store.Walk(prefix, func(info storage.ObjectInfo) error {
+			if misc.IsHiddenKey(info.Key) || seen[info.Key] || !sel.matches(info.Key, info.ModTime) {
+				return nil
+			}
+			seen[info.Key] = true
+			sum, err := misc.ObjectDigest(store, info.Key, "sha256")
+			if err != nil {
+				return err
+			}
+			o := Object{Key: info.Key, Size: info.Size, SHA256: sum, ModTime: info.ModTime.UTC()}
+			if v, err := misc.ReadValidators(store, info.Key); err == nil {
+				o.ETag, o.LastModified = v.ETag, v.LastModified
+			}
+			if e, ok := index.Get(info.Key); ok {
+				o.URL = e.URL
+			}
+			manifest.Objects = append(manifest.Objects, o)
+			return nil
+		})
+		if err != nil {
+			return manifest, err
+		}
+	}
+	sort.Slice(manifest.Objects, func(i, j int) bool { return manifest.Objects[i].Key < manifest.Objects[j].Key })
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	if err := writeEntry(tw, manifestName, data, manifest.Created); err != nil {
+		return manifest, err
+	}
+	if err := writeEntry(tw, signatureName, ed25519.Sign(key, data), manifest.Created); err != nil {
+		return manifest, err
+	}
+	for _, o := range manifest.Objects {
+		if err := writeObject(store, tw, o); err != nil {
+			return manifest, fmt.Errorf("%s: %w", o.Key, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return manifest, err
+	}
+	return manifest, zw.Close()
+}
+
+func writeEntry(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: modTime}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+func writeObject(store storage.Backend, tw *tar.Writer, o Object) error {
+	obj, info, err := store.Open(o.Key)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+	if info.Size != o.Size {
+		return errChanged
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: objectsDir + o.Key, Mode: 0o644, Size: o.Size, ModTime: o.ModTime}); err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err := io.CopyN(io.MultiWriter(tw, h), obj, o.Size); err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != o.SHA256 {
+		return errChanged
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package bundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/psvmcc/hub/pkg/misc"
+	"github.com/psvmcc/hub/pkg/storage"
+)
+
+// maxManifestSize bounds the manifest read before its signature is checked.
+const maxManifestSize = 256 << 20
+
+// ImportResult is the outcome of an import.
+type ImportResult struct {
+	Imported int
+	// Duplicates counts objects that are cached already with the same
+	// content.
+	Duplicates int
+	// Kept lists objects that are cached with other content fetched later
+	// than the one in the bundle, so the local copy stays.
+	Kept []string
+	// Corrupt lists objects whose content doesn't match the manifest and
+	// objects of the manifest missing in the bundle. None of them are
+	// imported.
+	Corrupt []string
+}
+
+// Import merges the bundle read from r into store. The manifest must be
+// signed by key, objects are only committed if they match its digests.
+func Import(store storage.Backend, r io.Reader, key ed25519.PublicKey) (ImportResult, error) {
+	var result ImportResult
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return result, err
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+
+	data, err := readEntry(tr, manifestName, maxManifestSize)
+	if err != nil {
+		return result, err
+	}
+	signature, err := readEntry(tr, signatureName, ed25519.SignatureSize)
+	if err != nil {
+		return result, err
+	}
+	if !ed25519.Verify(key, data, signature) {
+		return result, ErrSignature
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return result, fmt.Errorf("reading %s: %w", manifestName, err)
+	}
+	if manifest.Version != formatVersion {
+		return result, fmt.Errorf("unsupported bundle version %d", manifest.Version)
+	}
+	pending := map[string]Object{}
+	for _, o := range manifest.Objects {
+		if storage.CleanKey(o.Key) != o.Key || misc.IsHiddenKey(o.Key) || !strings.Contains(o.Key, "/") {
+			return result, fmt.Errorf("invalid key %q in %s", o.Key, manifestName)
+		}
+		pending[o.Key] = o
+	}
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+		key, ok := strings.CutPrefix(header.Name, objectsDir)
+		o, listed := pending[key]
+		if !ok || !listed {
+			return result, fmt.Errorf("%s is not listed in %s", header.Name, manifestName)
+		}
+		delete(pending, key)
+		if header.Size != o.Size {
+			result.Corrupt = append(result.Corrupt, key)
+			continue
+		}
+
+		info, err := store.Stat(key)
+		switch {
+		case err == nil:
+			sum, err := misc.ObjectDigest(store, key, "sha256")
+			if err != nil {
+				return result, err
+			}
+			if strings.EqualFold(sum, o.SHA256) {
+				result.Duplicates++
+				continue
+			}
+			if info.ModTime.After(o.ModTime) {
+				result.Kept = append(result.Kept, key)
+				continue
+			}
+		case !errors.Is(err, fs.ErrNotExist):
+			return result, err
+		}
+
+		v := misc.Validators{ETag: o.ETag, LastModified: o.LastModified}
+		err = misc.ImportObject(store, tr, key, o.SHA256, o.ModTime, o.URL, v)
+		if errors.Is(err, misc.ErrChecksumMismatch) {
+			result.Corrupt = append(result.Corrupt, key)
+			continue
+		}
+		if err != nil {
+			return result, fmt.Errorf("%s: %w", key, err)
+		}
+		result.Imported++
+	}
+	for key := range pending {
+		result.Corrupt = append(result.Corrupt, key)
+	}
+	sort.Strings(result.Corrupt)
+	return result, nil
+}
+
+// readEntry reads the next entry of tr, which must be name and at most
+// limit bytes.
+func readEntry(tr *tar.Reader, name string, limit int64) ([]byte, error) {
+	header, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", name, err)
+	}
+	if header.Name != name {
+		return nil, fmt.Errorf("expected %s, found %s", name, header.Name)
+	}
+	if header.Size > limit {
+		return nil, fmt.Errorf("%s is larger than %d bytes", name, limit)
+	}
+	return io.ReadAll(tr)
+}
@@ -0,0 +1,207 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/psvmcc/hub/pkg/storage"
+)
+
+// testBundle describes a bundle to write. Objects are the entries below
+// objects/, which may differ from the manifest.
+type testBundle struct {
+	manifest Manifest
+	objects  map[string]string
+	signer   ed25519.PrivateKey
+	// unsigned leaves out the signature entry.
+	unsigned bool
+	// tamper changes the manifest after it was signed.
+	tamper func(data []byte) []byte
+}
+
+func (b testBundle) write(t *testing.T) []byte {
+	t.Helper()
+	data, err := json.Marshal(b.manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := ed25519.Sign(b.signer, data)
+	if b.tamper != nil {
+		data = b.tamper(data)
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	if err := writeEntry(tw, manifestName, data, b.manifest.Created); err != nil {
+		t.Fatal(err)
+	}
+	if !b.unsigned {
+		if err := writeEntry(tw, signatureName, signature, b.manifest.Created); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys := make([]string, 0, len(b.objects))
+	for key := range b.objects {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		if err := writeEntry(tw, objectsDir+key, []byte(b.objects[key]), b.manifest.Created); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testObject(key, content string, modTime time.Time) Object {
+	sum := sha256.Sum256([]byte(content))
+	return Object{Key: key, Size: int64(len(content)), SHA256: hex.EncodeToString(sum[:]), ModTime: modTime}
+}
+
+func TestImport(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const key = "npm/npmjs/left-pad/-/left-pad-1.3.0.tgz"
+	fetched := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	valid := Manifest{Version: formatVersion, Created: fetched, Objects: []Object{testObject(key, "tarball", fetched)}}
+
+	tests := []struct {
+		name   string
+		bundle testBundle
+		// cached is stored under key before the import, fetched at
+		// cachedAt.
+		cached   string
+		cachedAt time.Time
+		wantErr  error
+		want     ImportResult
+		// wantContent is the content of key after the import, empty for
+		// none.
+		wantContent string
+	}{
+		{
+			name:        "imported",
+			bundle:      testBundle{manifest: valid, objects: map[string]string{key: "tarball"}, signer: priv},
+			want:        ImportResult{Imported: 1},
+			wantContent: "tarball",
+		},
+		{
+			name:    "other signer",
+			bundle:  testBundle{manifest: valid, objects: map[string]string{key: "tarball"}, signer: other},
+			wantErr: ErrSignature,
+		},
+		{
+			name: "tampered manifest",
+			bundle: testBundle{manifest: valid, objects: map[string]string{key: "tarball"}, signer: priv, tamper: func(data []byte) []byte {
+				return bytes.Replace(data, []byte(`"size":7`), []byte(`"size":8`), 1)
+			}},
+			wantErr: ErrSignature,
+		},
+		{
+			name:   "unsigned",
+			bundle: testBundle{manifest: valid, objects: map[string]string{key: "tarball"}, signer: priv, unsigned: true},
+		},
+		{
+			name:   "corrupt object",
+			bundle: testBundle{manifest: valid, objects: map[string]string{key: "tarbalL"}, signer: priv},
+			want:   ImportResult{Corrupt: []string{key}},
+		},
+		{
+			name:   "truncated object",
+			bundle: testBundle{manifest: valid, objects: map[string]string{key: "tar"}, signer: priv},
+			want:   ImportResult{Corrupt: []string{key}},
+		},
+		{
+			name:   "missing object",
+			bundle: testBundle{manifest: valid, signer: priv},
+			want:   ImportResult{Corrupt: []string{key}},
+		},
+		{
+			name:        "cached later",
+			bundle:      testBundle{manifest: valid, objects: map[string]string{key: "tarball"}, signer: priv},
+			cached:      "republished",
+			cachedAt:    fetched.Add(time.Hour),
+			want:        ImportResult{Kept: []string{key}},
+			wantContent: "republished",
+		},
+		{
+			name:        "cached earlier",
+			bundle:      testBundle{manifest: valid, objects: map[string]string{key: "tarball"}, signer: priv},
+			cached:      "republished",
+			cachedAt:    fetched.Add(-time.Hour),
+			want:        ImportResult{Imported: 1},
+			wantContent: "tarball",
+		},
+		{
+			name:        "cached already",
+			bundle:      testBundle{manifest: valid, objects: map[string]string{key: "tarball"}, signer: priv},
+			cached:      "tarball",
+			cachedAt:    fetched,
+			want:        ImportResult{Duplicates: 1},
+			wantContent: "tarball",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storage.NewFS(t.TempDir())
+			if tt.cached != "" {
+				w, err := store.Create(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := w.Write([]byte(tt.cached)); err != nil {
+					t.Fatal(err)
+				}
+				if err := w.Commit(tt.cachedAt); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			result, err := Import(store, bytes.NewReader(tt.bundle.write(t)), pub)
+			switch {
+			case tt.bundle.unsigned:
+				if err == nil {
+					t.Fatal("Import of an unsigned bundle succeeded")
+				}
+			case !errors.Is(err, tt.wantErr):
+				t.Fatalf("Import error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if result.Imported != tt.want.Imported || result.Duplicates != tt.want.Duplicates ||
+					!slices.Equal(result.Kept, tt.want.Kept) || !slices.Equal(result.Corrupt, tt.want.Corrupt) {
+					t.Errorf("Import = %+v, want %+v", result, tt.want)
+				}
+			}
+
+			content, err := storage.ReadFile(store, key)
+			switch {
+			case tt.wantContent == "" && err == nil:
+				t.Errorf("%s was stored: %q", key, content)
+			case tt.wantContent != "" && string(content) != tt.wantContent:
+				t.Errorf("%s = %q (%v), want %q", key, content, err, tt.wantContent)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package bundle
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/psvmcc/hub/pkg/types"
+	"github.com/psvmcc/hub/pkg/warm"
+)
+
+// Selection picks the objects of an export. Objects must match every
+// criterion that is set.
+type Selection struct {
+	// Repos are repos like pypi/pypi, every repo when empty.
+	Repos []string
+	// Packages limits the export to the metadata and artifacts of these
+	// packages, all of their cached versions when Version is empty.
+	Packages []warm.Package
+	// Since and Until limit the export to objects fetched or revalidated
+	// in that window.
+	Since time.Time
+	Until time.Time
+}
+
+// prefixes returns the key prefixes to walk.
+func (s Selection) prefixes() []string {
+	if len(s.Repos) == 0 {
+		return []string{""}
+	}
+	prefixes := make([]string, 0, len(s.Repos))
+	for _, repo := range s.Repos {
+		prefixes = append(prefixes, strings.Trim(repo, "/")+"/")
+	}
+	return prefixes
+}
+
+func (s Selection) matches(key string, modTime time.Time) bool {
+	if !s.Since.IsZero() && modTime.Before(s.Since) {
+		return false
+	}
+	if !s.Until.IsZero() && modTime.After(s.Until) {
+		return false
+	}
+	if len(s.Packages) == 0 {
+		return true
+	}
+	for _, p := range s.Packages {
+		if packageMatches(p, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsePackage reads a package given like name, name@version or
+// name==version, optionally prefixed by its ecosystem like npm:left-pad.
+func ParsePackage(s string) (warm.Package, error) {
+	var p warm.Package
+	if ecosystem, rest, ok := strings.Cut(s, ":"); ok {
+		p.Ecosystem, s = ecosystem, rest
+	}
+	if name, version, ok := strings.Cut(s, "=="); ok {
+		p.Name, p.Version = name, version
+	} else if i := strings.LastIndex(s, "@"); i > 0 {
+		// Scoped npm packages start with @.
+		p.Name, p.Version = s[:i], s[i+1:]
+	} else {
+		p.Name = s
+	}
+	if p.Name == "" {
+		return p, fmt.Errorf("no package name in %q", s)
+	}
+	return p, nil
+}
+
+// ParseTime reads a point in time given as a date, an RFC 3339 time or a
+// duration before now like 72h.
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is neither a date, an RFC 3339 time nor a duration", s)
+}
+
+// packageMatches reports whether key is the metadata of p or an artifact
+// of the version of p.
+func packageMatches(p warm.Package, key string) bool {
+	cached, ok := types.ParseCacheKey(key)
+	if !ok {
+		// The compact index lists every gem, bundler needs it for any.
+		parts := strings.Split(key, "/")
+		return len(parts) == 3 && parts[0] == "rubygems" && parts[2] == "versions" && (p.Ecosystem == "" || p.Ecosystem == "rubygems")
+	}
+	if p.Ecosystem != "" && p.Ecosystem != cached.Ecosystem || !types.SamePackage(cached.Ecosystem, cached.Name, p.Name) {
+		return false
+	}
+	return cached.Version == "" || p.Version == "" || cached.Version == p.Version
+}
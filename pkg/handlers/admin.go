@@ -0,0 +1,387 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/psvmcc/hub/pkg/index"
+	"github.com/psvmcc/hub/pkg/misc"
+	"github.com/psvmcc/hub/pkg/storage"
+	"github.com/psvmcc/hub/pkg/types"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+func adminRepoConfigs(cfg types.ConfigFile) map[string]map[string]types.Repo {
+	return map[string]map[string]types.Repo{
+		"pypi":     cfg.Server.PYPI,
+		"npm":      cfg.Server.NPM,
+		"static":   cfg.Server.Static,
+		"goproxy":  cfg.Server.GOPROXY,
+		"rubygems": cfg.Server.RUBYGEMS,
+		"galaxy":   cfg.Server.Galaxy,
+	}
+}
+
+// IndexPrefixes returns the cache prefixes of the repos whose objects are
+// kept in the cache index, that is every repo but dir mode ones.
+func IndexPrefixes(cfg types.ConfigFile) []string {
+	prefixes := []string{}
+	for ecosystem, list := range adminRepoConfigs(cfg) {
+		for k, v := range list {
+			if !v.IsDir() {
+				prefixes = append(prefixes, fmt.Sprintf("%s/%s/", ecosystem, k))
+			}
+		}
+	}
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+// AdminRepos lists every repo with the number and size of its cached
+// objects.
+func AdminRepos() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		cfg := c.Get("cfg").(types.ConfigFile)
+		repos := []types.AdminRepo{}
+		for ecosystem, list := range adminRepoConfigs(cfg) {
+			for k, v := range list {
+				repo := types.AdminRepo{
+					Repo:      fmt.Sprintf("%s/%s", ecosystem, k),
+					Ecosystem: ecosystem,
+					Name:      k,
+					Mode:      types.RepoModeProxy,
+					Upstreams: v.Upstreams(),
+					Dir:       v.Dir,
+					Offline:   v.Offline,
+					MaxSize:   int64(v.MaxSize),
+				}
+				switch {
+				case v.IsHosted():
+					repo.Mode = types.RepoModeHosted
+				case v.IsDir():
+					repo.Mode = "dir"
+				}
+				repo.Objects, repo.Size = index.Usage(repo.Repo)
+				repos = append(repos, repo)
+			}
+		}
+		sort.Slice(repos, func(i, j int) bool { return repos[i].Repo < repos[j].Repo })
+		return c.JSON(http.StatusOK, repos)
+	}
+}
+
+// adminRepo returns the cache prefix of the repo of the request, false
+// when there is no such repo.
+func adminRepo(c echo.Context) (string, bool) {
+	cfg := c.Get("cfg").(types.ConfigFile)
+	ecosystem, key := c.Param("ecosystem"), c.Param("repo")
+	if _, ok := adminRepoConfigs(cfg)[ecosystem][key]; !ok {
+		return "", false
+	}
+	return fmt.Sprintf("%s/%s/", ecosystem, key), true
+}
+
+// adminPath returns the unescaped wildcard of the route.
+func adminPath(c echo.Context) (string, bool) {
+	p, err := url.PathUnescape(c.Param("*"))
+	return p, err == nil && p != ""
+}
+
+// AdminPackages lists the packages cached in a repo with their versions,
+// optionally only those whose name matches the glob in ?name=.
+func AdminPackages() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		prefix, ok := adminRepo(c)
+		if !ok {
+			return c.String(http.StatusNotFound, "Repo not found")
+		}
+		glob := c.QueryParam("name")
+		if _, err := path.Match(glob, ""); err != nil {
+			return c.String(http.StatusBadRequest, fmt.Sprintf("Invalid name glob: %s", err))
+		}
+		packages := map[string]*types.AdminPackage{}
+		versions := map[string]map[string]*types.AdminVersion{}
+		for _, e := range index.List(prefix) {
+			p, ok := types.ParseCacheKey(e.Key)
+			if !ok {
+				continue
+			}
+			if glob != "" {
+				if match, _ := path.Match(glob, p.Name); !match {
+					continue
+				}
+			}
+			pkg := packages[p.Name]
+			if pkg == nil {
+				pkg = &types.AdminPackage{Name: p.Name}
+				packages[p.Name] = pkg
+				versions[p.Name] = map[string]*types.AdminVersion{}
+			}
+			addAdminUsage(pkg, versions[p.Name], p.Version, e)
+		}
+		list := make([]types.AdminPackage, 0, len(packages))
+		for name, pkg := range packages {
+			list = append(list, finishAdminPackage(pkg, versions[name]))
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+		return c.JSON(http.StatusOK, list)
+	}
+}
+
+// AdminPackage describes one package of a repo along with the keys of its
+// cached objects.
+func AdminPackage() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		prefix, ok := adminRepo(c)
+		if !ok {
+			return c.String(http.StatusNotFound, "Repo not found")
+		}
+		name, ok := adminPath(c)
+		if !ok {
+			return c.String(http.StatusBadRequest, "Bad request")
+		}
+		var pkg *types.AdminPackage
+		versions := map[string]*types.AdminVersion{}
+		for _, e := range index.List(prefix) {
+			p, ok := types.ParseCacheKey(e.Key)
+			if !ok || !types.SamePackage(p.Ecosystem, p.Name, name) {
+				continue
+			}
+			if pkg == nil {
+				pkg = &types.AdminPackage{Name: p.Name}
+			}
+			addAdminUsage(pkg, versions, p.Version, e)
+			pkg.Keys = append(pkg.Keys, e.Key)
+		}
+		if pkg == nil {
+			return c.String(http.StatusNotFound, "Package not found")
+		}
+		return c.JSON(http.StatusOK, finishAdminPackage(pkg, versions))
+	}
+}
+
+// addAdminUsage counts the object of e for the package and, unless it is
+// metadata of every version, for its version.
+func addAdminUsage(pkg *types.AdminPackage, versions map[string]*types.AdminVersion, version string, e index.Entry) {
+	used := e.Used()
+	pkg.Objects++
+	pkg.Size += e.Size
+	if used.After(pkg.LastAccess) {
+		pkg.LastAccess = used
+	}
+	if version == "" {
+		return
+	}
+	v := versions[version]
+	if v == nil {
+		v = &types.AdminVersion{Version: version}
+		versions[version] = v
+	}
+	v.Objects++
+	v.Size += e.Size
+	if used.After(v.LastAccess) {
+		v.LastAccess = used
+	}
+}
+
+// finishAdminPackage adds the versions to pkg, newest first.
+func finishAdminPackage(pkg *types.AdminPackage, versions map[string]*types.AdminVersion) types.AdminPackage {
+	pkg.Versions = make([]types.AdminVersion, 0, len(versions))
+	for _, v := range versions {
+		pkg.Versions = append(pkg.Versions, *v)
+	}
+	sort.Slice(pkg.Versions, func(i, j int) bool {
+		return misc.CompareVersions(pkg.Versions[i].Version, pkg.Versions[j].Version) > 0
+	})
+	return *pkg
+}
+
+// AdminObject describes a cached object.
+func AdminObject() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		store := c.Get("storage").(storage.Backend)
+		key, ok := adminObjectKey(c)
+		if !ok {
+			return c.String(http.StatusBadRequest, "Bad request")
+		}
+		info, err := store.Stat(key)
+		if errors.Is(err, fs.ErrNotExist) {
+			return c.String(http.StatusNotFound, "Object not found")
+		}
+		if err != nil {
+			return adminError(c, "Stat %s error: %s", key, err)
+		}
+		sum, err := misc.ObjectDigest(store, key, "sha256")
+		if err != nil {
+			return adminError(c, "Hashing %s error: %s", key, err)
+		}
+		object := types.AdminObject{Key: key, Size: info.Size, ModTime: info.ModTime.UTC(), SHA256: sum}
+		if p, ok := types.ParseCacheKey(key); ok {
+			object.Package, object.Version = p.Name, p.Version
+		}
+		if v, err := misc.ReadValidators(store, key); err == nil {
+			object.ETag, object.LastModified = v.ETag, v.LastModified
+		}
+		if e, ok := index.Get(key); ok {
+			object.URL, object.ContentType, object.Hits = e.URL, e.ContentType, e.Hits
+			object.FetchedAt, object.LastAccess = e.FetchedAt, e.LastAccess
+		}
+		return c.JSON(http.StatusOK, object)
+	}
+}
+
+// AdminReconcile reconciles the cache index with the storage, for objects
+// written by another replica or removed by hand.
+func AdminReconcile() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		cfg := c.Get("cfg").(types.ConfigFile)
+		store := c.Get("storage").(storage.Backend)
+		logger := c.Get("logger").(*zap.SugaredLogger)
+		added, removed, err := misc.ReconcileIndex(store, IndexPrefixes(cfg))
+		if err != nil {
+			return adminError(c, "Reconciling cache index error: %s", err)
+		}
+		logger.Named("admin").Infof("Cache index reconciled, %d entries added, %d removed, requested from %s", added, removed, c.RealIP())
+		return c.JSON(http.StatusOK, types.AdminReconcile{Added: added, Removed: removed})
+	}
+}
+
+// AdminPurgeObject removes a cached object by its exact key.
+func AdminPurgeObject() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		store := c.Get("storage").(storage.Backend)
+		key, ok := adminObjectKey(c)
+		if !ok {
+			return c.String(http.StatusBadRequest, "Bad request")
+		}
+		if _, err := store.Stat(key); errors.Is(err, fs.ErrNotExist) {
+			// Sidecars and the index entry may outlive their object.
+			if !adminDryRun(c) {
+				_ = misc.RemoveObject(store, key)
+			}
+			return c.String(http.StatusNotFound, "Object not found")
+		} else if err != nil {
+			return adminError(c, "Stat %s error: %s", key, err)
+		}
+		return adminPurge(c, []string{key})
+	}
+}
+
+// AdminPurgeGlob removes the cached objects whose key matches the glob in
+// ?glob=, like npm/main/tarballs/@types/*/-/*.
+func AdminPurgeGlob() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		store := c.Get("storage").(storage.Backend)
+		glob := c.QueryParam("glob")
+		if _, err := path.Match(glob, ""); err != nil || glob == "" {
+			return c.String(http.StatusBadRequest, "Please set a valid glob")
+		}
+		// Only the part of the tree the glob can match is walked.
+		prefix := glob
+		if i := strings.IndexAny(glob, `*?[\`); i >= 0 {
+			prefix = glob[:i]
+		}
+		keys := []string{}
+		err := store.Walk(prefix, func(info storage.ObjectInfo) error {
+			if misc.IsHiddenKey(info.Key) {
+				return nil
+			}
+			if match, _ := path.Match(glob, info.Key); match {
+				keys = append(keys, info.Key)
+			}
+			return nil
+		})
+		if err != nil {
+			return adminError(c, "Listing %s error: %s", prefix, err)
+		}
+		return adminPurge(c, keys)
+	}
+}
+
+// AdminPurgePackage removes the cached artifacts and metadata of a package,
+// or only the artifacts of one version with ?version=.
+func AdminPurgePackage() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		store := c.Get("storage").(storage.Backend)
+		prefix, ok := adminRepo(c)
+		if !ok {
+			return c.String(http.StatusNotFound, "Repo not found")
+		}
+		name, ok := adminPath(c)
+		if !ok {
+			return c.String(http.StatusBadRequest, "Bad request")
+		}
+		version := c.QueryParam("version")
+		keys := []string{}
+		err := store.Walk(prefix, func(info storage.ObjectInfo) error {
+			if misc.IsHiddenKey(info.Key) {
+				return nil
+			}
+			p, ok := types.ParseCacheKey(info.Key)
+			if !ok || !types.SamePackage(p.Ecosystem, p.Name, name) {
+				return nil
+			}
+			if version == "" || p.Version == version {
+				keys = append(keys, info.Key)
+			}
+			return nil
+		})
+		if err != nil {
+			return adminError(c, "Listing %s error: %s", prefix, err)
+		}
+		if len(keys) == 0 {
+			return c.String(http.StatusNotFound, "Package not found")
+		}
+		return adminPurge(c, keys)
+	}
+}
+
+// adminPurge removes keys with their sidecars unless it is a dry run.
+func adminPurge(c echo.Context, keys []string) error {
+	logger := c.Get("logger").(*zap.SugaredLogger)
+	store := c.Get("storage").(storage.Backend)
+	result := types.AdminPurge{Purged: []string{}, DryRun: adminDryRun(c)}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !result.DryRun {
+			if err := misc.RemoveObject(store, key); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				logger.Named("admin").Errorf("Purging %s error: %s", key, err)
+				continue
+			}
+			logger.Named("admin").Infof("Purged %s, requested from %s", key, c.RealIP())
+		}
+		result.Purged = append(result.Purged, key)
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// adminObjectKey returns the cache key of the request. Sidecars in hidden
+// trees are not objects of their own.
+func adminObjectKey(c echo.Context) (string, bool) {
+	p, ok := adminPath(c)
+	if !ok {
+		return "", false
+	}
+	key := storage.CleanKey(p)
+	return key, strings.Count(key, "/") >= 2 && !misc.IsHiddenKey(key)
+}
+
+func adminDryRun(c echo.Context) bool {
+	dryRun, _ := strconv.ParseBool(c.QueryParam("dry_run"))
+	return dryRun
+}
+
+func adminError(c echo.Context, format string, args ...any) error {
+	logger := c.Get("logger").(*zap.SugaredLogger)
+	logger.Named("admin").Errorf(format, args...)
+	return c.String(http.StatusInternalServerError, "Please check logs...")
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// BearerAuth only lets requests through that send one of tokens as bearer
+// token.
+func BearerAuth(realm string, tokens []string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			token, ok := strings.CutPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+			if ok && token != "" {
+				for _, t := range tokens {
+					if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+						return next(c)
+					}
+				}
+			}
+			c.Response().Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer realm=%q", realm))
+			return c.String(http.StatusUnauthorized, "Unauthorized")
+		}
+	}
+}
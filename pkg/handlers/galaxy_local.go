@@ -10,7 +10,6 @@ import (
 	"net/http"
 	"os"
 
-	"github.com/psvmcc/hub/pkg/misc"
 	"github.com/psvmcc/hub/pkg/types"
 
 	"github.com/labstack/echo/v4"
@@ -170,7 +169,7 @@ func GalaxyLocalCollectionVersionInfo(key string) echo.HandlerFunc {
 					collectionVersionInfo.Collection.Href = fmt.Sprintf("/galaxy/%s/api/v3/collections/%s/%s/", key, namespace, name)
 					collectionVersionInfo.Artifact.Size = v.Size
 					collectionVersionInfo.Artifact.Filename = v.Filename
-					collectionVersionInfo.Artifact.Sha256, err = misc.CalculateSHA256(fmt.Sprintf("%s%s", dest, v.Filename))
+					collectionVersionInfo.Artifact.Sha256, err = types.LocalSHA256(fmt.Sprintf("%s%s", dest, v.Filename), v.Size, v.Time)
 					if err != nil {
 						logger.Named(loggerNS).Errorf("sha calculating error for %s%s: %v", dest, v.Filename, err)
 					}
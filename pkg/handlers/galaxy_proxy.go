@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/psvmcc/hub/pkg/misc"
+	"github.com/psvmcc/hub/pkg/storage"
 	"github.com/psvmcc/hub/pkg/types"
 
 	"github.com/labstack/echo/v4"
@@ -18,31 +19,26 @@ func GalaxyProxyCollection(key string) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		cfg := c.Get("cfg").(types.ConfigFile)
 		logger := c.Get("logger").(*zap.SugaredLogger)
+		store := c.Get("storage").(storage.Backend)
 		loggerNS := "galaxy_proxy_connection"
 		namespace := c.Param("namespace")
 		name := c.Param("name")
 		url := fmt.Sprintf("%s/api/v3/collections/%s/%s/", cfg.Server.Galaxy[key].URL, namespace, name)
-		dest := fmt.Sprintf("%s/galaxy/%s/index/%s/%s/index.json", cfg.Dir, key, namespace, name)
+		dest := fmt.Sprintf("galaxy/%s/index/%s/%s/index.json", key, namespace, name)
 
 		headers := types.RequestHeaders{
 			"User-Agent": "ansible-galaxy",
 		}
-		status, err := misc.DownloadFile(url, dest, headers)
+		cacheStatus, status, err := fetchCached(c, loggerNS, cfg.Server.Galaxy[key].Policy.Metadata.Resolve(revalidateAlways), url, dest, headers)
+		c.Response().Header().Add("X-Cache-Status", cacheStatus)
 		if err != nil {
-			logger.Named(loggerNS).Errorf("[Downloading] %s", err)
-			if _, err = os.Stat(dest); errors.Is(err, os.ErrNotExist) {
-				logger.Named(loggerNS).Errorf("[FS]: %s", err)
-				c.Response().Header().Add("X-Cache-Status", "ERROR")
-				return c.String(status, fmt.Sprintf("%v", err))
-			}
-			c.Response().Header().Add("X-Cache-Status", "STALE")
-			logger.Named(loggerNS).Debugf("Remote %s served from local file %s", url, dest)
-		} else {
-			c.Response().Header().Add("X-Cache-Status", "MISS")
-			logger.Named(loggerNS).Debugf("Remote %s saved as %s", url, dest)
+			return c.String(status, fmt.Sprintf("%v", err))
 		}
 		var collection types.GalaxyCollection
-		err = collection.ReadFromJSONFile(dest)
+		data, err := storage.ReadFile(store, dest)
+		if err == nil {
+			err = collection.ReadFromJSON(data)
+		}
 		if err != nil {
 			logger.Named(loggerNS).Errorf("Unable to parse local json file %s, got error: %s", dest, err)
 		}
@@ -57,31 +53,26 @@ func GalaxyProxyCollectionVersions(key string) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		cfg := c.Get("cfg").(types.ConfigFile)
 		logger := c.Get("logger").(*zap.SugaredLogger)
+		store := c.Get("storage").(storage.Backend)
 		loggerNS := "galaxy_proxy_connection_versions"
 		namespace := c.Param("namespace")
 		name := c.Param("name")
 		url := fmt.Sprintf("%s/api/v3/collections/%s/%s/versions/?%s", cfg.Server.Galaxy[key].URL, namespace, name, c.QueryString())
-		dest := fmt.Sprintf("%s/galaxy/%s/index/%s/%s/versions/index/%s", cfg.Dir, key, namespace, name, c.QueryString())
+		dest := fmt.Sprintf("galaxy/%s/index/%s/%s/versions/index/%s", key, namespace, name, c.QueryString())
 
 		headers := types.RequestHeaders{
 			"User-Agent": "ansible-galaxy",
 		}
-		status, err := misc.DownloadFile(url, dest, headers)
+		cacheStatus, status, err := fetchCached(c, loggerNS, cfg.Server.Galaxy[key].Policy.Metadata.Resolve(revalidateAlways), url, dest, headers)
+		c.Response().Header().Add("X-Cache-Status", cacheStatus)
 		if err != nil {
-			logger.Named(loggerNS).Errorf("[Downloading] %s", err)
-			if _, err = os.Stat(dest); errors.Is(err, os.ErrNotExist) {
-				logger.Named(loggerNS).Errorf("[FS]: %s", err)
-				c.Response().Header().Add("X-Cache-Status", "ERROR")
-				return c.String(status, fmt.Sprintf("%v", err))
-			}
-			c.Response().Header().Add("X-Cache-Status", "STALE")
-			logger.Named(loggerNS).Debugf("Remote %s served from local file %s", url, dest)
-		} else {
-			c.Response().Header().Add("X-Cache-Status", "MISS")
-			logger.Named(loggerNS).Debugf("Remote %s saved as %s", url, dest)
+			return c.String(status, fmt.Sprintf("%v", err))
 		}
 		var collectionVersions types.GalaxyCollectionVersions
-		err = collectionVersions.ReadFromJSONFile(dest, key, namespace, name)
+		data, err := storage.ReadFile(store, dest)
+		if err == nil {
+			err = collectionVersions.ReadFromJSON(data, key, namespace, name)
+		}
 		if err != nil {
 			logger.Named(loggerNS).Errorf("Unable to parse local json file %s, got error: %s", dest, err)
 		}
@@ -93,12 +84,13 @@ func GalaxyProxyCollectionVersionInfo(key string) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		cfg := c.Get("cfg").(types.ConfigFile)
 		logger := c.Get("logger").(*zap.SugaredLogger)
+		store := c.Get("storage").(storage.Backend)
 		loggerNS := "galaxy_proxy_connection_version_info"
 		namespace := c.Param("namespace")
 		name := c.Param("name")
 		version := c.Param("version")
 		url := fmt.Sprintf("%s/api/v3/collections/%s/%s/versions/%s", cfg.Server.Galaxy[key].URL, namespace, name, version)
-		dest := fmt.Sprintf("%s/galaxy/%s/index/%s/%s/versions/%s/index.json", cfg.Dir, key, namespace, name, version)
+		dest := fmt.Sprintf("galaxy/%s/index/%s/%s/versions/%s/index.json", key, namespace, name, version)
 
 		scheme := c.Scheme()
 		host := c.Request().Host
@@ -106,22 +98,16 @@ func GalaxyProxyCollectionVersionInfo(key string) echo.HandlerFunc {
 		headers := types.RequestHeaders{
 			"User-Agent": "ansible-galaxy",
 		}
-		_, err := misc.DownloadFile(url, dest, headers)
+		cacheStatus, _, err := fetchCached(c, loggerNS, cfg.Server.Galaxy[key].Policy.Metadata.Resolve(revalidateAlways), url, dest, headers)
+		c.Response().Header().Add("X-Cache-Status", cacheStatus)
 		if err != nil {
-			logger.Named(loggerNS).Errorf("[Downloading] %s", err)
-			if _, err = os.Stat(dest); errors.Is(err, os.ErrNotExist) {
-				logger.Named(loggerNS).Errorf("[FS]: %s", err)
-				c.Response().Header().Add("X-Cache-Status", "ERROR")
-				return c.String(http.StatusNotFound, "")
-			}
-			c.Response().Header().Add("X-Cache-Status", "STALE")
-			logger.Named(loggerNS).Debugf("Remote %s served from local file %s", url, dest)
-		} else {
-			c.Response().Header().Add("X-Cache-Status", "MISS")
-			logger.Named(loggerNS).Debugf("Remote %s saved as %s", url, dest)
+			return c.String(http.StatusNotFound, "")
 		}
 		var CollectionVersionInfo types.GalaxyCollectionVersionInfo
-		err = CollectionVersionInfo.ReadFromJSONFile(dest)
+		data, err := storage.ReadFile(store, dest)
+		if err == nil {
+			err = CollectionVersionInfo.ReadFromJSON(data)
+		}
 		if err != nil {
 			logger.Named(loggerNS).Errorf("Unable to parse local json file %s, got error: %s", dest, err)
 		}
@@ -136,13 +122,17 @@ func GalaxyProxyCollectionGet(key string) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		cfg := c.Get("cfg").(types.ConfigFile)
 		logger := c.Get("logger").(*zap.SugaredLogger)
+		store := c.Get("storage").(storage.Backend)
 		loggerNS := "galaxy_proxy_connection_get"
 		namespace := c.Param("namespace")
 		name := c.Param("name")
 		version := strings.TrimRight(c.Param("version"), "/")
-		versionFile := fmt.Sprintf("%s/galaxy/%s/index/%s/%s/versions/%s/index.json", cfg.Dir, key, namespace, name, version)
+		versionFile := fmt.Sprintf("galaxy/%s/index/%s/%s/versions/%s/index.json", key, namespace, name, version)
 		var CollectionVersionInfo types.GalaxyCollectionVersionInfo
-		err := CollectionVersionInfo.ReadFromJSONFile(versionFile)
+		data, err := storage.ReadFile(store, versionFile)
+		if err == nil {
+			err = CollectionVersionInfo.ReadFromJSON(data)
+		}
 		if err != nil {
 			logger.Named(loggerNS).Debugf("Parse local json file %s, got error: %s", versionFile, err)
 
@@ -151,36 +141,49 @@ func GalaxyProxyCollectionGet(key string) echo.HandlerFunc {
 			headers := types.RequestHeaders{
 				"User-Agent": "ansible-galaxy",
 			}
-			_, err := misc.DownloadFile(url, versionFile, headers)
+			_, err := misc.DownloadFile(store, url, versionFile, headers)
 			if err != nil {
 				logger.Named(loggerNS).Errorf("[Downloading] %s", err)
-				c.Response().Header().Add("X-Cache-Status", "ERROR")
+				c.Response().Header().Add("X-Cache-Status", downloadErrorStatus(err))
+				if errors.Is(err, misc.ErrOffline) {
+					return c.String(http.StatusNotFound, "")
+				}
 				return c.String(http.StatusBadRequest, "Downloading error")
 			}
 			logger.Named(loggerNS).Debugf("Remote %s saved as %s", url, versionFile)
-			err = CollectionVersionInfo.ReadFromJSONFile(versionFile)
+			data, err = storage.ReadFile(store, versionFile)
+			if err == nil {
+				err = CollectionVersionInfo.ReadFromJSON(data)
+			}
 			if err != nil {
 				logger.Named(loggerNS).Errorf("Unable to parse local json file %s, got error: %s", versionFile, err)
 				return c.String(http.StatusBadRequest, "Metadata error")
 			}
 		}
 		url := CollectionVersionInfo.DownloadURL
-		dest := fmt.Sprintf("%s/galaxy/%s/binary/%s/%s/%s-%s-%s.tar.gz", cfg.Dir, key, namespace, name, namespace, name, version)
+		dest := fmt.Sprintf("galaxy/%s/binary/%s/%s/%s-%s-%s.tar.gz", key, namespace, name, namespace, name, version)
 
 		headers := types.RequestHeaders{
 			"User-Agent": "ansible-galaxy",
 		}
-		if _, err := os.Stat(dest); errors.Is(err, os.ErrNotExist) {
-			status, err := misc.DownloadFile(url, dest, headers)
+		info, err := store.Stat(dest)
+		if err == nil && isFresh(cfg.Server.Galaxy[key].Policy.Artifacts.Resolve(revalidateAlways), dest, info) {
+			logger.Named(loggerNS).Debugf("Serving fresh local file %s", dest)
+			c.Response().Header().Add("X-Cache-Status", "HIT")
+		} else if errors.Is(err, os.ErrNotExist) {
+			d, status, err := misc.StreamFile(store, url, dest, headers)
 			if err != nil {
 				logger.Named(loggerNS).Errorf("[Downloading] %s", err)
-				c.Response().Header().Add("X-Cache-Status", "ERROR")
+				c.Response().Header().Add("X-Cache-Status", downloadErrorStatus(err))
 				return c.String(status, fmt.Sprintf("%v", err))
 			}
 			c.Response().Header().Add("X-Cache-Status", "MISS")
+			c.Response().Header().Add("Content-Type", "application/gzip")
+			c.Response().Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s-%s-%s.tar.gz\"", namespace, name, version))
+			return serveDownload(c, d)
 		} else {
 			logger.Named(loggerNS).Debugf("Remote %s served from local file %s", url, dest)
-			localSha, err := misc.CalculateSHA256(dest)
+			localSha, err := misc.CalculateObjectSHA256(store, dest)
 			if err != nil {
 				logger.Named(loggerNS).Errorf("SHA calculating for %s error: %s", dest, err)
 			}
@@ -188,7 +191,7 @@ func GalaxyProxyCollectionGet(key string) echo.HandlerFunc {
 				c.Response().Header().Add("X-Cache-Status", "HIT")
 			} else {
 				logger.Named(loggerNS).Errorf("SHA mismatch for %s local %s and remote %s", dest, localSha, CollectionVersionInfo.Artifact.Sha256)
-				status, err := misc.DownloadFile(url, dest, headers)
+				status, err := misc.DownloadFile(store, url, dest, headers)
 				if err != nil {
 					logger.Named(loggerNS).Errorf("[Downloading] %s", err)
 					c.Response().Header().Add("X-Cache-Status", "ERROR")
@@ -201,6 +204,6 @@ func GalaxyProxyCollectionGet(key string) echo.HandlerFunc {
 
 		c.Response().Header().Add("Content-Type", "application/gzip")
 		c.Response().Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s-%s-%s.tar.gz\"", namespace, name, version))
-		return c.File(dest)
+		return serveObject(c, store, dest)
 	}
 }
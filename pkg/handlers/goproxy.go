@@ -3,78 +3,136 @@ package handlers
 import (
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"strings"
-	"time"
 
 	"github.com/psvmcc/hub/pkg/misc"
+	"github.com/psvmcc/hub/pkg/storage"
+	"github.com/psvmcc/hub/pkg/sumdb"
 	"github.com/psvmcc/hub/pkg/types"
 
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
 )
 
-// downloadAndCacheFile downloads a file from upstream and caches it locally
-func downloadAndCacheFile(c echo.Context, key, loggerNS, url, dest string) error {
-	logger := c.Get("logger").(*zap.SugaredLogger)
-
+// downloadAndCacheFile downloads a file from upstream and caches it locally.
+// On error status is the code to answer with.
+func downloadAndCacheFile(c echo.Context, fresh types.Freshness, loggerNS, url, dest string, verify misc.Verifier) (status int, err error) {
 	headers := types.RequestHeaders{
 		"User-Agent": "go/goproxy",
 	}
 
-	status, err := misc.DownloadFile(url, dest, headers)
+	cacheStatus, status, err := fetchCachedVerified(c, loggerNS, fresh, url, dest, headers, verify)
+	c.Response().Header().Add("X-Cache-Status", cacheStatus)
+	return status, err
+}
+
+// goSumVerifier returns a check of a downloaded zip, or go.mod when mod is
+// set, of modulePath at version against the checksum database of the repo.
+// It is nil when the module isn't looked up. Content that fails the check
+// is quarantined.
+func goSumVerifier(c echo.Context, key, modulePath, version, dest string, mod bool) misc.Verifier {
+	cfg := c.Get("cfg").(types.ConfigFile)
+	logger := c.Get("logger").(*zap.SugaredLogger)
+	store := c.Get("storage").(storage.Backend)
+	db := cfg.Server.GOPROXY[key].SumDB
+	if db.Disabled || sumdb.MatchPrefix(db.PrivatePatterns(), goUnescapePath(modulePath)) {
+		return nil
+	}
+	verifier, err := sumdb.ParseVerifier(db.VerifierKey())
 	if err != nil {
-		logger.Named(loggerNS).Errorf("[Downloading] %s", err)
-		if _, err = os.Stat(dest); errors.Is(err, os.ErrNotExist) {
-			logger.Named(loggerNS).Errorf("[FS]: %s", err)
-			c.Response().Header().Add("X-Cache-Status", "ERROR")
-			return c.String(status, "410 Gone\n")
+		// Keys are checked on start, a broken one fails every check.
+		return func(io.ReaderAt, int64) error { return err }
+	}
+	client := sumdb.Client{
+		URL:      db.BaseURL(),
+		Verifier: verifier,
+		Do: func(req *http.Request) (*http.Response, error) {
+			return misc.RepoDo(dest, req)
+		},
+	}
+
+	// The check runs in the download, which outlives the request, so it
+	// must not use c.
+	return func(r io.ReaderAt, size int64) error {
+		hashes, err := goSumLookup(store, client, key, modulePath, version)
+		if err != nil {
+			return err
 		}
-		c.Response().Header().Add("X-Cache-Status", "STALE")
-		logger.Named(loggerNS).Debugf("Remote %s served from local file %s", url, dest)
-	} else {
-		c.Response().Header().Add("X-Cache-Status", "MISS")
-		logger.Named(loggerNS).Debugf("Remote %s saved as %s", url, dest)
+		want, got := hashes.Zip, ""
+		if mod {
+			want = hashes.Mod
+			got, err = sumdb.HashMod(r, size)
+		} else {
+			got, err = sumdb.HashZip(r, size)
+		}
+		if want == "" {
+			return fmt.Errorf("no checksum of %s in the checksum database", dest)
+		}
+		if err != nil {
+			got = fmt.Sprintf("unreadable (%s)", err)
+		}
+		if got == want {
+			return nil
+		}
+		if err := misc.Quarantine(store, dest, r, size); err != nil {
+			logger.Named("goproxy").Errorf("[Quarantine] %s: %s", dest, err)
+		}
+		return misc.IntegrityFailure(dest, got, want)
 	}
+}
 
-	return nil
+// goSumLookup returns the go.sum hashes of modulePath at version. Answers
+// of the checksum database never change, so verified ones are cached for
+// good. The signature of cached answers is checked again on every use.
+func goSumLookup(store storage.Backend, client sumdb.Client, key, modulePath, version string) (sumdb.Hashes, error) {
+	dest := fmt.Sprintf("goproxy/%s/.sumdb/%s@%s", key, modulePath, version)
+	data, err := storage.ReadFile(store, dest)
+	if err == nil {
+		err = client.Verifier.CheckLookup(data)
+	}
+	if err != nil {
+		data, err = client.Lookup(modulePath, version)
+		if err != nil {
+			return sumdb.Hashes{}, err
+		}
+		if _, err := sumdb.ParseLookup(data, goUnescapePath(modulePath), goUnescapePath(version)); err == nil {
+			_ = storage.WriteFile(store, dest, data)
+		}
+	}
+	return sumdb.ParseLookup(data, goUnescapePath(modulePath), goUnescapePath(version))
 }
 
 // GoProxyList handles GET /{module}/@v/list requests
 func GoProxyList(key string) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		cfg := c.Get("cfg").(types.ConfigFile)
-		logger := c.Get("logger").(*zap.SugaredLogger)
+		store := c.Get("storage").(storage.Backend)
 		loggerNS := "goproxy_list"
 
 		module := c.Param("*")
 		module = strings.TrimSuffix(module, "/@v/list")
+		if err := checkRoute(cfg.Server.Rules.GOPROXY, key, goUnescapePath(module)); err != nil {
+			return denyRoute(c, loggerNS, err)
+		}
 
-		url := fmt.Sprintf("%s/%s/@v/list", cfg.Server.GOPROXY[key], module)
-		dest := fmt.Sprintf("%s/goproxy/%s/%s/@v/list", cfg.Dir, key, module)
+		url := fmt.Sprintf("%s/%s/@v/list", cfg.Server.GOPROXY[key].URL, module)
+		dest := fmt.Sprintf("goproxy/%s/%s/@v/list", key, module)
 
 		headers := types.RequestHeaders{
 			"User-Agent": "go/goproxy",
 		}
 
-		status, err := misc.DownloadFile(url, dest, headers)
+		cacheStatus, status, err := fetchCached(c, loggerNS, cfg.Server.GOPROXY[key].Policy.Metadata.Resolve(revalidateAlways), url, dest, headers)
+		c.Response().Header().Add("X-Cache-Status", cacheStatus)
 		if err != nil {
-			logger.Named(loggerNS).Errorf("[Downloading] %s", err)
-			if _, err = os.Stat(dest); errors.Is(err, os.ErrNotExist) {
-				logger.Named(loggerNS).Errorf("[FS]: %s", err)
-				c.Response().Header().Add("X-Cache-Status", "ERROR")
-				return c.String(status, "410 Gone\n")
-			}
-			c.Response().Header().Add("X-Cache-Status", "STALE")
-			logger.Named(loggerNS).Debugf("Remote %s served from local file %s", url, dest)
-		} else {
-			c.Response().Header().Add("X-Cache-Status", "MISS")
-			logger.Named(loggerNS).Debugf("Remote %s saved as %s", url, dest)
+			return c.String(status, "410 Gone\n")
 		}
 
 		c.Response().Header().Set("Content-Type", "text/plain; charset=utf-8")
-		return c.File(dest)
+		return serveObject(c, store, dest)
 	}
 }
 
@@ -82,6 +140,7 @@ func GoProxyList(key string) echo.HandlerFunc {
 func GoProxyInfo(key string) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		cfg := c.Get("cfg").(types.ConfigFile)
+		store := c.Get("storage").(storage.Backend)
 
 		module := c.Param("*")
 		parts := strings.Split(module, "/@v/")
@@ -90,16 +149,19 @@ func GoProxyInfo(key string) echo.HandlerFunc {
 		}
 		modulePath := parts[0]
 		version := strings.TrimSuffix(parts[1], ".info")
+		if err := checkRoute(cfg.Server.Rules.GOPROXY, key, goUnescapePath(modulePath)); err != nil {
+			return denyRoute(c, "goproxy_info", err)
+		}
 
-		url := fmt.Sprintf("%s/%s/@v/%s.info", cfg.Server.GOPROXY[key], modulePath, version)
-		dest := fmt.Sprintf("%s/goproxy/%s/%s/@v/%s.info", cfg.Dir, key, modulePath, version)
+		url := fmt.Sprintf("%s/%s/@v/%s.info", cfg.Server.GOPROXY[key].URL, modulePath, version)
+		dest := fmt.Sprintf("goproxy/%s/%s/@v/%s.info", key, modulePath, version)
 
-		if err := downloadAndCacheFile(c, key, "goproxy_info", url, dest); err != nil {
-			return err
+		if status, err := downloadAndCacheFile(c, cfg.Server.GOPROXY[key].Policy.Artifacts.Resolve(revalidateAlways), "goproxy_info", url, dest, nil); err != nil {
+			return c.String(status, "410 Gone\n")
 		}
 
 		c.Response().Header().Set("Content-Type", "application/json")
-		return c.File(dest)
+		return serveObject(c, store, dest)
 	}
 }
 
@@ -107,6 +169,7 @@ func GoProxyInfo(key string) echo.HandlerFunc {
 func GoProxyMod(key string) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		cfg := c.Get("cfg").(types.ConfigFile)
+		store := c.Get("storage").(storage.Backend)
 
 		module := c.Param("*")
 		parts := strings.Split(module, "/@v/")
@@ -115,16 +178,23 @@ func GoProxyMod(key string) echo.HandlerFunc {
 		}
 		modulePath := parts[0]
 		version := strings.TrimSuffix(parts[1], ".mod")
+		if err := checkRoute(cfg.Server.Rules.GOPROXY, key, goUnescapePath(modulePath)); err != nil {
+			return denyRoute(c, "goproxy_mod", err)
+		}
 
-		url := fmt.Sprintf("%s/%s/@v/%s.mod", cfg.Server.GOPROXY[key], modulePath, version)
-		dest := fmt.Sprintf("%s/goproxy/%s/%s/@v/%s.mod", cfg.Dir, key, modulePath, version)
+		url := fmt.Sprintf("%s/%s/@v/%s.mod", cfg.Server.GOPROXY[key].URL, modulePath, version)
+		dest := fmt.Sprintf("goproxy/%s/%s/@v/%s.mod", key, modulePath, version)
 
-		if err := downloadAndCacheFile(c, key, "goproxy_mod", url, dest); err != nil {
-			return err
+		verify := goSumVerifier(c, key, modulePath, version, dest, true)
+		if status, err := downloadAndCacheFile(c, cfg.Server.GOPROXY[key].Policy.Artifacts.Resolve(revalidateAlways), "goproxy_mod", url, dest, verify); err != nil {
+			if errors.Is(err, misc.ErrIntegrity) {
+				return c.String(status, errorMessage(err))
+			}
+			return c.String(status, "410 Gone\n")
 		}
 
 		c.Response().Header().Set("Content-Type", "text/plain; charset=utf-8")
-		return c.File(dest)
+		return serveObject(c, store, dest)
 	}
 }
 
@@ -133,6 +203,7 @@ func GoProxyZip(key string) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		cfg := c.Get("cfg").(types.ConfigFile)
 		logger := c.Get("logger").(*zap.SugaredLogger)
+		store := c.Get("storage").(storage.Backend)
 		loggerNS := "goproxy_zip"
 
 		module := c.Param("*")
@@ -142,39 +213,65 @@ func GoProxyZip(key string) echo.HandlerFunc {
 		}
 		modulePath := parts[0]
 		version := strings.TrimSuffix(parts[1], ".zip")
+		if err := checkRoute(cfg.Server.Rules.GOPROXY, key, goUnescapePath(modulePath)); err != nil {
+			return denyRoute(c, loggerNS, err)
+		}
 
-		url := fmt.Sprintf("%s/%s/@v/%s.zip", cfg.Server.GOPROXY[key], modulePath, version)
-		dest := fmt.Sprintf("%s/goproxy/%s/%s/@v/%s.zip", cfg.Dir, key, modulePath, version)
+		url := fmt.Sprintf("%s/%s/@v/%s.zip", cfg.Server.GOPROXY[key].URL, modulePath, version)
+		dest := fmt.Sprintf("goproxy/%s/%s/@v/%s.zip", key, modulePath, version)
 
 		headers := types.RequestHeaders{
 			"User-Agent": "go/goproxy",
 		}
 
-		// Check if file exists and has valid content
-		if _, err := os.Stat(dest); err == nil {
+		if info, err := store.Stat(dest); err == nil && isFresh(cfg.Server.GOPROXY[key].Policy.Artifacts.Resolve(immutable), dest, info) {
 			c.Response().Header().Add("X-Cache-Status", "HIT")
 			logger.Named(loggerNS).Debugf("Serving cached file %s", dest)
 			c.Response().Header().Set("Content-Type", "application/zip")
-			return c.File(dest)
+			return serveObject(c, store, dest)
 		}
 
-		status, err := misc.DownloadFile(url, dest, headers)
+		if verify := goSumVerifier(c, key, modulePath, version, dest, false); verify != nil {
+			// Checked zips are only sent once they are complete, so a bad one
+			// is answered with an error instead of a broken body.
+			status, err := misc.DownloadFileVerified(store, url, dest, headers, verify)
+			if err != nil {
+				logger.Named(loggerNS).Errorf("[Downloading] %s", err)
+				if errors.Is(err, misc.ErrIntegrity) {
+					c.Response().Header().Add("X-Cache-Status", downloadErrorStatus(err))
+					return c.String(status, errorMessage(err))
+				}
+				if !storage.Exists(store, dest) {
+					c.Response().Header().Add("X-Cache-Status", downloadErrorStatus(err))
+					return c.String(status, "410 Gone\n")
+				}
+				c.Response().Header().Add("X-Cache-Status", "STALE")
+			} else {
+				c.Response().Header().Add("X-Cache-Status", "MISS")
+				logger.Named(loggerNS).Debugf("Remote %s verified as %s", url, dest)
+			}
+			c.Response().Header().Set("Content-Type", "application/zip")
+			return serveObject(c, store, dest)
+		}
+
+		d, status, err := misc.StreamFile(store, url, dest, headers)
 		if err != nil {
 			logger.Named(loggerNS).Errorf("[Downloading] %s", err)
-			if _, err = os.Stat(dest); errors.Is(err, os.ErrNotExist) {
-				logger.Named(loggerNS).Errorf("[FS]: %s", err)
-				c.Response().Header().Add("X-Cache-Status", "ERROR")
+			if _, statErr := store.Stat(dest); errors.Is(statErr, os.ErrNotExist) {
+				logger.Named(loggerNS).Errorf("[FS]: %s", statErr)
+				c.Response().Header().Add("X-Cache-Status", downloadErrorStatus(err))
 				return c.String(status, "410 Gone\n")
 			}
 			c.Response().Header().Add("X-Cache-Status", "STALE")
 			logger.Named(loggerNS).Debugf("Remote %s served from local file %s", url, dest)
-		} else {
-			c.Response().Header().Add("X-Cache-Status", "MISS")
-			logger.Named(loggerNS).Debugf("Remote %s saved as %s", url, dest)
+			c.Response().Header().Set("Content-Type", "application/zip")
+			return serveObject(c, store, dest)
 		}
 
+		c.Response().Header().Add("X-Cache-Status", "MISS")
+		logger.Named(loggerNS).Debugf("Remote %s streaming into %s", url, dest)
 		c.Response().Header().Set("Content-Type", "application/zip")
-		return c.File(dest)
+		return serveDownload(c, d)
 	}
 }
 
@@ -182,48 +279,29 @@ func GoProxyZip(key string) echo.HandlerFunc {
 func GoProxyLatest(key string) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		cfg := c.Get("cfg").(types.ConfigFile)
-		logger := c.Get("logger").(*zap.SugaredLogger)
+		store := c.Get("storage").(storage.Backend)
 		loggerNS := "goproxy_latest"
 
 		module := c.Param("*")
 		module = strings.TrimSuffix(module, "/@latest")
+		if err := checkRoute(cfg.Server.Rules.GOPROXY, key, goUnescapePath(module)); err != nil {
+			return denyRoute(c, loggerNS, err)
+		}
 
-		url := fmt.Sprintf("%s/%s/@latest", cfg.Server.GOPROXY[key], module)
-		dest := fmt.Sprintf("%s/goproxy/%s/%s/@latest", cfg.Dir, key, module)
+		url := fmt.Sprintf("%s/%s/@latest", cfg.Server.GOPROXY[key].URL, module)
+		dest := fmt.Sprintf("goproxy/%s/%s/@latest", key, module)
 
 		headers := types.RequestHeaders{
 			"User-Agent": "go/goproxy",
 		}
 
-		// @latest should be fetched more frequently, so check if file is older than 1 hour
-		fileInfo, err := os.Stat(dest)
-		cacheValid := false
-		if err == nil {
-			oneHourAgo := time.Now().Add(-1 * time.Hour)
-			cacheValid = fileInfo.ModTime().After(oneHourAgo)
-		}
-
-		if !cacheValid {
-			status, err := misc.DownloadFile(url, dest, headers)
-			if err != nil {
-				logger.Named(loggerNS).Errorf("[Downloading] %s", err)
-				if _, err = os.Stat(dest); errors.Is(err, os.ErrNotExist) {
-					logger.Named(loggerNS).Errorf("[FS]: %s", err)
-					c.Response().Header().Add("X-Cache-Status", "ERROR")
-					return c.String(status, "410 Gone\n")
-				}
-				c.Response().Header().Add("X-Cache-Status", "STALE")
-				logger.Named(loggerNS).Debugf("Remote %s served from local file %s", url, dest)
-			} else {
-				c.Response().Header().Add("X-Cache-Status", "MISS")
-				logger.Named(loggerNS).Debugf("Remote %s saved as %s", url, dest)
-			}
-		} else {
-			c.Response().Header().Add("X-Cache-Status", "HIT")
-			logger.Named(loggerNS).Debugf("Serving cached @latest for %s", module)
+		cacheStatus, status, err := fetchCached(c, loggerNS, cfg.Server.GOPROXY[key].Policy.Metadata.Resolve(hourlyTTL), url, dest, headers)
+		c.Response().Header().Add("X-Cache-Status", cacheStatus)
+		if err != nil {
+			return c.String(status, "410 Gone\n")
 		}
 
 		c.Response().Header().Set("Content-Type", "application/json")
-		return c.File(dest)
+		return serveObject(c, store, dest)
 	}
 }
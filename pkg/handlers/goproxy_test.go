@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/psvmcc/hub/pkg/misc"
+	"github.com/psvmcc/hub/pkg/storage"
+	"github.com/psvmcc/hub/pkg/sumdb"
+	"github.com/psvmcc/hub/pkg/types"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// standInSumDB is a checksum database like sum.golang.org. It answers
+// lookups with signed tree heads and serves the tiles that prove them.
+type standInSumDB struct {
+	name    string
+	key     ed25519.PrivateKey
+	vkey    string
+	records [][]byte
+	ids     map[string]int64
+	// forged answers a lookup with this record text instead of the one
+	// in the tree.
+	forged []byte
+}
+
+func newStandInSumDB(t *testing.T) *standInSumDB {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &standInSumDB{name: "sumdb.test", key: priv, ids: map[string]int64{}}
+	key := append([]byte{1}, pub...)
+	h := sha256.Sum256(append([]byte(db.name+"\n"), key...))
+	db.vkey = fmt.Sprintf("%s+%08x+%s", db.name, binary.BigEndian.Uint32(h[:]), base64.StdEncoding.EncodeToString(key))
+	// Enough records for a second level of tiles.
+	for i := range 300 {
+		db.add(fmt.Sprintf("example.com/filler%d", i), "v1.0.0", "h1:AAAA")
+	}
+	return db
+}
+
+func (db *standInSumDB) add(modulePath, version, zipHash string) {
+	db.ids[modulePath+"@"+version] = int64(len(db.records))
+	db.records = append(db.records, fmt.Appendf(nil, "%s %s %s\n%s %s/go.mod h1:BBBB\n", modulePath, version, zipHash, modulePath, version))
+}
+
+func leafHash(data []byte) [32]byte {
+	return sha256.Sum256(append([]byte{0}, data...))
+}
+
+func innerHash(left, right [32]byte) [32]byte {
+	return sha256.Sum256(append(append([]byte{1}, left[:]...), right[:]...))
+}
+
+// levels returns the hashes of the complete subtrees of every level.
+func (db *standInSumDB) levels() [][][32]byte {
+	level := make([][32]byte, len(db.records))
+	for i, r := range db.records {
+		level[i] = leafHash(r)
+	}
+	levels := [][][32]byte{level}
+	for len(level) > 1 {
+		next := make([][32]byte, len(level)/2)
+		for i := range next {
+			next[i] = innerHash(level[2*i], level[2*i+1])
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return levels
+}
+
+func rootHash(leaves [][32]byte) [32]byte {
+	if len(leaves) == 1 {
+		return leaves[0]
+	}
+	k := 1
+	for k*2 < len(leaves) {
+		k *= 2
+	}
+	return innerHash(rootHash(leaves[:k]), rootHash(leaves[k:]))
+}
+
+func (db *standInSumDB) signedTree() string {
+	levels := db.levels()
+	root := rootHash(levels[0])
+	text := fmt.Sprintf("go.sum database tree\n%d\n%s\n", len(db.records), base64.StdEncoding.EncodeToString(root[:]))
+	pub := db.key.Public().(ed25519.PublicKey)
+	h := sha256.Sum256(append([]byte(db.name+"\n"), append([]byte{1}, pub...)...))
+	sig := append(h[:4:4], ed25519.Sign(db.key, []byte(text))...)
+	return fmt.Sprintf("%s\n— %s %s\n", text, db.name, base64.StdEncoding.EncodeToString(sig))
+}
+
+func (db *standInSumDB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if lookup, ok := strings.CutPrefix(r.URL.Path, "/lookup/"); ok {
+		id, ok := db.ids[lookup]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		record := db.records[id]
+		if db.forged != nil {
+			record = db.forged
+		}
+		fmt.Fprintf(w, "%d\n%s\n%s", id, record, db.signedTree())
+		return
+	}
+	// tile/8/<level>/<index>[.p/<width>], the indexes are below 1000.
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/tile/8/"), "/")
+	level, err1 := strconv.Atoi(parts[0])
+	index, err2 := strconv.Atoi(strings.TrimSuffix(parts[1], ".p"))
+	width := 256
+	if len(parts) == 3 {
+		width, _ = strconv.Atoi(parts[2])
+	}
+	levels := db.levels()
+	if err1 != nil || err2 != nil || level*8 >= len(levels) || index*256+width > len(levels[level*8]) {
+		http.NotFound(w, r)
+		return
+	}
+	for _, h := range levels[level*8][index*256 : index*256+width] {
+		_, _ = w.Write(h[:])
+	}
+}
+
+func moduleZip(t *testing.T, content string) []byte {
+	var buf bytes.Buffer
+	z := zip.NewWriter(&buf)
+	f, err := z.Create("example.com/mod@v1.0.0/go.mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write([]byte(content))
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func sumDBContext(t *testing.T, db *standInSumDB) (echo.Context, storage.Backend) {
+	server := httptest.NewServer(db)
+	t.Cleanup(server.Close)
+	t.Setenv("GONOSUMDB", "")
+	t.Setenv("GOPRIVATE", "")
+
+	var cfg types.ConfigFile
+	cfg.Server.GOPROXY = map[string]types.Repo{
+		"go": {URL: "http://127.0.0.1:1", SumDB: types.SumDBConfig{URL: server.URL, Key: db.vkey}},
+	}
+	store := storage.NewFS(t.TempDir())
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+	c.Set("cfg", cfg)
+	c.Set("logger", zap.NewNop().Sugar())
+	c.Set("storage", store)
+	return c, store
+}
+
+func TestGoSumVerifier(t *testing.T) {
+	good := moduleZip(t, "module example.com/mod\n")
+	goodHash, err := sumdb.HashZip(bytes.NewReader(good), int64(len(good)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const dest = "goproxy/go/example.com/mod/@v/v1.0.0.zip"
+	cached := "goproxy/go/.sumdb/example.com/mod@v1.0.0"
+
+	t.Run("matching zip", func(t *testing.T) {
+		db := newStandInSumDB(t)
+		db.add("example.com/mod", "v1.0.0", goodHash)
+		c, store := sumDBContext(t, db)
+		verify := goSumVerifier(c, "go", "example.com/mod", "v1.0.0", dest, false)
+		if err := verify(bytes.NewReader(good), int64(len(good))); err != nil {
+			t.Fatalf("verify: %v", err)
+		}
+		if !storage.Exists(store, cached) {
+			t.Errorf("verified answer is not cached")
+		}
+	})
+
+	t.Run("mismatched zip", func(t *testing.T) {
+		db := newStandInSumDB(t)
+		db.add("example.com/mod", "v1.0.0", goodHash)
+		c, store := sumDBContext(t, db)
+		bad := moduleZip(t, "module example.com/evil\n")
+		verify := goSumVerifier(c, "go", "example.com/mod", "v1.0.0", dest, false)
+		if err := verify(bytes.NewReader(bad), int64(len(bad))); !errors.Is(err, misc.ErrIntegrity) {
+			t.Fatalf("verify: got %v, want an integrity error", err)
+		}
+		data, err := storage.ReadFile(store, misc.QuarantineKey(dest))
+		if err != nil || !bytes.Equal(data, bad) {
+			t.Errorf("mismatched zip is not quarantined: %v", err)
+		}
+	})
+
+	t.Run("missing record", func(t *testing.T) {
+		db := newStandInSumDB(t)
+		c, store := sumDBContext(t, db)
+		verify := goSumVerifier(c, "go", "example.com/mod", "v1.0.0", dest, false)
+		if err := verify(bytes.NewReader(good), int64(len(good))); !errors.Is(err, sumdb.ErrNotFound) {
+			t.Fatalf("verify: got %v, want not found", err)
+		}
+		if storage.Exists(store, cached) {
+			t.Errorf("missing record is cached")
+		}
+	})
+
+	t.Run("private module", func(t *testing.T) {
+		db := newStandInSumDB(t)
+		c, _ := sumDBContext(t, db)
+		t.Setenv("GOPRIVATE", "example.com/other,example.com/mod")
+		if verify := goSumVerifier(c, "go", "example.com/mod", "v1.0.0", dest, false); verify != nil {
+			t.Errorf("module matching GOPRIVATE is looked up")
+		}
+	})
+
+	t.Run("record not in tree", func(t *testing.T) {
+		db := newStandInSumDB(t)
+		db.add("example.com/mod", "v1.0.0", "h1:CCCC")
+		db.forged = fmt.Appendf(nil, "example.com/mod v1.0.0 %s\n", goodHash)
+		c, store := sumDBContext(t, db)
+		verify := goSumVerifier(c, "go", "example.com/mod", "v1.0.0", dest, false)
+		if err := verify(bytes.NewReader(good), int64(len(good))); !errors.Is(err, sumdb.ErrVerify) {
+			t.Fatalf("verify: got %v, want a verification error", err)
+		}
+		if storage.Exists(store, cached) {
+			t.Errorf("forged answer is cached")
+		}
+	})
+}
@@ -0,0 +1,16 @@
+package handlers
+
+// groupCacheStatus reports the least fresh cache status seen across the
+// members that answered a group request.
+func groupCacheStatus(statuses []string) string {
+	rank := map[string]int{"MISS": 1, "REVALIDATING": 2, "EXPIRED": 3, "STALE": 4}
+	result := ""
+	best := -1
+	for _, s := range statuses {
+		if rank[s] > best {
+			best = rank[s]
+			result = s
+		}
+	}
+	return result
+}
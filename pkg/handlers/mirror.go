@@ -0,0 +1,16 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/psvmcc/hub/pkg/warm"
+
+	"github.com/labstack/echo/v4"
+)
+
+// MirrorStatus lists the progress and last runs of every mirrored repo.
+func MirrorStatus() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.JSON(http.StatusOK, warm.MirrorStatuses())
+	}
+}
@@ -6,27 +6,22 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/fs"
 	"net/http"
 	"net/url"
-	"os"
 	"path"
-	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/psvmcc/hub/pkg/misc"
+	"github.com/psvmcc/hub/pkg/storage"
 	"github.com/psvmcc/hub/pkg/types"
 
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
 )
 
-type npmCacheMeta struct {
-	ETag         string `json:"etag"`
-	LastModified string `json:"last_modified"`
-}
-
-const npmSearchTTL = 10 * time.Minute
+var npmSearchTTL = types.Freshness{TTL: 10 * time.Minute, Revalidate: types.RevalidateTTL}
 
 func NpmProxy(key string) echo.HandlerFunc {
 	return func(c echo.Context) error {
@@ -34,14 +29,7 @@ func NpmProxy(key string) echo.HandlerFunc {
 		logger := c.Get("logger").(*zap.SugaredLogger)
 		loggerNS := "npm"
 
-		rawPath := strings.TrimPrefix(c.Param("*"), "/")
-		rawPath = strings.TrimSuffix(rawPath, "/")
-		if rawPath == "" {
-			return c.String(http.StatusNotFound, "")
-		}
-
-		cleaned := path.Clean("/" + rawPath)
-		cleaned = strings.TrimPrefix(cleaned, "/")
+		cleaned := npmRequestPath(c)
 		if cleaned == "" {
 			return c.String(http.StatusNotFound, "")
 		}
@@ -57,17 +45,52 @@ func NpmProxy(key string) echo.HandlerFunc {
 }
 
 func handleNpmMetadata(c echo.Context, cfg types.ConfigFile, logger *zap.SugaredLogger, loggerNS, key, rawPath string) error {
-	decodedPath, err := url.PathUnescape(rawPath)
-	if err != nil {
-		decodedPath = rawPath
-	}
-	decodedPath = path.Clean("/" + decodedPath)
-	packageName := strings.TrimSuffix(strings.TrimPrefix(decodedPath, "/"), "/")
+	packageName := npmPackageName(rawPath)
 	if packageName == "" {
 		return c.String(http.StatusNotFound, "")
 	}
 
+	packument, contentType, cacheStatus, status, err := loadNpmPackument(c, cfg, logger, loggerNS, key, packageName)
+	if cacheStatus != "" {
+		c.Response().Header().Add("X-Cache-Status", cacheStatus)
+	}
+	if err != nil {
+		if errors.Is(err, errNpmPackageNotFound) {
+			return c.String(status, "No package found")
+		}
+		if cacheStatus == "ERROR" || cacheStatus == "BLOCKED" || cacheStatus == "NEGATIVE_HIT" || cacheStatus == "OFFLINE" {
+			return c.String(status, errorMessage(err))
+		}
+		return c.String(status, "Metadata error")
+	}
+
+	baseURL := fmt.Sprintf("%s://%s", c.Scheme(), c.Request().Host)
+	rewrote := rewriteNpmTarballs(packument, baseURL, key)
+	if !rewrote {
+		logger.Named(loggerNS).Debugf("No tarball URLs rewritten for %s", packageName)
+	}
+
+	updated, err := json.Marshal(packument)
+	if err != nil {
+		logger.Named(loggerNS).Errorf("Metadata marshal error: %s", err)
+		return c.String(http.StatusInternalServerError, "Metadata error")
+	}
+
+	return c.Blob(http.StatusOK, contentType, updated)
+}
+
+// loadNpmPackument revalidates the cached packument of a package and returns
+// it with the upstream tarball URLs untouched.
+func loadNpmPackument(c echo.Context, cfg types.ConfigFile, logger *zap.SugaredLogger, loggerNS, key, packageName string) (packument map[string]any, contentType, cacheStatus string, status int, err error) {
+	if cfg.Server.NPM[key].IsDir() {
+		return loadNpmLocalPackument(c, cfg, logger, loggerNS, key, packageName)
+	}
+	store := c.Get("storage").(storage.Backend)
 	acceptKey, upstreamAccept := npmAcceptHeader(c.Request().Header.Get("Accept"))
+	if err = checkRoute(cfg.Server.Rules.NPM, key, packageName); err != nil {
+		logger.Named(loggerNS).Warnf("[Rules] %s", err)
+		return nil, upstreamAccept, "BLOCKED", http.StatusNotFound, err
+	}
 	query := c.QueryString()
 	queryHash := ""
 	if query != "" {
@@ -79,12 +102,10 @@ func handleNpmMetadata(c echo.Context, cfg types.ConfigFile, logger *zap.Sugared
 	if queryHash != "" {
 		filenameBase = fmt.Sprintf("%s.%s", filenameBase, queryHash)
 	}
-	packagePath := filepath.FromSlash(packageName)
-	cacheDir := filepath.Join(cfg.Dir, "npm", key, "metadata", packagePath)
-	dataFile := filepath.Join(cacheDir, filenameBase+".json")
-	metaFile := filepath.Join(cacheDir, filenameBase+".meta.json")
+	cacheDir := npmMetadataDir(key, packageName)
+	dataFile := path.Join(cacheDir, filenameBase+".json")
 
-	upstreamBase := strings.TrimSuffix(cfg.Server.NPM[key], "/")
+	upstreamBase := strings.TrimSuffix(cfg.Server.NPM[key].URL, "/")
 	upstreamName := npmEncodePackageName(packageName)
 	upstreamURL := fmt.Sprintf("%s/%s", upstreamBase, upstreamName)
 	if query != "" {
@@ -96,96 +117,186 @@ func handleNpmMetadata(c echo.Context, cfg types.ConfigFile, logger *zap.Sugared
 		"Accept":     upstreamAccept,
 	}
 
-	cacheExists := fileExists(dataFile)
-	meta := npmCacheMeta{}
-	if cacheExists {
-		meta, _ = readNpmCacheMeta(metaFile)
-	}
-
-	status, newETag, newLastModified, notModified, err := misc.DownloadFileConditional(upstreamURL, dataFile, headers, meta.ETag, meta.LastModified)
+	cacheStatus, status, err = fetchCached(c, loggerNS, cfg.Server.NPM[key].Policy.Metadata.Resolve(revalidateETag), upstreamURL, dataFile, headers)
 	if err != nil {
-		logger.Named(loggerNS).Errorf("[Downloading] %s", err)
-		if !cacheExists {
-			c.Response().Header().Add("X-Cache-Status", "ERROR")
-			return c.String(status, "Please check logs...")
-		}
-		c.Response().Header().Add("X-Cache-Status", "STALE")
-	} else {
-		if notModified {
-			c.Response().Header().Add("X-Cache-Status", "HIT")
-		} else {
-			c.Response().Header().Add("X-Cache-Status", "MISS")
-		}
-		if newETag != "" || newLastModified != "" {
-			if newETag != "" {
-				meta.ETag = newETag
-			}
-			if newLastModified != "" {
-				meta.LastModified = newLastModified
-			}
-			if writeErr := writeNpmCacheMeta(metaFile, meta); writeErr != nil {
-				logger.Named(loggerNS).Errorf("Cache meta write error: %s", writeErr)
-			}
-		}
+		return nil, upstreamAccept, cacheStatus, status, err
 	}
 
-	payload, err := os.ReadFile(filepath.Clean(dataFile))
+	payload, err := storage.ReadFile(store, dataFile)
 	if err != nil {
 		logger.Named(loggerNS).Errorf("Cache read error: %s", err)
-		return c.String(http.StatusBadRequest, "Metadata error")
+		return nil, upstreamAccept, cacheStatus, http.StatusBadRequest, err
 	}
 
-	var packument map[string]any
 	if err := json.Unmarshal(payload, &packument); err != nil {
 		logger.Named(loggerNS).Errorf("Metadata unmarshal error: %s", err)
-		return c.String(http.StatusBadRequest, "Metadata error")
+		return nil, upstreamAccept, cacheStatus, http.StatusBadRequest, err
 	}
 
-	baseURL := fmt.Sprintf("%s://%s", c.Scheme(), c.Request().Host)
-	rewrote := rewriteNpmTarballs(packument, baseURL, key)
-	if !rewrote {
-		logger.Named(loggerNS).Debugf("No tarball URLs rewritten for %s", packageName)
-	}
-
-	updated, err := json.Marshal(packument)
-	if err != nil {
-		logger.Named(loggerNS).Errorf("Metadata marshal error: %s", err)
-		return c.String(http.StatusInternalServerError, "Metadata error")
-	}
-
-	return c.Blob(http.StatusOK, upstreamAccept, updated)
+	return packument, upstreamAccept, cacheStatus, http.StatusOK, nil
 }
 
 func handleNpmTarball(c echo.Context, cfg types.ConfigFile, logger *zap.SugaredLogger, loggerNS, key, rawPath string) error {
-	upstreamBase := strings.TrimSuffix(cfg.Server.NPM[key], "/")
+	if packageName, _ := npmTarballVersion(rawPath); packageName != "" {
+		if err := checkRoute(cfg.Server.Rules.NPM, key, packageName); err != nil {
+			return denyRoute(c, loggerNS, err)
+		}
+	}
+	upstreamBase := strings.TrimSuffix(cfg.Server.NPM[key].URL, "/")
 	upstreamURL := fmt.Sprintf("%s/%s", upstreamBase, rawPath)
-	dest := filepath.Join(cfg.Dir, "npm", key, "tarballs", filepath.FromSlash(rawPath))
+	dest := fmt.Sprintf("npm/%s/tarballs/%s", key, rawPath)
+	store := c.Get("storage").(storage.Backend)
 
 	headers := types.RequestHeaders{
 		"User-Agent": "npm",
 	}
 
-	if _, err := os.Stat(dest); err == nil {
-		c.Response().Header().Add("X-Cache-Status", "HIT")
-		return c.File(dest)
+	integrity, verified := npmTarballIntegrity(c, cfg, logger, loggerNS, key, rawPath)
+	if !verified {
+		logger.Named(loggerNS).Debugf("No dist.integrity or dist.shasum known for %s", rawPath)
 	}
 
-	status, err := misc.DownloadFile(upstreamURL, dest, headers)
+	replaced := false
+	if info, err := store.Stat(dest); err == nil && isFresh(cfg.Server.NPM[key].Policy.Artifacts.Resolve(immutable), dest, info) {
+		if !verified {
+			c.Response().Header().Add("X-Cache-Status", "HIT")
+			return serveObject(c, store, dest)
+		}
+		err := integrity.CheckObject(store, dest)
+		if err == nil {
+			c.Response().Header().Add("X-Cache-Status", "HIT")
+			return serveObject(c, store, dest)
+		}
+		if !errors.Is(err, misc.ErrIntegrity) {
+			logger.Named(loggerNS).Errorf("[Integrity] %s", err)
+			c.Response().Header().Add("X-Cache-Status", "ERROR")
+			return c.String(http.StatusInternalServerError, errorMessage(err))
+		}
+		// The cached copy is corrupt or was tampered with, fetch it again.
+		_ = misc.RemoveObject(store, dest)
+		replaced = true
+	}
+
+	if verified {
+		// Verified tarballs are only sent once they are complete, so a bad
+		// one is answered with an error instead of a broken body.
+		status, err := misc.DownloadFileVerified(store, upstreamURL, dest, headers, integrity.Verifier(dest))
+		if err != nil {
+			logger.Named(loggerNS).Errorf("[Downloading] %s", err)
+			if errors.Is(err, misc.ErrIntegrity) || !storage.Exists(store, dest) || integrity.CheckObject(store, dest) != nil {
+				c.Response().Header().Add("X-Cache-Status", downloadErrorStatus(err))
+				return c.String(status, errorMessage(err))
+			}
+			c.Response().Header().Add("X-Cache-Status", "STALE")
+			return serveObject(c, store, dest)
+		}
+		if replaced {
+			c.Response().Header().Add("X-Cache-Status", "EXPIRED")
+		} else {
+			c.Response().Header().Add("X-Cache-Status", "MISS")
+		}
+		logger.Named(loggerNS).Debugf("Remote %s verified as %s", upstreamURL, dest)
+		return serveObject(c, store, dest)
+	}
+
+	d, status, err := misc.StreamFile(store, upstreamURL, dest, headers)
 	if err != nil {
 		logger.Named(loggerNS).Errorf("[Downloading] %s", err)
-		if _, statErr := os.Stat(dest); errors.Is(statErr, os.ErrNotExist) {
+		if _, statErr := store.Stat(dest); errors.Is(statErr, fs.ErrNotExist) {
 			logger.Named(loggerNS).Errorf("[FS]: %s", statErr)
-			c.Response().Header().Add("X-Cache-Status", "ERROR")
-			return c.String(status, "Please check logs...")
+			c.Response().Header().Add("X-Cache-Status", downloadErrorStatus(err))
+			return c.String(status, errorMessage(err))
 		}
 		c.Response().Header().Add("X-Cache-Status", "STALE")
 		logger.Named(loggerNS).Debugf("Remote %s served from local file %s", upstreamURL, dest)
-		return c.File(dest)
+		return serveObject(c, store, dest)
 	}
 
 	c.Response().Header().Add("X-Cache-Status", "MISS")
-	logger.Named(loggerNS).Debugf("Remote %s saved as %s", upstreamURL, dest)
-	return c.File(dest)
+	logger.Named(loggerNS).Debugf("Remote %s streaming into %s", upstreamURL, dest)
+	return serveDownload(c, d)
+}
+
+// npmTarballIntegrity returns the digest the packument announces for the
+// tarball at rawPath. Cached packuments are read first, as clients fetch
+// the packument right before its tarballs.
+func npmTarballIntegrity(c echo.Context, cfg types.ConfigFile, logger *zap.SugaredLogger, loggerNS, key, rawPath string) (misc.Integrity, bool) {
+	packageName, version := npmTarballVersion(rawPath)
+	if packageName == "" {
+		return misc.Integrity{}, false
+	}
+	store := c.Get("storage").(storage.Backend)
+
+	for _, packumentFile := range npmPackumentKeys(key, packageName) {
+		var packument map[string]any
+		data, err := storage.ReadFile(store, packumentFile)
+		if err != nil || json.Unmarshal(data, &packument) != nil {
+			continue
+		}
+		if integrity, ok := npmDistIntegrity(packument, version, rawPath); ok {
+			return integrity, true
+		}
+	}
+
+	packument, _, _, _, err := loadNpmPackument(c, cfg, logger, loggerNS, key, packageName)
+	if err != nil {
+		logger.Named(loggerNS).Debugf("Packument of %s for integrity check: %s", packageName, err)
+		return misc.Integrity{}, false
+	}
+	return npmDistIntegrity(packument, version, rawPath)
+}
+
+// npmPackumentKeys returns the keys of the cached corgi and full
+// packuments of packageName. They are known without listing the metadata
+// dir, which is a request of its own on S3. Packuments of other Accept
+// headers or queries are left out.
+func npmPackumentKeys(key, packageName string) []string {
+	dir := npmMetadataDir(key, packageName)
+	return []string{dir + "/packument.corgi.json", dir + "/packument.full.json"}
+}
+
+// npmDistIntegrity returns the digest of the dist object that belongs to
+// version, or whose tarball URL ends in rawPath. dist.integrity is
+// preferred over the sha1 dist.shasum.
+func npmDistIntegrity(packument map[string]any, version, rawPath string) (misc.Integrity, bool) {
+	versions, ok := packument["versions"].(map[string]any)
+	if !ok {
+		return misc.Integrity{}, false
+	}
+	var dist map[string]any
+	if versionInfo, ok := versions[version].(map[string]any); ok {
+		dist, _ = versionInfo["dist"].(map[string]any)
+	}
+	if dist == nil {
+		for _, v := range versions {
+			versionInfo, ok := v.(map[string]any)
+			if !ok {
+				continue
+			}
+			candidate, ok := versionInfo["dist"].(map[string]any)
+			if !ok {
+				continue
+			}
+			tarballURL, _ := candidate["tarball"].(string)
+			if parsed, err := url.Parse(tarballURL); err == nil && strings.HasSuffix(parsed.Path, "/"+rawPath) {
+				dist = candidate
+				break
+			}
+		}
+	}
+	if dist == nil {
+		return misc.Integrity{}, false
+	}
+
+	if sri, ok := dist["integrity"].(string); ok {
+		if integrity, ok := misc.ParseSRI(sri); ok {
+			return integrity, true
+		}
+	}
+	if shasum, ok := dist["shasum"].(string); ok {
+		return misc.ParseHexDigest("sha1", shasum)
+	}
+	return misc.Integrity{}, false
 }
 
 func handleNpmSearch(c echo.Context, cfg types.ConfigFile, logger *zap.SugaredLogger, loggerNS, key string) error {
@@ -196,15 +307,9 @@ func handleNpmSearch(c echo.Context, cfg types.ConfigFile, logger *zap.SugaredLo
 		hash = hex.EncodeToString(sum[:])
 	}
 
-	dest := filepath.Join(cfg.Dir, "npm", key, "search", hash+".json")
-	info, err := os.Stat(dest)
-	if err == nil && time.Since(info.ModTime()) < npmSearchTTL {
-		c.Response().Header().Add("X-Cache-Status", "HIT")
-		c.Response().Header().Set("Content-Type", "application/json")
-		return c.File(dest)
-	}
-
-	upstreamBase := strings.TrimSuffix(cfg.Server.NPM[key], "/")
+	dest := fmt.Sprintf("npm/%s/search/%s.json", key, hash)
+	store := c.Get("storage").(storage.Backend)
+	upstreamBase := strings.TrimSuffix(cfg.Server.NPM[key].URL, "/")
 	upstreamURL := fmt.Sprintf("%s/-/v1/search", upstreamBase)
 	if query != "" {
 		upstreamURL = upstreamURL + "?" + query
@@ -215,31 +320,41 @@ func handleNpmSearch(c echo.Context, cfg types.ConfigFile, logger *zap.SugaredLo
 		"Accept":     "application/json",
 	}
 
-	status, err := misc.DownloadFile(upstreamURL, dest, headers)
+	cacheStatus, status, err := fetchCached(c, loggerNS, cfg.Server.NPM[key].Policy.Search.Resolve(npmSearchTTL), upstreamURL, dest, headers)
+	c.Response().Header().Add("X-Cache-Status", cacheStatus)
 	if err != nil {
-		logger.Named(loggerNS).Errorf("[Downloading] %s", err)
-		if _, statErr := os.Stat(dest); errors.Is(statErr, os.ErrNotExist) {
-			logger.Named(loggerNS).Errorf("[FS]: %s", statErr)
-			c.Response().Header().Add("X-Cache-Status", "ERROR")
-			return c.String(status, "Please check logs...")
-		}
-		c.Response().Header().Add("X-Cache-Status", "STALE")
-		c.Response().Header().Set("Content-Type", "application/json")
-		return c.File(dest)
+		return c.String(status, errorMessage(err))
 	}
 
-	if err := os.Chtimes(dest, time.Now(), time.Now()); err != nil {
-		logger.Named(loggerNS).Errorf("Cache timestamp update error: %s", err)
-	}
-	c.Response().Header().Add("X-Cache-Status", "MISS")
 	c.Response().Header().Set("Content-Type", "application/json")
-	return c.File(dest)
+	return serveObject(c, store, dest)
+}
+
+func npmRequestPath(c echo.Context) string {
+	rawPath := strings.TrimPrefix(c.Param("*"), "/")
+	rawPath = strings.TrimSuffix(rawPath, "/")
+	if rawPath == "" {
+		return ""
+	}
+	return strings.TrimPrefix(path.Clean("/"+rawPath), "/")
 }
 
 func isNpmTarballPath(p string) bool {
 	return strings.Contains(p, "/-/") && (strings.HasSuffix(p, ".tgz") || strings.HasSuffix(p, ".tar.gz"))
 }
 
+func npmTarballVersion(rawPath string) (packageName, version string) {
+	idx := strings.Index(rawPath, "/-/")
+	if idx <= 0 {
+		return "", ""
+	}
+	packageName = npmPackageName(rawPath[:idx])
+	filename := path.Base(rawPath)
+	filename = strings.TrimSuffix(strings.TrimSuffix(filename, ".tgz"), ".tar.gz")
+	version = strings.TrimPrefix(filename, path.Base(packageName)+"-")
+	return packageName, version
+}
+
 func isNpmSearchPath(p string) bool {
 	return strings.TrimSuffix(p, "/") == "-/v1/search"
 }
@@ -255,6 +370,19 @@ func npmAcceptHeader(accept string) (cacheKey, upstreamAccept string) {
 	return "accept-" + hex.EncodeToString(sum[:8]), accept
 }
 
+func npmPackageName(rawPath string) string {
+	decodedPath, err := url.PathUnescape(rawPath)
+	if err != nil {
+		decodedPath = rawPath
+	}
+	decodedPath = path.Clean("/" + decodedPath)
+	return strings.TrimSuffix(strings.TrimPrefix(decodedPath, "/"), "/")
+}
+
+func npmMetadataDir(key, packageName string) string {
+	return fmt.Sprintf("npm/%s/metadata/%s", key, packageName)
+}
+
 func npmEncodePackageName(name string) string {
 	if strings.HasPrefix(name, "@") && strings.Contains(name, "/") {
 		return strings.ReplaceAll(name, "/", "%2F")
@@ -308,32 +436,3 @@ func rewriteNpmTarballs(packument map[string]any, baseURL, key string) bool {
 
 	return updated
 }
-
-func readNpmCacheMeta(metaPath string) (npmCacheMeta, error) {
-	meta := npmCacheMeta{}
-	data, err := os.ReadFile(filepath.Clean(metaPath))
-	if err != nil {
-		return meta, err
-	}
-	if err := json.Unmarshal(data, &meta); err != nil {
-		return meta, err
-	}
-	return meta, nil
-}
-
-func writeNpmCacheMeta(metaPath string, meta npmCacheMeta) error {
-	file := filepath.Clean(metaPath)
-	data, err := json.Marshal(meta)
-	if err != nil {
-		return err
-	}
-	if err := os.MkdirAll(filepath.Dir(file), 0o750); err != nil {
-		return err
-	}
-	return os.WriteFile(file, data, 0o600)
-}
-
-func fileExists(filePath string) bool {
-	_, err := os.Stat(filePath)
-	return err == nil
-}
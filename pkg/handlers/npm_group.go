@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/psvmcc/hub/pkg/storage"
+	"github.com/psvmcc/hub/pkg/types"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// NpmGroup serves one registry on top of several npm repos. Packuments are
+// merged by version in member order, tarballs are fetched from the member
+// that owns the version and search goes to the first member.
+func NpmGroup(key string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		cfg := c.Get("cfg").(types.ConfigFile)
+		logger := c.Get("logger").(*zap.SugaredLogger)
+		store := c.Get("storage").(storage.Backend)
+		loggerNS := "npm_group"
+		members := cfg.Server.Groups.NPM[key].Members
+
+		cleaned := npmRequestPath(c)
+		if cleaned == "" {
+			return c.String(http.StatusNotFound, "")
+		}
+
+		if isNpmSearchPath(cleaned) {
+			if cfg.Server.NPM[members[0]].IsDir() {
+				return handleNpmLocalSearch(c, cfg, logger, loggerNS, members[0])
+			}
+			return handleNpmSearch(c, cfg, logger, loggerNS, members[0])
+		}
+		if isNpmTarballPath(cleaned) {
+			owner := npmTarballOwner(cfg, store, members, cleaned)
+			logger.Named(loggerNS).Debugf("%s routed to member %s", cleaned, owner)
+			if cfg.Server.NPM[owner].IsDir() {
+				return handleNpmLocalTarball(c, cfg, logger, loggerNS, owner, cleaned)
+			}
+			return handleNpmTarball(c, cfg, logger, loggerNS, owner, cleaned)
+		}
+		return handleNpmGroupMetadata(c, cfg, logger, loggerNS, key, members, cleaned)
+	}
+}
+
+func handleNpmGroupMetadata(c echo.Context, cfg types.ConfigFile, logger *zap.SugaredLogger, loggerNS, key string, members []string, rawPath string) error {
+	packageName := npmPackageName(rawPath)
+	if packageName == "" {
+		return c.String(http.StatusNotFound, "")
+	}
+
+	baseURL := fmt.Sprintf("%s://%s", c.Scheme(), c.Request().Host)
+	var merged map[string]any
+	contentType := ""
+	errStatus := 0
+	var firstErr error
+	statuses := []string{}
+
+	for _, member := range members {
+		packument, memberContentType, cacheStatus, status, err := loadNpmPackument(c, cfg, logger, loggerNS, member, packageName)
+		if err != nil {
+			logger.Named(loggerNS).Debugf("Member %s skipped for %s: %s", member, packageName, err)
+			if firstErr == nil {
+				errStatus = status
+				firstErr = err
+			}
+			continue
+		}
+		statuses = append(statuses, cacheStatus)
+		rewriteNpmTarballs(packument, baseURL, key)
+		if merged == nil {
+			merged = packument
+			contentType = memberContentType
+			continue
+		}
+		mergeNpmPackument(merged, packument)
+	}
+
+	if merged == nil {
+		c.Response().Header().Add("X-Cache-Status", "ERROR")
+		return c.String(errStatus, errorMessage(firstErr))
+	}
+	c.Response().Header().Add("X-Cache-Status", groupCacheStatus(statuses))
+
+	updated, err := json.Marshal(merged)
+	if err != nil {
+		logger.Named(loggerNS).Errorf("Metadata marshal error: %s", err)
+		return c.String(http.StatusInternalServerError, "Metadata error")
+	}
+	return c.Blob(http.StatusOK, contentType, updated)
+}
+
+// mergeNpmPackument adds the versions, publish times and dist-tags of
+// packument that dst doesn't have yet, so entries already in dst win.
+func mergeNpmPackument(dst, packument map[string]any) {
+	for _, field := range []string{"versions", "time", "dist-tags"} {
+		src, ok := packument[field].(map[string]any)
+		if !ok {
+			continue
+		}
+		target, ok := dst[field].(map[string]any)
+		if !ok {
+			target = map[string]any{}
+			dst[field] = target
+		}
+		for k, v := range src {
+			if _, exists := target[k]; !exists {
+				target[k] = v
+			}
+		}
+	}
+}
+
+// npmTarballOwner returns the first member whose cached packument lists the
+// version of the requested tarball. The first member is used as a fallback.
+func npmTarballOwner(cfg types.ConfigFile, store storage.Backend, members []string, rawPath string) string {
+	packageName, version := npmTarballVersion(rawPath)
+	if packageName == "" {
+		return members[0]
+	}
+	for _, member := range members {
+		if npmPackumentHasVersion(cfg, store, member, packageName, version) {
+			return member
+		}
+	}
+	return members[0]
+}
+
+func npmPackumentHasVersion(cfg types.ConfigFile, store storage.Backend, key, packageName, version string) bool {
+	if cfg.Server.NPM[key].IsDir() {
+		var npmLocal types.NpmLocal
+		if err := npmLocal.List(cfg.Server.NPM[key].Dir); err != nil {
+			return false
+		}
+		for _, t := range npmLocal.Packages[packageName] {
+			if t.Version == version {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, file := range npmPackumentKeys(key, packageName) {
+		payload, err := storage.ReadFile(store, file)
+		if err != nil {
+			continue
+		}
+		var packument struct {
+			Versions map[string]json.RawMessage `json:"versions"`
+		}
+		if err := json.Unmarshal(payload, &packument); err != nil {
+			continue
+		}
+		if _, ok := packument.Versions[version]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/psvmcc/hub/pkg/misc"
+	"github.com/psvmcc/hub/pkg/types"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+var errNpmPackageNotFound = errors.New("no package found")
+
+// npmCorgiFields are the version fields kept in the abbreviated
+// (application/vnd.npm.install-v1+json) packument.
+var npmCorgiFields = []string{
+	"name", "version", "deprecated", "dependencies", "optionalDependencies", "devDependencies",
+	"bundleDependencies", "peerDependencies", "peerDependenciesMeta", "bin", "directories",
+	"engines", "os", "cpu", "funding", "license", "_hasShrinkwrap",
+}
+
+// NpmLocal serves packuments and tarballs generated from the .tgz files
+// found in the repo directory.
+func NpmLocal(key string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		cfg := c.Get("cfg").(types.ConfigFile)
+		logger := c.Get("logger").(*zap.SugaredLogger)
+		loggerNS := "npm_local"
+
+		cleaned := npmRequestPath(c)
+		if cleaned == "" {
+			return c.String(http.StatusNotFound, "")
+		}
+
+		if isNpmSearchPath(cleaned) {
+			return handleNpmLocalSearch(c, cfg, logger, loggerNS, key)
+		}
+		if isNpmTarballPath(cleaned) {
+			return handleNpmLocalTarball(c, cfg, logger, loggerNS, key, cleaned)
+		}
+		return handleNpmMetadata(c, cfg, logger, loggerNS, key, cleaned)
+	}
+}
+
+// loadNpmLocalPackument synthesizes a full or corgi packument, depending on
+// the Accept header, with tarball URLs relative to the repo root.
+func loadNpmLocalPackument(c echo.Context, cfg types.ConfigFile, logger *zap.SugaredLogger, loggerNS, key, packageName string) (packument map[string]any, contentType, cacheStatus string, status int, err error) {
+	acceptKey, _ := npmAcceptHeader(c.Request().Header.Get("Accept"))
+	corgi := acceptKey == "corgi"
+	contentType = "application/json"
+	if corgi {
+		contentType = "application/vnd.npm.install-v1+json"
+	}
+
+	if err = checkRoute(cfg.Server.Rules.NPM, key, packageName); err != nil {
+		logger.Named(loggerNS).Warnf("[Rules] %s", err)
+		return nil, contentType, "BLOCKED", http.StatusNotFound, err
+	}
+
+	npmLocal, err := listNpmLocal(cfg, logger, loggerNS, key)
+	if err != nil {
+		return nil, contentType, "ERROR", http.StatusInternalServerError, err
+	}
+	tarballs := slices.Clone(npmLocal.Packages[packageName])
+	if len(tarballs) == 0 {
+		logger.Named(loggerNS).Debugf("Package not found: %s", packageName)
+		return nil, contentType, "LOCAL", http.StatusNotFound, errNpmPackageNotFound
+	}
+	sort.Slice(tarballs, func(i, j int) bool {
+		return misc.CompareVersions(tarballs[i].Version, tarballs[j].Version) < 0
+	})
+
+	versions := map[string]any{}
+	times := map[string]any{}
+	latest := npmLatestTarball(tarballs)
+	var created, modified time.Time
+	for _, t := range tarballs {
+		dist, err := npmLocalDist(t)
+		if err != nil {
+			logger.Named(loggerNS).Errorf("Digest for %s error: %s", t.Path, err)
+			continue
+		}
+
+		var version map[string]any
+		if corgi {
+			version = map[string]any{}
+			for _, field := range npmCorgiFields {
+				if v, ok := t.Manifest[field]; ok {
+					version[field] = v
+				}
+			}
+			if npmHasInstallScript(t.Manifest) {
+				version["hasInstallScript"] = true
+			}
+		} else {
+			version = maps.Clone(t.Manifest)
+			version["_id"] = fmt.Sprintf("%s@%s", t.Name, t.Version)
+		}
+		version["dist"] = dist
+		versions[t.Version] = version
+
+		times[t.Version] = t.Time.UTC().Format(time.RFC3339)
+		if created.IsZero() || t.Time.Before(created) {
+			created = t.Time
+		}
+		if t.Time.After(modified) {
+			modified = t.Time
+		}
+	}
+
+	packument = map[string]any{
+		"name":      packageName,
+		"dist-tags": map[string]any{"latest": latest.Version},
+		"versions":  versions,
+		"modified":  modified.UTC().Format(time.RFC3339),
+	}
+	if !corgi {
+		packument["_id"] = packageName
+		times["created"] = created.UTC().Format(time.RFC3339)
+		times["modified"] = modified.UTC().Format(time.RFC3339)
+		packument["time"] = times
+		for _, field := range []string{"description", "license", "homepage", "repository", "keywords", "author", "readme"} {
+			if v, ok := latest.Manifest[field]; ok {
+				packument[field] = v
+			}
+		}
+	}
+
+	return packument, contentType, "LOCAL", http.StatusOK, nil
+}
+
+func handleNpmLocalTarball(c echo.Context, cfg types.ConfigFile, logger *zap.SugaredLogger, loggerNS, key, rawPath string) error {
+	packageName, version := npmTarballVersion(rawPath)
+	if packageName == "" {
+		return c.String(http.StatusNotFound, "")
+	}
+	if err := checkRoute(cfg.Server.Rules.NPM, key, packageName); err != nil {
+		return denyRoute(c, loggerNS, err)
+	}
+
+	npmLocal, err := listNpmLocal(cfg, logger, loggerNS, key)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, "Please check logs...")
+	}
+	for _, t := range npmLocal.Packages[packageName] {
+		if t.Version == version {
+			c.Response().Header().Add("X-Cache-Status", "LOCAL")
+			c.Response().Header().Set("Content-Type", echo.MIMEOctetStream)
+			return c.File(t.Path)
+		}
+	}
+
+	logger.Named(loggerNS).Debugf("Tarball not found: %s", rawPath)
+	return c.String(http.StatusNotFound, "")
+}
+
+// handleNpmLocalSearch answers /-/v1/search with the packages whose name
+// contains the text query.
+func handleNpmLocalSearch(c echo.Context, cfg types.ConfigFile, logger *zap.SugaredLogger, loggerNS, key string) error {
+	npmLocal, err := listNpmLocal(cfg, logger, loggerNS, key)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, "Please check logs...")
+	}
+
+	text := strings.ToLower(c.QueryParam("text"))
+	names := []string{}
+	for name := range npmLocal.Packages {
+		if strings.Contains(strings.ToLower(name), text) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	objects := make([]map[string]any, 0, len(names))
+	for _, name := range names {
+		latest := npmLatestTarball(npmLocal.Packages[name])
+		pkg := map[string]any{
+			"name":    name,
+			"version": latest.Version,
+			"date":    latest.Time.UTC().Format(time.RFC3339),
+		}
+		for _, field := range []string{"description", "keywords"} {
+			if v, ok := latest.Manifest[field]; ok {
+				pkg[field] = v
+			}
+		}
+		objects = append(objects, map[string]any{
+			"package":     pkg,
+			"score":       map[string]any{"final": 1},
+			"searchScore": 1,
+		})
+	}
+
+	c.Response().Header().Add("X-Cache-Status", "LOCAL")
+	return c.JSON(http.StatusOK, map[string]any{
+		"objects": objects,
+		"total":   len(objects),
+		"time":    time.Now().UTC().Format(time.RFC1123),
+	})
+}
+
+func listNpmLocal(cfg types.ConfigFile, logger *zap.SugaredLogger, loggerNS, key string) (types.NpmLocal, error) {
+	var npmLocal types.NpmLocal
+	if err := npmLocal.List(cfg.Server.NPM[key].Dir); err != nil {
+		logger.Named(loggerNS).Errorf("Package list error: %s", err)
+		return npmLocal, err
+	}
+	for _, err := range npmLocal.Errors {
+		logger.Named(loggerNS).Debugf("Tarball skipped: %s", err)
+	}
+	return npmLocal, nil
+}
+
+// npmLocalDist returns the dist object of a local tarball. The tarball URL
+// is relative and gets rewritten like upstream ones.
+func npmLocalDist(t types.NpmLocalTarball) (map[string]any, error) {
+	sha1Sum, sha512Sum, err := t.Digests()
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"tarball":   fmt.Sprintf("/%s/-/%s-%s.tgz", t.Name, path.Base(t.Name), t.Version),
+		"shasum":    hex.EncodeToString(sha1Sum),
+		"integrity": "sha512-" + base64.StdEncoding.EncodeToString(sha512Sum),
+	}, nil
+}
+
+// npmLatestTarball returns the highest release version, or the highest
+// pre-release when there are no releases.
+func npmLatestTarball(tarballs []types.NpmLocalTarball) types.NpmLocalTarball {
+	latest := tarballs[0]
+	for _, t := range tarballs[1:] {
+		if misc.IsPrerelease(t.Version) && !misc.IsPrerelease(latest.Version) {
+			continue
+		}
+		if misc.CompareVersions(t.Version, latest.Version) > 0 || (!misc.IsPrerelease(t.Version) && misc.IsPrerelease(latest.Version)) {
+			latest = t
+		}
+	}
+	return latest
+}
+
+func npmHasInstallScript(manifest map[string]any) bool {
+	scripts, ok := manifest["scripts"].(map[string]any)
+	if !ok {
+		return false
+	}
+	for _, s := range []string{"preinstall", "install", "postinstall"} {
+		if _, ok := scripts[s]; ok {
+			return true
+		}
+	}
+	return false
+}
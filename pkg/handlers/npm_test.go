@@ -0,0 +1,88 @@
+package handlers
+
+import "testing"
+
+func TestNpmDistIntegrity(t *testing.T) {
+	const (
+		sha512SRI = "sha512-z4PhNX7vuL3xVChQ1m2AB9Yg5AULVxXcg/SpIdNs6c5H0NE8XYXysP+DGNKHfuwvY7kxvUdBeoGlODJ6+SfaPg=="
+		sha256SRI = "sha256-47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+		shasum    = "da39a3ee5e6b4b0d3255bfef95601890afd80709"
+	)
+	packument := func(dist map[string]any) map[string]any {
+		dist["tarball"] = "https://registry.npmjs.org/left-pad/-/left-pad-1.3.0.tgz"
+		return map[string]any{"versions": map[string]any{"1.3.0": map[string]any{"dist": dist}}}
+	}
+	tests := []struct {
+		name      string
+		packument map[string]any
+		version   string
+		rawPath   string
+		// want is the SRI form of the expected digest, empty for none.
+		want string
+	}{
+		{
+			name:      "integrity over shasum",
+			packument: packument(map[string]any{"integrity": sha512SRI, "shasum": shasum}),
+			version:   "1.3.0",
+			want:      sha512SRI,
+		},
+		{
+			name:      "strongest integrity",
+			packument: packument(map[string]any{"integrity": sha256SRI + " " + sha512SRI}),
+			version:   "1.3.0",
+			want:      sha512SRI,
+		},
+		{
+			name:      "shasum only",
+			packument: packument(map[string]any{"shasum": shasum}),
+			version:   "1.3.0",
+			want:      "sha1-2jmj7l5rSw0yVb/vlWAYkK/YBwk=",
+		},
+		{
+			name:      "unparsable integrity falls back to shasum",
+			packument: packument(map[string]any{"integrity": "md5-x", "shasum": shasum}),
+			version:   "1.3.0",
+			want:      "sha1-2jmj7l5rSw0yVb/vlWAYkK/YBwk=",
+		},
+		{
+			name:      "bad shasum",
+			packument: packument(map[string]any{"shasum": "not hex"}),
+			version:   "1.3.0",
+		},
+		{
+			name:      "no digests",
+			packument: packument(map[string]any{}),
+			version:   "1.3.0",
+		},
+		{
+			name:      "found by tarball path",
+			packument: packument(map[string]any{"integrity": sha512SRI}),
+			rawPath:   "left-pad-1.3.0.tgz",
+			want:      sha512SRI,
+		},
+		{
+			name:      "other tarball",
+			packument: packument(map[string]any{"integrity": sha512SRI}),
+			rawPath:   "left-pad-1.2.0.tgz",
+		},
+		{
+			name:      "no versions",
+			packument: map[string]any{},
+			version:   "1.3.0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			integrity, ok := npmDistIntegrity(tt.packument, tt.version, tt.rawPath)
+			if tt.want == "" {
+				if ok {
+					t.Fatalf("npmDistIntegrity = %s, want none", integrity)
+				}
+				return
+			}
+			if !ok || integrity.String() != tt.want {
+				t.Errorf("npmDistIntegrity = %s (%v), want %s", integrity, ok, tt.want)
+			}
+		})
+	}
+}
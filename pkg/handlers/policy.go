@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/psvmcc/hub/pkg/misc"
+	"github.com/psvmcc/hub/pkg/storage"
+	"github.com/psvmcc/hub/pkg/types"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// Endpoint defaults, used for every field a repo policy leaves unset.
+var (
+	revalidateAlways = types.Freshness{Revalidate: types.RevalidateAlways}
+	revalidateETag   = types.Freshness{Revalidate: types.RevalidateETag}
+	hourlyTTL        = types.Freshness{TTL: time.Hour, Revalidate: types.RevalidateTTL}
+	immutable        = types.Freshness{Immutable: true}
+)
+
+// fetchCached brings the cached copy of url at dest up to date according to
+// the freshness policy and returns the X-Cache-Status for it. On error
+// nothing usable is cached and status is the code to answer with.
+func fetchCached(c echo.Context, loggerNS string, fresh types.Freshness, url, dest string, headers types.RequestHeaders) (cacheStatus string, status int, err error) {
+	return fetchCachedVerified(c, loggerNS, fresh, url, dest, headers, nil)
+}
+
+// fetchCachedVerified is fetchCached with a check of downloaded content.
+// Verified content is always downloaded in full instead of revalidated, and
+// content that fails the check is never served.
+func fetchCachedVerified(c echo.Context, loggerNS string, fresh types.Freshness, url, dest string, headers types.RequestHeaders, verify misc.Verifier) (cacheStatus string, status int, err error) {
+	logger := c.Get("logger").(*zap.SugaredLogger)
+	store := c.Get("storage").(storage.Backend)
+
+	info, statErr := store.Stat(dest)
+	cached := statErr == nil
+	if cached && isFresh(fresh, dest, info) {
+		logger.Named(loggerNS).Debugf("Serving fresh local file %s", dest)
+		return "HIT", http.StatusOK, nil
+	}
+	if cached && fresh.Revalidating(info.ModTime) {
+		logger.Named(loggerNS).Debugf("Serving local file %s while it is refreshed", dest)
+		refreshInBackground(dest, func() {
+			refreshCached(logger, store, loggerNS, fresh, url, dest, headers, verify, info, statErr)
+		})
+		return "REVALIDATING", http.StatusOK, nil
+	}
+	return refreshCached(logger, store, loggerNS, fresh, url, dest, headers, verify, info, statErr)
+}
+
+// revalidations holds the cache keys that are refreshed in the background.
+var revalidations = struct {
+	sync.Mutex
+	running map[string]bool
+}{running: map[string]bool{}}
+
+// refreshInBackground runs refresh for dest unless a refresh of it is
+// already running. The refresh outlives the request that started it.
+func refreshInBackground(dest string, refresh func()) {
+	revalidations.Lock()
+	defer revalidations.Unlock()
+	if revalidations.running[dest] {
+		return
+	}
+	revalidations.running[dest] = true
+	go func() {
+		defer func() {
+			revalidations.Lock()
+			delete(revalidations.running, dest)
+			revalidations.Unlock()
+		}()
+		refresh()
+	}()
+}
+
+// refreshCached contacts upstream for the cached copy at dest, which is
+// described by info and statErr, and returns the X-Cache-Status like
+// fetchCached.
+func refreshCached(logger *zap.SugaredLogger, store storage.Backend, loggerNS string, fresh types.Freshness, url, dest string, headers types.RequestHeaders, verify misc.Verifier, info storage.ObjectInfo, statErr error) (cacheStatus string, status int, err error) {
+	cached := statErr == nil
+	if cached && fresh.Revalidate == types.RevalidateETag && verify == nil {
+		var notModified bool
+		status, notModified, err = misc.Revalidate(store, url, dest, headers)
+		if err == nil && notModified {
+			logger.Named(loggerNS).Debugf("Remote %s not modified", url)
+			return "HIT", http.StatusOK, nil
+		}
+	} else {
+		status, err = misc.DownloadFileVerified(store, url, dest, headers, verify)
+	}
+
+	if errors.Is(err, misc.ErrOffline) {
+		logger.Named(loggerNS).Debugf("Offline, %s is not cached", dest)
+		return "OFFLINE", status, err
+	}
+	if errors.Is(err, misc.ErrNegativeHit) {
+		logger.Named(loggerNS).Debugf("Remote %s recently not found", url)
+		return "NEGATIVE_HIT", status, err
+	}
+	if errors.Is(err, misc.ErrIntegrity) {
+		return "INTEGRITY_ERROR", status, err
+	}
+	if err != nil {
+		logger.Named(loggerNS).Errorf("[Downloading] %s", err)
+		if !cached {
+			logger.Named(loggerNS).Errorf("[FS]: %s", statErr)
+			return "ERROR", status, err
+		}
+		if !fresh.ServeStale(info.ModTime) {
+			logger.Named(loggerNS).Errorf("Local file %s is older than max_stale %s", dest, fresh.MaxStale)
+			return "ERROR", status, err
+		}
+		logger.Named(loggerNS).Debugf("Remote %s served from local file %s", url, dest)
+		return "STALE", http.StatusOK, nil
+	}
+	logger.Named(loggerNS).Debugf("Remote %s saved as %s", url, dest)
+	return "MISS", http.StatusOK, nil
+}
+
+// isFresh reports whether the cached copy at dest can be served without
+// contacting upstream. Offline repos serve every cached copy.
+func isFresh(fresh types.Freshness, dest string, info storage.ObjectInfo) bool {
+	return misc.IsOffline(dest) || fresh.Fresh(info.ModTime)
+}
+
+// checkCached reports whether the cached copy at dest exists and can be
+// served as is. Copies past their TTL are compared with upstream by a HEAD
+// request unless the policy only trusts the TTL.
+func checkCached(c echo.Context, loggerNS string, fresh types.Freshness, url, dest string) (info storage.ObjectInfo, cached, hit bool) {
+	logger := c.Get("logger").(*zap.SugaredLogger)
+	store := c.Get("storage").(storage.Backend)
+
+	info, err := store.Stat(dest)
+	if err != nil {
+		return info, false, false
+	}
+	if isFresh(fresh, dest, info) {
+		return info, true, true
+	}
+	if fresh.Revalidate == types.RevalidateTTL {
+		return info, true, false
+	}
+
+	equal, err := misc.FilesEqual(store, url, dest)
+	if err != nil {
+		logger.Named(loggerNS).Errorf("[FilesEqual]: %s", err)
+	}
+	if !equal {
+		return info, true, false
+	}
+	if err := misc.Touch(store, dest, time.Now()); err != nil {
+		logger.Named(loggerNS).Errorf("Cache timestamp update error: %s", err)
+	}
+	return info, true, true
+}
+
+// downloadErrorStatus returns the X-Cache-Status for content that is not
+// cached and could not be downloaded.
+func downloadErrorStatus(err error) string {
+	if errors.Is(err, misc.ErrOffline) {
+		return "OFFLINE"
+	}
+	if errors.Is(err, misc.ErrNegativeHit) {
+		return "NEGATIVE_HIT"
+	}
+	if errors.Is(err, misc.ErrIntegrity) {
+		return "INTEGRITY_ERROR"
+	}
+	return "ERROR"
+}
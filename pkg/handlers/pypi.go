@@ -6,9 +6,9 @@ import (
 	"net/http"
 	"os"
 	"strings"
-	"time"
 
 	"github.com/psvmcc/hub/pkg/misc"
+	"github.com/psvmcc/hub/pkg/storage"
 	"github.com/psvmcc/hub/pkg/types"
 
 	"github.com/labstack/echo/v4"
@@ -17,118 +17,120 @@ import (
 
 func PypiSimple(key string) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		cfg := c.Get("cfg").(types.ConfigFile)
-		logger := c.Get("logger").(*zap.SugaredLogger)
-		loggerNS := "pypi_simple"
 		name := c.Param("name")
-		url := fmt.Sprintf("%s/%s/", cfg.Server.PYPI[key], name)
-		dest := fmt.Sprintf("%s/pypi/%s/%s/index.json", cfg.Dir, key, name)
-
 		scheme := c.Scheme()
 		host := c.Request().Host
-		headers := types.RequestHeaders{
-			"User-Agent": "pypi",
-			"Accept":     "application/vnd.pypi.simple.v1+json",
-		}
 
-		status, err := misc.DownloadFile(url, dest, headers)
+		pypiMetadata, cacheStatus, status, err := loadPypiSimple(c, key, name)
+		c.Response().Header().Add("X-Cache-Status", cacheStatus)
 		if err != nil {
-			logger.Named(loggerNS).Errorf("[Downloading] %s", err)
-			if _, err = os.Stat(dest); errors.Is(err, os.ErrNotExist) {
-				logger.Named(loggerNS).Errorf("[FS]: %s", err)
-				c.Response().Header().Add("X-Cache-Status", "ERROR")
-				return c.String(status, "Please check logs...")
-			}
-			c.Response().Header().Add("X-Cache-Status", "STALE")
-			logger.Named(loggerNS).Debugf("Remote %s served from local file %s", url, dest)
-		} else {
-			c.Response().Header().Add("X-Cache-Status", "MISS")
-			logger.Named(loggerNS).Debugf("Remote %s saved as %s", url, dest)
-		}
-
-		var pypiMetadata types.PypiMetadata
-		err = pypiMetadata.ReadFromJSONFile(dest)
-		if err != nil {
-			logger.Named(loggerNS).Errorf("Unable to parse local json file %s, got error: %s", dest, err)
+			return c.String(status, errorMessage(err))
 		}
 
 		for i := range pypiMetadata.Files {
 			pypiMetadata.Files[i].URL = fmt.Sprintf("%s://%s/pypi/%s/packages/%s/%s", scheme, host, key, name, pypiMetadata.Files[i].Filename)
 		}
 
-		if strings.Contains(c.Request().Header.Get(echo.HeaderAccept), "application/vnd.pypi.simple.v1+json") {
-			c.Response().Header().Set("Content-Type", "application/vnd.pypi.simple.v1+json")
-			return c.JSON(http.StatusOK, pypiMetadata)
-		}
+		return renderPypiSimple(c, pypiMetadata)
+	}
+}
+
+// loadPypiSimple refreshes the cached simple index of a project and returns
+// it with the upstream file URLs untouched.
+func loadPypiSimple(c echo.Context, key, name string) (pypiMetadata types.PypiMetadata, cacheStatus string, status int, err error) {
+	cfg := c.Get("cfg").(types.ConfigFile)
+	if cfg.Server.PYPI[key].IsDir() {
+		return loadPypiLocalSimple(c, key, name)
+	}
+	logger := c.Get("logger").(*zap.SugaredLogger)
+	store := c.Get("storage").(storage.Backend)
+	loggerNS := "pypi_simple"
+	if err = checkRoute(cfg.Server.Rules.PYPI, key, types.PypiNormalizeName(name)); err != nil {
+		logger.Named(loggerNS).Warnf("[Rules] %s", err)
+		return pypiMetadata, "BLOCKED", http.StatusNotFound, err
+	}
+	url := fmt.Sprintf("%s/%s/", cfg.Server.PYPI[key].URL, name)
+	dest := fmt.Sprintf("pypi/%s/%s/index.json", key, name)
+
+	headers := types.RequestHeaders{
+		"User-Agent": "pypi",
+		"Accept":     "application/vnd.pypi.simple.v1+json",
+	}
+
+	cacheStatus, status, err = fetchCached(c, loggerNS, cfg.Server.PYPI[key].Policy.Metadata.Resolve(revalidateAlways), url, dest, headers)
+	if err != nil {
+		return pypiMetadata, cacheStatus, status, err
+	}
+
+	data, err := storage.ReadFile(store, dest)
+	if err == nil {
+		err = pypiMetadata.ReadFromJSON(data)
+	}
+	if err != nil {
+		logger.Named(loggerNS).Errorf("Unable to parse local json file %s, got error: %s", dest, err)
+	}
+	return pypiMetadata, cacheStatus, http.StatusOK, nil
+}
 
-		c.Response().Header().Add("Content-Type", "text/html")
-		return c.Render(http.StatusOK, "pypi", pypiMetadata)
+func renderPypiSimple(c echo.Context, pypiMetadata types.PypiMetadata) error {
+	if strings.Contains(c.Request().Header.Get(echo.HeaderAccept), "application/vnd.pypi.simple.v1+json") {
+		c.Response().Header().Set("Content-Type", "application/vnd.pypi.simple.v1+json")
+		return c.JSON(http.StatusOK, pypiMetadata)
 	}
+
+	c.Response().Header().Add("Content-Type", "text/html")
+	return c.Render(http.StatusOK, "pypi", pypiMetadata)
 }
 
 func PypiPackages(key string) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		cfg := c.Get("cfg").(types.ConfigFile)
 		logger := c.Get("logger").(*zap.SugaredLogger)
+		store := c.Get("storage").(storage.Backend)
 		loggerNS := "pypi_packages"
 		name := c.Param("name")
 		filename := c.Param("filename")
-		indexDest := fmt.Sprintf("%s/pypi/%s/%s/index.json", cfg.Dir, key, name)
+		if err := checkRoute(cfg.Server.Rules.PYPI, key, types.PypiNormalizeName(name)); err != nil {
+			return denyRoute(c, loggerNS, err)
+		}
+		indexDest := fmt.Sprintf("pypi/%s/%s/index.json", key, name)
 
-		dest := fmt.Sprintf("%s/pypi/%s/%s/%s", cfg.Dir, key, name, filename)
+		dest := fmt.Sprintf("pypi/%s/%s/%s", key, name, filename)
 		var url, sha string
 
 		headers := types.RequestHeaders{
 			"User-Agent": "pypi",
 		}
 
-		indexFileInfo, err := os.Stat(indexDest)
-		if err == nil {
-			modTime := indexFileInfo.ModTime()
-			oneHourAgo := time.Now().Add(-1 * time.Hour)
-
-			logger.Debugf("index=%s modTime=%s now=%s age=%s", indexDest, modTime, time.Now(), time.Since(modTime))
-			if modTime.Before(oneHourAgo) {
-				logger.Named(loggerNS).Debugf("Index file older than 1 hour: %s", indexDest)
-
-				url = fmt.Sprintf("%s/%s/", cfg.Server.PYPI[key], name)
+		if info, err := store.Stat(dest); err == nil && isFresh(cfg.Server.PYPI[key].Policy.Artifacts.Resolve(revalidateAlways), dest, info) {
+			c.Response().Header().Add("X-Cache-Status", "HIT")
+			c.Response().Header().Add("Content-Type", "application/gzip")
+			c.Response().Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+			return serveObject(c, store, dest)
+		}
 
-				headers = types.RequestHeaders{
-					"User-Agent": "pypi",
-					"Accept":     "application/vnd.pypi.simple.v1+json",
-				}
-				_, err := misc.DownloadFile(url, indexDest, headers)
-				if err != nil {
-					logger.Named(loggerNS).Errorf("[Downloading] %s", err)
-				}
-				logger.Named(loggerNS).Debugf("Remote %s saved as %s", url, indexDest)
+		indexURL := fmt.Sprintf("%s/%s/", cfg.Server.PYPI[key].URL, name)
+		indexHeaders := types.RequestHeaders{
+			"User-Agent": "pypi",
+			"Accept":     "application/vnd.pypi.simple.v1+json",
+		}
+		if indexStatus, status, err := fetchCached(c, loggerNS, cfg.Server.PYPI[key].Policy.Metadata.Resolve(hourlyTTL), indexURL, indexDest, indexHeaders); err != nil {
+			c.Response().Header().Add("X-Cache-Status", indexStatus)
+			if indexStatus != "ERROR" {
+				return c.String(status, errorMessage(err))
 			}
+			return c.String(http.StatusBadRequest, "Downloading error")
 		}
 
 		var pypiMetadata types.PypiMetadata
-		err = pypiMetadata.ReadFromJSONFile(indexDest)
+		data, err := storage.ReadFile(store, indexDest)
+		if err == nil {
+			err = pypiMetadata.ReadFromJSON(data)
+		}
 		if err != nil {
-			logger.Named(loggerNS).Debugf("Parse local json file %s, got error: %s", indexDest, err)
-
-			url = fmt.Sprintf("%s/%s/", cfg.Server.PYPI[key], name)
-
-			headers = types.RequestHeaders{
-				"User-Agent": "pypi",
-				"Accept":     "application/vnd.pypi.simple.v1+json",
-			}
-			_, err := misc.DownloadFile(url, indexDest, headers)
-			if err != nil {
-				logger.Named(loggerNS).Errorf("[Downloading] %s", err)
-				c.Response().Header().Add("X-Cache-Status", "ERROR")
-				return c.String(http.StatusBadRequest, "Downloading error")
-			}
-			logger.Named(loggerNS).Debugf("Remote %s saved as %s", url, indexDest)
-			err = pypiMetadata.ReadFromJSONFile(indexDest)
-			if err != nil {
-				logger.Named(loggerNS).Errorf("Unable to parse local json file %s, got error: %s", indexDest, err)
-				c.Response().Header().Add("X-Cache-Status", "ERROR")
-				return c.String(http.StatusBadRequest, "Metadata error")
-			}
+			logger.Named(loggerNS).Errorf("Unable to parse local json file %s, got error: %s", indexDest, err)
+			c.Response().Header().Add("X-Cache-Status", "ERROR")
+			return c.String(http.StatusBadRequest, "Metadata error")
 		}
 
 		for i := range pypiMetadata.Files {
@@ -153,17 +155,20 @@ func PypiPackages(key string) echo.HandlerFunc {
 			return c.String(http.StatusNotFound, fmt.Sprintf("URL is empty for %s/%s", name, filename))
 		}
 
-		if _, err := os.Stat(dest); errors.Is(err, os.ErrNotExist) {
-			status, err := misc.DownloadFile(url, dest, headers)
+		if _, err := store.Stat(dest); errors.Is(err, os.ErrNotExist) {
+			d, status, err := misc.StreamFile(store, url, dest, headers)
 			if err != nil {
 				logger.Named(loggerNS).Errorf("Downloading %s error: %s", url, err)
-				c.Response().Header().Add("X-Cache-Status", "ERROR")
+				c.Response().Header().Add("X-Cache-Status", downloadErrorStatus(err))
 				return c.String(status, fmt.Sprintf("%v", err))
 			}
 			logger.Named(loggerNS).Debugf("Local file %s not found", dest)
 			c.Response().Header().Add("X-Cache-Status", "MISS")
+			c.Response().Header().Add("Content-Type", "application/gzip")
+			c.Response().Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+			return serveDownload(c, d)
 		} else {
-			localSha, err := misc.CalculateSHA256(dest)
+			localSha, err := misc.CalculateObjectSHA256(store, dest)
 			if err != nil {
 				logger.Named(loggerNS).Errorf("SHA calculating for %s error: %s", dest, err)
 			}
@@ -171,7 +176,7 @@ func PypiPackages(key string) echo.HandlerFunc {
 				c.Response().Header().Add("X-Cache-Status", "HIT")
 			} else {
 				logger.Named(loggerNS).Errorf("SHA mismatch for %s local %s and remote %s", dest, localSha, sha)
-				status, err := misc.DownloadFile(url, dest, headers)
+				status, err := misc.DownloadFile(store, url, dest, headers)
 				if err != nil {
 					logger.Named(loggerNS).Errorf("[Downloading] %s", err)
 					c.Response().Header().Add("X-Cache-Status", "ERROR")
@@ -184,6 +189,6 @@ func PypiPackages(key string) echo.HandlerFunc {
 
 		c.Response().Header().Add("Content-Type", "application/gzip")
 		c.Response().Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
-		return c.File(dest)
+		return serveObject(c, store, dest)
 	}
 }
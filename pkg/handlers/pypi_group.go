@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/psvmcc/hub/pkg/storage"
+	"github.com/psvmcc/hub/pkg/types"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// PypiGroupSimple merges the simple indexes of all group members. Files
+// with the same name are taken from the member listed first.
+func PypiGroupSimple(key string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		cfg := c.Get("cfg").(types.ConfigFile)
+		logger := c.Get("logger").(*zap.SugaredLogger)
+		loggerNS := "pypi_group_simple"
+		name := c.Param("name")
+		scheme := c.Scheme()
+		host := c.Request().Host
+
+		var merged types.PypiMetadata
+		found := false
+		errStatus := 0
+		var firstErr error
+		statuses := []string{}
+		seenFiles := map[string]bool{}
+		seenVersions := map[string]bool{}
+
+		for _, member := range cfg.Server.Groups.PYPI[key].Members {
+			pypiMetadata, cacheStatus, status, err := loadPypiSimple(c, member, name)
+			if err != nil {
+				logger.Named(loggerNS).Debugf("Member %s skipped for %s: %s", member, name, err)
+				if firstErr == nil {
+					errStatus = status
+					firstErr = err
+				}
+				continue
+			}
+			statuses = append(statuses, cacheStatus)
+			if !found {
+				merged.Name = pypiMetadata.Name
+				merged.Meta = pypiMetadata.Meta
+				found = true
+			}
+			for _, f := range pypiMetadata.Files {
+				if seenFiles[f.Filename] {
+					continue
+				}
+				seenFiles[f.Filename] = true
+				f.URL = fmt.Sprintf("%s://%s/pypi/%s/packages/%s/%s", scheme, host, key, name, f.Filename)
+				merged.Files = append(merged.Files, f)
+			}
+			for _, v := range pypiMetadata.Versions {
+				if !seenVersions[v] {
+					seenVersions[v] = true
+					merged.Versions = append(merged.Versions, v)
+				}
+			}
+		}
+
+		if !found {
+			c.Response().Header().Add("X-Cache-Status", "ERROR")
+			return c.String(errStatus, errorMessage(firstErr))
+		}
+		c.Response().Header().Add("X-Cache-Status", groupCacheStatus(statuses))
+		return renderPypiSimple(c, merged)
+	}
+}
+
+// PypiGroupPackages hands the request over to the first member whose cached
+// index lists the requested file.
+func PypiGroupPackages(key string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		cfg := c.Get("cfg").(types.ConfigFile)
+		logger := c.Get("logger").(*zap.SugaredLogger)
+		store := c.Get("storage").(storage.Backend)
+		loggerNS := "pypi_group_packages"
+		name := c.Param("name")
+		filename := c.Param("filename")
+
+		members := cfg.Server.Groups.PYPI[key].Members
+		owner := members[0]
+		for _, member := range members {
+			if pypiIndexHasFile(cfg, store, member, name, filename) {
+				owner = member
+				break
+			}
+		}
+
+		logger.Named(loggerNS).Debugf("%s/%s routed to member %s", name, filename, owner)
+		if cfg.Server.PYPI[owner].IsDir() {
+			return PypiLocalPackages(owner)(c)
+		}
+		return PypiPackages(owner)(c)
+	}
+}
+
+func pypiIndexHasFile(cfg types.ConfigFile, store storage.Backend, key, name, filename string) bool {
+	if cfg.Server.PYPI[key].IsDir() {
+		var pypiLocal types.PypiLocal
+		if err := pypiLocal.List(cfg.Server.PYPI[key].Dir); err != nil {
+			return false
+		}
+		for _, f := range pypiLocal.Projects[types.PypiNormalizeName(name)] {
+			if f.Filename == filename || f.Filename+".metadata" == filename {
+				return true
+			}
+		}
+		return false
+	}
+
+	data, err := storage.ReadFile(store, fmt.Sprintf("pypi/%s/%s/index.json", key, name))
+	if err != nil {
+		return false
+	}
+	var pypiMetadata types.PypiMetadata
+	if err := pypiMetadata.ReadFromJSON(data); err != nil {
+		return false
+	}
+	for i := range pypiMetadata.Files {
+		if pypiMetadata.Files[i].Filename == filename || pypiMetadata.Files[i].Filename+".metadata" == filename {
+			return true
+		}
+	}
+	return false
+}
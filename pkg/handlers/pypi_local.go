@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/psvmcc/hub/pkg/types"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+var errPypiProjectNotFound = errors.New("no project found")
+
+func PypiLocalSimple(key string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		name := c.Param("name")
+		scheme := c.Scheme()
+		host := c.Request().Host
+
+		pypiMetadata, cacheStatus, status, err := loadPypiLocalSimple(c, key, name)
+		c.Response().Header().Add("X-Cache-Status", cacheStatus)
+		if err != nil {
+			if errors.Is(err, errPypiProjectNotFound) {
+				return c.String(status, "No project found")
+			}
+			return c.String(status, errorMessage(err))
+		}
+
+		for i := range pypiMetadata.Files {
+			pypiMetadata.Files[i].URL = fmt.Sprintf("%s://%s/pypi/%s/packages/%s/%s", scheme, host, key, name, pypiMetadata.Files[i].Filename)
+		}
+
+		return renderPypiSimple(c, pypiMetadata)
+	}
+}
+
+// loadPypiLocalSimple builds the simple index of a project from the wheels
+// and sdists found in the repo directory.
+func loadPypiLocalSimple(c echo.Context, key, name string) (pypiMetadata types.PypiMetadata, cacheStatus string, status int, err error) {
+	cfg := c.Get("cfg").(types.ConfigFile)
+	logger := c.Get("logger").(*zap.SugaredLogger)
+	loggerNS := "pypi_local_simple"
+	normalized := types.PypiNormalizeName(name)
+
+	if err = checkRoute(cfg.Server.Rules.PYPI, key, normalized); err != nil {
+		logger.Named(loggerNS).Warnf("[Rules] %s", err)
+		return pypiMetadata, "BLOCKED", http.StatusNotFound, err
+	}
+
+	var pypiLocal types.PypiLocal
+	if err = pypiLocal.List(cfg.Server.PYPI[key].Dir); err != nil {
+		logger.Named(loggerNS).Errorf("Project list error: %s", err)
+		return pypiMetadata, "ERROR", http.StatusInternalServerError, err
+	}
+	files := slices.Clone(pypiLocal.Projects[normalized])
+	if len(files) == 0 {
+		logger.Named(loggerNS).Debugf("Project not found: %s", name)
+		return pypiMetadata, "LOCAL", http.StatusNotFound, errPypiProjectNotFound
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Filename < files[j].Filename })
+
+	pypiMetadata.Name = normalized
+	pypiMetadata.Meta.APIVersion = "1.1"
+	seenVersions := map[string]bool{}
+	for _, f := range files {
+		file := types.PypiFile{
+			Filename:             f.Filename,
+			Size:                 int(f.Size),
+			UploadTime:           f.Time.UTC(),
+			Yanked:               false,
+			CoreMetadata:         false,
+			DataDistInfoMetadata: false,
+		}
+		file.Hashes.Sha256, err = f.SHA256()
+		if err != nil {
+			logger.Named(loggerNS).Errorf("SHA calculating for %s error: %s", f.Path, err)
+		}
+		if f.IsWheel() {
+			metadata, err := f.Metadata()
+			if err != nil {
+				logger.Named(loggerNS).Errorf("Wheel metadata for %s error: %s", f.Path, err)
+			} else {
+				sum := sha256.Sum256(metadata)
+				coreMetadata := map[string]any{"sha256": hex.EncodeToString(sum[:])}
+				file.CoreMetadata = coreMetadata
+				file.DataDistInfoMetadata = coreMetadata
+				file.RequiresPython = types.PypiMetadataField(metadata, "Requires-Python")
+			}
+		}
+		pypiMetadata.Files = append(pypiMetadata.Files, file)
+		if !seenVersions[f.Version] {
+			seenVersions[f.Version] = true
+			pypiMetadata.Versions = append(pypiMetadata.Versions, f.Version)
+		}
+	}
+
+	return pypiMetadata, "LOCAL", http.StatusOK, nil
+}
+
+// PypiLocalPackages serves a distribution from the repo directory, or the
+// PEP 658 metadata of a wheel when the file name ends with .metadata.
+func PypiLocalPackages(key string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		cfg := c.Get("cfg").(types.ConfigFile)
+		logger := c.Get("logger").(*zap.SugaredLogger)
+		loggerNS := "pypi_local_packages"
+		name := c.Param("name")
+		filename := c.Param("filename")
+		normalized := types.PypiNormalizeName(name)
+
+		if err := checkRoute(cfg.Server.Rules.PYPI, key, normalized); err != nil {
+			return denyRoute(c, loggerNS, err)
+		}
+
+		var pypiLocal types.PypiLocal
+		if err := pypiLocal.List(cfg.Server.PYPI[key].Dir); err != nil {
+			logger.Named(loggerNS).Errorf("Project list error: %s", err)
+			return c.String(http.StatusInternalServerError, "Please check logs...")
+		}
+
+		wheelName, isMetadata := strings.CutSuffix(filename, ".metadata")
+		for _, f := range pypiLocal.Projects[normalized] {
+			if isMetadata && f.Filename == wheelName && f.IsWheel() {
+				metadata, err := f.Metadata()
+				if err != nil {
+					logger.Named(loggerNS).Errorf("Wheel metadata for %s error: %s", f.Path, err)
+					return c.String(http.StatusNotFound, "")
+				}
+				c.Response().Header().Add("X-Cache-Status", "LOCAL")
+				return c.Blob(http.StatusOK, echo.MIMEOctetStream, metadata)
+			}
+			if f.Filename == filename {
+				c.Response().Header().Add("X-Cache-Status", "LOCAL")
+				c.Response().Header().Add("Content-Type", pypiLocalContentType(filename))
+				c.Response().Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+				return c.File(f.Path)
+			}
+		}
+
+		logger.Named(loggerNS).Debugf("File not found: %s/%s", name, filename)
+		return c.String(http.StatusNotFound, "")
+	}
+}
+
+// pypiLocalContentType returns the media type of a wheel or sdist by its
+// file name.
+func pypiLocalContentType(filename string) string {
+	switch {
+	case strings.HasSuffix(filename, ".whl"), strings.HasSuffix(filename, ".zip"):
+		return "application/zip"
+	case strings.HasSuffix(filename, ".tar.bz2"):
+		return "application/x-bzip2"
+	}
+	return "application/gzip"
+}
@@ -3,13 +3,12 @@ package handlers
 import (
 	"crypto/sha256"
 	"encoding/hex"
-	"errors"
 	"fmt"
-	"os"
 	"path"
 	"strings"
 
 	"github.com/psvmcc/hub/pkg/misc"
+	"github.com/psvmcc/hub/pkg/storage"
 	"github.com/psvmcc/hub/pkg/types"
 
 	"github.com/labstack/echo/v4"
@@ -20,6 +19,7 @@ func RubyGems(key string) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		cfg := c.Get("cfg").(types.ConfigFile)
 		logger := c.Get("logger").(*zap.SugaredLogger)
+		store := c.Get("storage").(storage.Backend)
 		loggerNS := "rubygems"
 
 		requestedPath := strings.TrimPrefix(c.Param("*"), "/")
@@ -31,13 +31,19 @@ func RubyGems(key string) echo.HandlerFunc {
 		}
 
 		query := c.QueryString()
+		for _, name := range rubyGemsNames(upstreamPath, query) {
+			if err := checkRoute(cfg.Server.Rules.RUBYGEMS, key, name); err != nil {
+				return denyRoute(c, loggerNS, err)
+			}
+		}
+
 		cachePath := cacheKey
 		if query != "" {
 			sum := sha256.Sum256([]byte(query))
 			cachePath = path.Join("_query", hex.EncodeToString(sum[:]), cacheKey)
 		}
 
-		upstreamBase := strings.TrimSuffix(cfg.Server.RUBYGEMS[key], "/")
+		upstreamBase := strings.TrimSuffix(cfg.Server.RUBYGEMS[key].URL, "/")
 		url := upstreamBase + "/"
 		if upstreamPath != "" {
 			url += upstreamPath
@@ -46,38 +52,29 @@ func RubyGems(key string) echo.HandlerFunc {
 			url = url + "?" + query
 		}
 
-		dest := fmt.Sprintf("%s/rubygems/%s/%s", cfg.Dir, key, cachePath)
+		dest := fmt.Sprintf("rubygems/%s/%s", key, cachePath)
 
 		headers := types.RequestHeaders{
 			"User-Agent": "rubygems",
 		}
 
-		cacheExists := true
-		if _, err := os.Stat(dest); errors.Is(err, os.ErrNotExist) {
-			cacheExists = false
-		} else {
-			equal, err := misc.FilesEqual(url, dest)
-			if err != nil {
-				logger.Named(loggerNS).Errorf("[FilesEqual]: %s", err)
-			}
-
-			if equal {
-				c.Response().Header().Add("X-Cache-Status", "HIT")
-				return c.File(dest)
-			}
+		fresh := rubyGemsPolicy(cfg.Server.RUBYGEMS[key].Policy, upstreamPath).Resolve(revalidateAlways)
+		info, cacheExists, hit := checkCached(c, loggerNS, fresh, url, dest)
+		if hit {
+			c.Response().Header().Add("X-Cache-Status", "HIT")
+			return serveObject(c, store, dest)
 		}
 
-		status, err := misc.DownloadFile(url, dest, headers)
+		status, err := misc.DownloadFile(store, url, dest, headers)
 		if err != nil {
 			logger.Named(loggerNS).Errorf("[Downloading] %s", err)
-			if _, statErr := os.Stat(dest); errors.Is(statErr, os.ErrNotExist) {
-				logger.Named(loggerNS).Errorf("[FS]: %s", statErr)
-				c.Response().Header().Add("X-Cache-Status", "ERROR")
-				return c.String(status, "Please check logs...")
+			if !cacheExists || !fresh.ServeStale(info.ModTime) {
+				c.Response().Header().Add("X-Cache-Status", downloadErrorStatus(err))
+				return c.String(status, errorMessage(err))
 			}
 			c.Response().Header().Add("X-Cache-Status", "STALE")
 			logger.Named(loggerNS).Debugf("Remote %s served from local file %s", url, dest)
-			return c.File(dest)
+			return serveObject(c, store, dest)
 		}
 
 		if cacheExists {
@@ -86,6 +83,15 @@ func RubyGems(key string) echo.HandlerFunc {
 			c.Response().Header().Add("X-Cache-Status", "MISS")
 		}
 		logger.Named(loggerNS).Debugf("Remote %s saved as %s", url, dest)
-		return c.File(dest)
+		return serveObject(c, store, dest)
+	}
+}
+
+// rubyGemsPolicy picks the policy class of a RubyGems path. Gems and
+// gemspecs never change once published, indexes do.
+func rubyGemsPolicy(policy types.Policy, upstreamPath string) types.CachePolicy {
+	if strings.HasPrefix(upstreamPath, "gems/") || strings.HasPrefix(upstreamPath, "quick/") {
+		return policy.Artifacts
 	}
+	return policy.Metadata
 }
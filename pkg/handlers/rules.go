@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"github.com/psvmcc/hub/pkg/misc"
+	"github.com/psvmcc/hub/pkg/types"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// routeDeniedError is returned when a routing rule doesn't allow a repo to
+// serve a package.
+type routeDeniedError struct {
+	name string
+	key  string
+	rule types.RoutingRule
+}
+
+func (e *routeDeniedError) Error() string {
+	return fmt.Sprintf("package %q is not served by %s: rule %q allows only %s", e.name, e.key, e.rule.Match, strings.Join(e.rule.Repos, ", "))
+}
+
+func checkRoute(rules types.RoutingRules, key, name string) error {
+	if rule, ok := rules.Allowed(key, name); !ok {
+		return &routeDeniedError{name: name, key: key, rule: rule}
+	}
+	return nil
+}
+
+// denyRoute answers a request blocked by a routing rule with an explicit 404.
+func denyRoute(c echo.Context, loggerNS string, err error) error {
+	logger := c.Get("logger").(*zap.SugaredLogger)
+	logger.Named(loggerNS).Warnf("[Rules] %s", err)
+	c.Response().Header().Add("X-Cache-Status", "BLOCKED")
+	return c.String(http.StatusNotFound, err.Error())
+}
+
+// errorMessage hides internal errors from clients but keeps the reason of a
+// routing denial visible.
+func errorMessage(err error) string {
+	var denied *routeDeniedError
+	if errors.As(err, &denied) {
+		return denied.Error()
+	}
+	if errors.Is(err, misc.ErrOffline) || errors.Is(err, misc.ErrNegativeHit) || errors.Is(err, misc.ErrIntegrity) {
+		return err.Error()
+	}
+	return "Please check logs..."
+}
+
+// goUnescapePath reverses the module path case encoding, so `!corp` matches
+// rules written for `Corp`.
+func goUnescapePath(escaped string) string {
+	var b strings.Builder
+	bang := false
+	for _, r := range escaped {
+		if bang {
+			b.WriteRune(unicode.ToUpper(r))
+			bang = false
+			continue
+		}
+		if r == '!' {
+			bang = true
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// rubyGemsNames returns the gem names referenced by a RubyGems request path.
+// Global indexes such as /versions or /specs.4.8.gz reference no gem.
+func rubyGemsNames(requestPath, query string) []string {
+	switch {
+	case strings.HasPrefix(requestPath, "info/"):
+		return []string{strings.TrimPrefix(requestPath, "info/")}
+	case strings.HasPrefix(requestPath, "gems/"):
+		return []string{rubyGemsFileName(strings.TrimSuffix(strings.TrimPrefix(requestPath, "gems/"), ".gem"))}
+	case strings.HasPrefix(requestPath, "quick/Marshal.4.8/"):
+		return []string{rubyGemsFileName(strings.TrimSuffix(strings.TrimPrefix(requestPath, "quick/Marshal.4.8/"), ".gemspec.rz"))}
+	case strings.HasPrefix(requestPath, "api/v1/dependencies"):
+		values, err := url.ParseQuery(query)
+		if err == nil && values.Get("gems") != "" {
+			return strings.Split(values.Get("gems"), ",")
+		}
+	}
+	return nil
+}
+
+// rubyGemsFileName strips the version and platform from a gem file name.
+func rubyGemsFileName(filename string) string {
+	name, _ := types.RubyGemsSplitFilename(filename)
+	return name
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/psvmcc/hub/pkg/types"
+)
+
+func TestCheckRoute(t *testing.T) {
+	rules := types.RoutingRules{
+		{Match: "@corp/public-*", Repos: []string{"npmjs", "corp"}},
+		{Match: "@corp/*", Repos: []string{"corp"}},
+		{Match: "*", Repos: []string{"npmjs"}},
+	}
+	goRules := types.RoutingRules{
+		{Match: "git.corp.example", Repos: []string{"corp"}},
+	}
+	tests := []struct {
+		name    string
+		rules   types.RoutingRules
+		repo    string
+		pkg     string
+		allowed bool
+		// deniedBy is the rule named in the error of a denial.
+		deniedBy string
+	}{
+		{name: "listed repo", rules: rules, repo: "corp", pkg: "@corp/util", allowed: true},
+		{name: "unlisted repo", rules: rules, repo: "npmjs", pkg: "@corp/util", deniedBy: "@corp/*"},
+		{name: "earlier rule wins", rules: rules, repo: "npmjs", pkg: "@corp/public-ui", allowed: true},
+		{name: "later rules are not checked", rules: rules, repo: "corp", pkg: "@corp/public-ui", allowed: true},
+		{name: "catch-all", rules: rules, repo: "corp", pkg: "left-pad", deniedBy: "*"},
+		{name: "catch-all listed repo", rules: rules, repo: "npmjs", pkg: "left-pad", allowed: true},
+		{name: "no rules", repo: "corp", pkg: "left-pad", allowed: true},
+		{name: "parent path", rules: goRules, repo: "proxy", pkg: "git.corp.example/team/lib", deniedBy: "git.corp.example"},
+		{name: "parent path listed repo", rules: goRules, repo: "corp", pkg: "git.corp.example/team/lib", allowed: true},
+		{name: "no matching rule", rules: goRules, repo: "proxy", pkg: "github.com/corp/lib", allowed: true},
+		{name: "prefix is no parent", rules: goRules, repo: "proxy", pkg: "git.corp.example.org/lib", allowed: true},
+		{name: "escaped module path", rules: goRules, repo: "proxy", pkg: goUnescapePath("git.corp.example/!team/lib"), deniedBy: "git.corp.example"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkRoute(tt.rules, tt.repo, tt.pkg)
+			if tt.allowed {
+				if err != nil {
+					t.Fatalf("checkRoute = %v, want allowed", err)
+				}
+				return
+			}
+			var denied *routeDeniedError
+			if !errors.As(err, &denied) {
+				t.Fatalf("checkRoute = %v, want a denial", err)
+			}
+			if denied.rule.Match != tt.deniedBy {
+				t.Errorf("denied by %q, want %q", denied.rule.Match, tt.deniedBy)
+			}
+			if msg := errorMessage(err); !strings.Contains(msg, tt.pkg) || !strings.Contains(msg, tt.repo) {
+				t.Errorf("errorMessage = %q, want the package and repo", msg)
+			}
+		})
+	}
+}
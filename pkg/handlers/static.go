@@ -1,12 +1,11 @@
 package handlers
 
 import (
-	"errors"
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/psvmcc/hub/pkg/misc"
+	"github.com/psvmcc/hub/pkg/storage"
 	"github.com/psvmcc/hub/pkg/types"
 
 	"github.com/labstack/echo/v4"
@@ -17,48 +16,41 @@ func Static(key string) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		cfg := c.Get("cfg").(types.ConfigFile)
 		logger := c.Get("logger").(*zap.SugaredLogger)
+		store := c.Get("storage").(storage.Backend)
 		loggerNS := "static"
 		path := strings.TrimPrefix(c.Request().URL.String(), fmt.Sprintf("/static/%s/get/", key))
-		url := fmt.Sprintf("%s/%s", cfg.Server.Static[key], path)
-		dest := fmt.Sprintf("%s/static/%s/%s", cfg.Dir, key, path)
+		url := fmt.Sprintf("%s/%s", cfg.Server.Static[key].URL, path)
+		dest := fmt.Sprintf("static/%s/%s", key, path)
 
 		headers := types.RequestHeaders{
 			"User-Agent": "curl",
 		}
 
-		cacheExists := true
-		if _, err := os.Stat(dest); errors.Is(err, os.ErrNotExist) {
-			cacheExists = false
-		} else {
-			equal, err := misc.FilesEqual(url, dest)
-			if err != nil {
-				logger.Named(loggerNS).Errorf("[FilesEqual]: %s", err)
-			}
-
-			if equal {
-				c.Response().Header().Add("X-Cache-Status", "HIT")
-				return c.File(dest)
-			}
+		fresh := cfg.Server.Static[key].Policy.Artifacts.Resolve(revalidateAlways)
+		info, cacheExists, hit := checkCached(c, loggerNS, fresh, url, dest)
+		if hit {
+			c.Response().Header().Add("X-Cache-Status", "HIT")
+			return serveObject(c, store, dest)
 		}
 
-		status, err := misc.DownloadFile(url, dest, headers)
+		d, status, err := misc.StreamFile(store, url, dest, headers)
 		if err != nil {
 			logger.Named(loggerNS).Errorf("[Downloading] %s", err)
-			if _, err := os.Stat(dest); errors.Is(err, os.ErrNotExist) {
-				logger.Named(loggerNS).Errorf("[FS]: %s", err)
-				c.Response().Header().Add("X-Cache-Status", "ERROR")
-				return c.String(status, "Please check logs...")
+			if !cacheExists || !fresh.ServeStale(info.ModTime) {
+				c.Response().Header().Add("X-Cache-Status", downloadErrorStatus(err))
+				return c.String(status, errorMessage(err))
 			}
 			c.Response().Header().Add("X-Cache-Status", "STALE")
 			logger.Named(loggerNS).Debugf("Remote %s served from local file %s", url, dest)
+			return serveObject(c, store, dest)
+		}
+
+		if cacheExists {
+			c.Response().Header().Add("X-Cache-Status", "EXPIRED")
 		} else {
-			if cacheExists {
-				c.Response().Header().Add("X-Cache-Status", "EXPIRED")
-			} else {
-				c.Response().Header().Add("X-Cache-Status", "MISS")
-			}
-			logger.Named(loggerNS).Debugf("Remote %s saved as %s", url, dest)
+			c.Response().Header().Add("X-Cache-Status", "MISS")
 		}
-		return c.File(dest)
+		logger.Named(loggerNS).Debugf("Remote %s streaming into %s", url, dest)
+		return serveDownload(c, d)
 	}
 }
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/psvmcc/hub/pkg/misc"
+	"github.com/psvmcc/hub/pkg/storage"
+	"github.com/psvmcc/hub/pkg/types"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const staticHostedMetaDir = ".meta"
+
+func StaticHostedGet(key string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		logger := c.Get("logger").(*zap.SugaredLogger)
+		store := c.Get("storage").(storage.Backend)
+		loggerNS := "static_hosted_get"
+
+		filePath, ok := staticHostedPath(c.Param("*"))
+		if !ok {
+			return c.String(http.StatusBadRequest, "invalid path")
+		}
+		dest := staticHostedDest(key, filePath)
+
+		if _, err := store.Stat(dest); errors.Is(err, fs.ErrNotExist) {
+			logger.Named(loggerNS).Debugf("File not found: %s", dest)
+			return c.String(http.StatusNotFound, "")
+		}
+
+		meta, err := readStaticHostedMeta(store, key, filePath)
+		if err != nil {
+			logger.Named(loggerNS).Errorf("Unable to read metadata for %s: %s", dest, err)
+		}
+		if meta.ContentType != "" {
+			c.Response().Header().Set("Content-Type", meta.ContentType)
+		}
+		if meta.Sha256 != "" {
+			c.Response().Header().Set("X-Checksum-Sha256", meta.Sha256)
+		}
+		c.Response().Header().Add("X-Cache-Status", "HOSTED")
+		return serveObject(c, store, dest)
+	}
+}
+
+func StaticHostedPut(key string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		logger := c.Get("logger").(*zap.SugaredLogger)
+		store := c.Get("storage").(storage.Backend)
+		loggerNS := "static_hosted_put"
+
+		filePath, ok := staticHostedPath(c.Param("*"))
+		if !ok {
+			return c.String(http.StatusBadRequest, "invalid path")
+		}
+		dest := staticHostedDest(key, filePath)
+
+		sha, size, err := misc.SaveFile(store, c.Request().Body, dest, c.Request().Header.Get("X-Checksum-Sha256"))
+		if err != nil {
+			if errors.Is(err, misc.ErrChecksumMismatch) {
+				logger.Named(loggerNS).Warnf("Upload %s rejected: %s", dest, err)
+				return c.String(http.StatusBadRequest, err.Error())
+			}
+			logger.Named(loggerNS).Errorf("Upload %s error: %s", dest, err)
+			return c.String(http.StatusInternalServerError, "Please check logs...")
+		}
+
+		contentType := c.Request().Header.Get(echo.HeaderContentType)
+		if contentType == "" {
+			contentType = echo.MIMEOctetStream
+		}
+		meta := types.StaticHostedFile{
+			Path:        filePath,
+			Size:        size,
+			Sha256:      sha,
+			ContentType: contentType,
+			UploadedAt:  time.Now().UTC(),
+		}
+		if err := writeStaticHostedMeta(store, key, meta); err != nil {
+			logger.Named(loggerNS).Errorf("Metadata write for %s error: %s", dest, err)
+			return c.String(http.StatusInternalServerError, "Please check logs...")
+		}
+
+		logger.Named(loggerNS).Infof("Uploaded %s (%d bytes, sha256 %s)", dest, size, sha)
+		return c.JSON(http.StatusCreated, meta)
+	}
+}
+
+func StaticHostedDelete(key string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		logger := c.Get("logger").(*zap.SugaredLogger)
+		store := c.Get("storage").(storage.Backend)
+		loggerNS := "static_hosted_delete"
+
+		filePath, ok := staticHostedPath(c.Param("*"))
+		if !ok {
+			return c.String(http.StatusBadRequest, "invalid path")
+		}
+		dest := staticHostedDest(key, filePath)
+
+		if err := misc.RemoveObject(store, dest); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return c.String(http.StatusNotFound, "")
+			}
+			logger.Named(loggerNS).Errorf("Remove %s error: %s", dest, err)
+			return c.String(http.StatusInternalServerError, "Please check logs...")
+		}
+		if err := store.Remove(staticHostedMetaDest(key, filePath)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logger.Named(loggerNS).Errorf("Remove metadata for %s error: %s", dest, err)
+		}
+
+		logger.Named(loggerNS).Infof("Deleted %s", dest)
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// StaticHostedList returns every uploaded file, optionally filtered by ?prefix=.
+func StaticHostedList(key string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		logger := c.Get("logger").(*zap.SugaredLogger)
+		store := c.Get("storage").(storage.Backend)
+		loggerNS := "static_hosted_list"
+
+		prefix := strings.TrimPrefix(c.QueryParam("prefix"), "/")
+		root := fmt.Sprintf("static/%s/", key)
+
+		files := []types.StaticHostedFile{}
+		err := store.Walk(root+prefix, func(info storage.ObjectInfo) error {
+			rel := strings.TrimPrefix(info.Key, root)
+			if strings.HasPrefix(rel, staticHostedMetaDir+"/") {
+				return nil
+			}
+
+			meta, err := readStaticHostedMeta(store, key, rel)
+			if err != nil {
+				logger.Named(loggerNS).Debugf("No metadata for %s: %s", info.Key, err)
+				meta = types.StaticHostedFile{Path: rel, Size: info.Size, UploadedAt: info.ModTime.UTC()}
+			}
+			files = append(files, meta)
+			return nil
+		})
+		if err != nil {
+			logger.Named(loggerNS).Errorf("Listing %s error: %s", root, err)
+			return c.String(http.StatusInternalServerError, "Please check logs...")
+		}
+
+		sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+		c.Response().Header().Add("X-Cache-Status", "HOSTED")
+		return c.JSON(http.StatusOK, files)
+	}
+}
+
+// staticHostedPath cleans the requested path and rejects anything that could
+// escape the repo directory or clash with the hidden metadata tree.
+func staticHostedPath(raw string) (string, bool) {
+	decoded, err := url.PathUnescape(raw)
+	if err != nil {
+		return "", false
+	}
+	cleaned := strings.TrimPrefix(path.Clean("/"+decoded), "/")
+	if cleaned == "" {
+		return "", false
+	}
+	for segment := range strings.SplitSeq(cleaned, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return "", false
+		}
+	}
+	return cleaned, true
+}
+
+func staticHostedDest(key, filePath string) string {
+	return fmt.Sprintf("static/%s/%s", key, filePath)
+}
+
+func staticHostedMetaDest(key, filePath string) string {
+	return fmt.Sprintf("static/%s/%s/%s.json", key, staticHostedMetaDir, filePath)
+}
+
+func readStaticHostedMeta(store storage.Backend, key, filePath string) (meta types.StaticHostedFile, err error) {
+	data, err := storage.ReadFile(store, staticHostedMetaDest(key, filePath))
+	if err != nil {
+		return meta, fmt.Errorf("error reading file: %v", err)
+	}
+	err = meta.ReadFromJSON(data)
+	return meta, err
+}
+
+func writeStaticHostedMeta(store storage.Backend, key string, meta types.StaticHostedFile) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("error marshalling JSON: %v", err)
+	}
+	return storage.WriteFile(store, staticHostedMetaDest(key, meta.Path), data)
+}
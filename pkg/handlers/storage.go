@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/psvmcc/hub/pkg/index"
+	"github.com/psvmcc/hub/pkg/misc"
+	"github.com/psvmcc/hub/pkg/storage"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// serveObject sends a stored object like c.File does for local files,
+// including Last-Modified and range request handling.
+func serveObject(c echo.Context, store storage.Backend, key string) error {
+	obj, info, err := store.Open(key)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return echo.ErrNotFound
+		}
+		return err
+	}
+	defer obj.Close()
+	index.Access(key)
+	http.ServeContent(c.Response(), c.Request(), path.Base(key), info.ModTime, obj)
+	return nil
+}
+
+// serveDownload sends an upstream download to the client while it is being
+// written to the cache. Range requests get the full body, as nothing can be
+// skipped before it was received.
+func serveDownload(c echo.Context, d *misc.Download) error {
+	r := d.NewReader()
+	defer r.Close()
+	index.Access(d.Key)
+
+	header := c.Response().Header()
+	if header.Get(echo.HeaderContentType) == "" {
+		contentType := mime.TypeByExtension(path.Ext(d.Key))
+		if contentType == "" {
+			contentType = echo.MIMEOctetStream
+		}
+		header.Set(echo.HeaderContentType, contentType)
+	}
+	if d.Size >= 0 {
+		header.Set(echo.HeaderContentLength, strconv.FormatInt(d.Size, 10))
+	}
+	if lastModified := d.Header.Get(echo.HeaderLastModified); lastModified != "" {
+		header.Set(echo.HeaderLastModified, lastModified)
+	}
+	c.Response().WriteHeader(http.StatusOK)
+	if _, err := io.Copy(c.Response(), r); err != nil {
+		logger := c.Get("logger").(*zap.SugaredLogger)
+		logger.Named("download").Errorf("[Streaming] %s: %s", d.Key, err)
+		// The status is sent already. Aborting the connection keeps the
+		// client from taking the partial body for a complete one.
+		panic(http.ErrAbortHandler)
+	}
+	return nil
+}
@@ -0,0 +1,379 @@
+// Package index keeps one record for every cached object: where it came
+// from, its validators and digest, and how often it is served. Records are
+// kept in memory and written to a journal file, so the cache can be listed,
+// measured and evicted without walking the storage.
+package index
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"go.uber.org/zap"
+)
+
+// Entry is the record of a cached object.
+type Entry struct {
+	Key  string `json:"key"`
+	Repo string `json:"repo,omitempty"`
+	// URL is the upstream the object was fetched from, empty for uploads.
+	URL          string `json:"url,omitempty"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	SHA256       string `json:"sha256,omitempty"`
+	Size         int64  `json:"size,omitempty"`
+	ContentType  string `json:"content_type,omitempty"`
+	// FetchedAt is the modification time of the object, the time it was
+	// fetched from upstream or last revalidated.
+	FetchedAt  time.Time `json:"fetched_at,omitzero"`
+	LastAccess time.Time `json:"last_access,omitzero"`
+	Hits       int64     `json:"hits,omitempty"`
+}
+
+// Used returns the time the object was last served, or fetched when it
+// wasn't served since.
+func (e Entry) Used() time.Time {
+	if e.LastAccess.After(e.FetchedAt) {
+		return e.LastAccess
+	}
+	return e.FetchedAt
+}
+
+// record is a line of the journal. Later lines replace earlier ones of the
+// same key.
+type record struct {
+	Entry
+	Removed bool `json:"removed,omitempty"`
+}
+
+type usage struct {
+	objects int64
+	size    int64
+}
+
+var idx = struct {
+	sync.Mutex
+	entries map[string]Entry
+	repos   map[string]usage
+	path    string
+	file    *os.File
+	w       *bufio.Writer
+	// records counts the lines of the journal, it is compacted once most
+	// of them are outdated.
+	records int
+}{entries: map[string]Entry{}, repos: map[string]usage{}}
+
+// flushInterval is how often journal writes are flushed to the file. A
+// crash loses at most the records of the last interval, which are restored
+// from the storage on the next start except for access counts.
+const flushInterval = time.Second
+
+// Open loads the journal at path and keeps writing records to it. Without
+// Open the index is only kept in memory.
+func Open(path string) error {
+	idx.Lock()
+	defer idx.Unlock()
+	if idx.file != nil {
+		return errors.New("index is already open")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	if err := load(path); err != nil {
+		return err
+	}
+	idx.path = path
+	if err := compact(); err != nil {
+		return err
+	}
+	go flushLoop()
+	return nil
+}
+
+// Load reads the journal at path without writing to it, for commands
+// that look up entries while the server keeps the journal.
+func Load(path string) error {
+	idx.Lock()
+	defer idx.Unlock()
+	return load(path)
+}
+
+func load(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var r record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// A line cut off by a crash, the records before it are intact.
+			zap.S().Named("index").Warnf("Skipping broken record in %s: %s", path, err)
+			continue
+		}
+		if r.Removed {
+			remove(r.Key)
+		} else {
+			set(r.Entry)
+		}
+	}
+	return scanner.Err()
+}
+
+// compact writes the current entries to a new journal and replaces the old
+// one with it.
+func compact() error {
+	tmp := idx.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, e := range idx.entries {
+		if err := enc.Encode(record{Entry: e}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if idx.file != nil {
+		_ = idx.w.Flush()
+		idx.file.Close()
+		idx.file, idx.w = nil, nil
+	}
+	if err := os.Rename(tmp, idx.path); err != nil {
+		return err
+	}
+	if idx.file, err = os.OpenFile(idx.path, os.O_APPEND|os.O_WRONLY, 0o600); err != nil {
+		return err
+	}
+	idx.w = bufio.NewWriter(idx.file)
+	idx.records = len(idx.entries)
+	return nil
+}
+
+// Close flushes the journal and stops writing to it, for commands that
+// record entries and exit.
+func Close() error {
+	idx.Lock()
+	defer idx.Unlock()
+	if idx.file == nil {
+		return nil
+	}
+	err := idx.w.Flush()
+	if closeErr := idx.file.Close(); err == nil {
+		err = closeErr
+	}
+	idx.file, idx.w = nil, nil
+	return err
+}
+
+func flushLoop() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		idx.Lock()
+		if idx.file == nil {
+			idx.Unlock()
+			return
+		}
+		var err error
+		if idx.records > 2*len(idx.entries)+1024 {
+			err = compact()
+		} else if idx.w != nil {
+			err = idx.w.Flush()
+		}
+		idx.Unlock()
+		if err != nil {
+			zap.S().Named("index").Errorf("Writing %s error: %s", idx.path, err)
+		}
+	}
+}
+
+// write appends r to the journal.
+func write(r record) {
+	if idx.w == nil {
+		return
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+	_, _ = idx.w.Write(append(data, '\n'))
+	idx.records++
+}
+
+func set(e Entry) {
+	if old, ok := idx.entries[e.Key]; ok {
+		idx.repos[old.Repo] = idx.repos[old.Repo].add(-1, -old.Size)
+	}
+	idx.entries[e.Key] = e
+	idx.repos[e.Repo] = idx.repos[e.Repo].add(1, e.Size)
+}
+
+func remove(key string) {
+	if old, ok := idx.entries[key]; ok {
+		idx.repos[old.Repo] = idx.repos[old.Repo].add(-1, -old.Size)
+		delete(idx.entries, key)
+	}
+}
+
+func (u usage) add(objects, size int64) usage {
+	return usage{objects: u.objects + objects, size: u.size + size}
+}
+
+// Put records a new object. The access history of an earlier object under
+// the same key is kept.
+func Put(e Entry) {
+	idx.Lock()
+	defer idx.Unlock()
+	if old, ok := idx.entries[e.Key]; ok {
+		e.LastAccess, e.Hits = old.LastAccess, old.Hits
+	}
+	set(e)
+	write(record{Entry: e})
+}
+
+// update changes the entry of key, if there is one.
+func update(key string, fn func(e *Entry)) {
+	idx.Lock()
+	defer idx.Unlock()
+	e, ok := idx.entries[key]
+	if !ok {
+		return
+	}
+	fn(&e)
+	set(e)
+	write(record{Entry: e})
+}
+
+// Access records that key was served to a client.
+func Access(key string) {
+	update(key, func(e *Entry) {
+		e.LastAccess = time.Now()
+		e.Hits++
+	})
+}
+
+// Imported records that key was added by an import at t. The object keeps
+// the fetch time of the exporting side, it only ranks as used at t, so
+// imported objects are not the first to be evicted.
+func Imported(key string, t time.Time) {
+	update(key, func(e *Entry) {
+		if t.After(e.LastAccess) {
+			e.LastAccess = t
+		}
+	})
+}
+
+// Refreshed records that upstream confirmed key unchanged at fetchedAt.
+func Refreshed(key string, fetchedAt time.Time, etag, lastModified string) {
+	update(key, func(e *Entry) {
+		e.FetchedAt = fetchedAt
+		if etag != "" {
+			e.ETag = etag
+		}
+		if lastModified != "" {
+			e.LastModified = lastModified
+		}
+	})
+}
+
+// SetSHA256 records the digest of key, if the entry is still about the
+// object of the given size and modification time.
+func SetSHA256(key, sum string, size int64, modTime time.Time) {
+	update(key, func(e *Entry) {
+		if e.Size == size && e.FetchedAt.Equal(modTime) {
+			e.SHA256 = sum
+		}
+	})
+}
+
+// Remove drops the entry of key.
+func Remove(key string) {
+	idx.Lock()
+	defer idx.Unlock()
+	if _, ok := idx.entries[key]; !ok {
+		return
+	}
+	remove(key)
+	write(record{Entry: Entry{Key: key}, Removed: true})
+}
+
+// Get returns the entry of key.
+func Get(key string) (Entry, bool) {
+	idx.Lock()
+	defer idx.Unlock()
+	e, ok := idx.entries[key]
+	return e, ok
+}
+
+// List returns the entries whose key starts with prefix, sorted by key.
+func List(prefix string) []Entry {
+	idx.Lock()
+	entries := []Entry{}
+	for key, e := range idx.entries {
+		if strings.HasPrefix(key, prefix) {
+			entries = append(entries, e)
+		}
+	}
+	idx.Unlock()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries
+}
+
+// Retain drops the entries keep returns false for.
+func Retain(keep func(Entry) bool) {
+	idx.Lock()
+	defer idx.Unlock()
+	for key, e := range idx.entries {
+		if !keep(e) {
+			remove(key)
+			write(record{Entry: Entry{Key: key}, Removed: true})
+		}
+	}
+}
+
+// Usage returns the number and total size of the objects of repo.
+func Usage(repo string) (objects, size int64) {
+	idx.Lock()
+	defer idx.Unlock()
+	u := idx.repos[repo]
+	return u.objects, u.size
+}
+
+// RegisterMetrics reports the size of every repo.
+func RegisterMetrics(repos []string) {
+	for _, repo := range repos {
+		metrics.GetOrCreateGauge(fmt.Sprintf("hub_cache_objects{repo=%q}", repo), func() float64 {
+			objects, _ := Usage(repo)
+			return float64(objects)
+		})
+		metrics.GetOrCreateGauge(fmt.Sprintf("hub_cache_size_bytes{repo=%q}", repo), func() float64 {
+			_, size := Usage(repo)
+			return float64(size)
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package misc
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/psvmcc/hub/pkg/types"
+)
+
+// upstreamAuth is the resolved auth config of a repo.
+type upstreamAuth struct {
+	hosts  map[string]bool
+	header http.Header
+	netrc  map[string]netrcEntry
+}
+
+type netrcEntry struct {
+	login    string
+	password string
+}
+
+var auths = struct {
+	sync.RWMutex
+	repos map[string]*upstreamAuth
+}{repos: map[string]*upstreamAuth{}}
+
+// SetAuth reads the credentials of repo, such as npm/npmjs, from their
+// environment variables and files. They are sent to the hosts of upstreams
+// and the extra hosts of the config.
+func SetAuth(repo string, upstreams []string, cfg types.AuthConfig) error {
+	a := &upstreamAuth{hosts: map[string]bool{}, header: http.Header{}}
+	for _, upstream := range upstreams {
+		u, err := url.Parse(upstream)
+		if err != nil {
+			return fmt.Errorf("invalid upstream %q: %v", upstream, err)
+		}
+		a.hosts[u.Host] = true
+	}
+	for _, host := range cfg.Hosts {
+		a.hosts[host] = true
+	}
+
+	if cfg.Username.IsSet() {
+		username, err := cfg.Username.Read()
+		if err != nil {
+			return fmt.Errorf("auth username: %v", err)
+		}
+		password, err := cfg.Password.Read()
+		if err != nil {
+			return fmt.Errorf("auth password: %v", err)
+		}
+		req := &http.Request{Header: http.Header{}}
+		req.SetBasicAuth(username, password)
+		a.header.Set("Authorization", req.Header.Get("Authorization"))
+	}
+	if cfg.Token.IsSet() {
+		token, err := cfg.Token.Read()
+		if err != nil {
+			return fmt.Errorf("auth token: %v", err)
+		}
+		a.header.Set("Authorization", cfg.Scheme()+" "+token)
+	}
+	for name, secret := range cfg.Headers {
+		value, err := secret.Read()
+		if err != nil {
+			return fmt.Errorf("auth header %s: %v", name, err)
+		}
+		a.header.Set(name, value)
+	}
+	if cfg.Netrc != "" {
+		data, err := os.ReadFile(filepath.Clean(cfg.Netrc))
+		if err != nil {
+			return fmt.Errorf("auth netrc: %v", err)
+		}
+		a.netrc = parseNetrc(string(data))
+	}
+
+	auths.Lock()
+	defer auths.Unlock()
+	auths.repos[repo] = a
+	return nil
+}
+
+// applyAuth adds the credentials of the repo of key to req when it goes to
+// one of the repo's hosts, or to a host of its .netrc file.
+func applyAuth(key string, req *http.Request) {
+	auths.RLock()
+	a := auths.repos[metricsRepo(key)]
+	auths.RUnlock()
+	if a == nil {
+		return
+	}
+
+	if a.hosts[req.URL.Host] {
+		for name, values := range a.header {
+			req.Header[name] = values
+		}
+	}
+	if a.netrc != nil && req.Header.Get("Authorization") == "" {
+		entry, ok := a.netrc[req.URL.Hostname()]
+		if !ok {
+			entry, ok = a.netrc[""]
+		}
+		if ok {
+			req.SetBasicAuth(entry.login, entry.password)
+		}
+	}
+}
+
+// stripAuth drops the auth headers of repo from a redirect to a host that
+// isn't one of the repo's hosts. net/http only drops Authorization and
+// Cookie by itself, custom headers like X-JFrog-Art-Api would follow the
+// redirect to a CDN.
+func stripAuth(repo string, req *http.Request) {
+	auths.RLock()
+	a := auths.repos[repo]
+	auths.RUnlock()
+	if a == nil || a.hosts[req.URL.Host] {
+		return
+	}
+	for name := range a.header {
+		req.Header.Del(name)
+	}
+}
+
+// parseNetrc returns the login and password of every machine of a .netrc
+// file. The default entry is stored under the empty name.
+func parseNetrc(data string) map[string]netrcEntry {
+	entries := map[string]netrcEntry{}
+	fields := strings.Fields(data)
+	machine := ""
+	inMachine := false
+	for i := 0; i < len(fields); i++ {
+		next := func() string {
+			if i+1 < len(fields) {
+				i++
+				return fields[i]
+			}
+			return ""
+		}
+		switch fields[i] {
+		case "machine":
+			machine = next()
+			inMachine = true
+		case "default":
+			machine = ""
+			inMachine = true
+		case "login":
+			if inMachine {
+				entry := entries[machine]
+				entry.login = next()
+				entries[machine] = entry
+			}
+		case "password":
+			if inMachine {
+				entry := entries[machine]
+				entry.password = next()
+				entries[machine] = entry
+			}
+		case "account":
+			next()
+		case "macdef":
+			// Macro definitions run until an empty line, which Fields can't
+			// see, so the rest of the file is skipped.
+			return entries
+		}
+	}
+	return entries
+}
@@ -0,0 +1,136 @@
+package misc
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/psvmcc/hub/pkg/types"
+)
+
+func TestRedirectAuth(t *testing.T) {
+	var got http.Header
+	target := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer target.Close()
+	targetHost := hostOf(t, target.URL)
+
+	tests := []struct {
+		name string
+		// to is where upstream redirects to, a path of upstream itself
+		// when empty.
+		to       string
+		hosts    []string
+		wantAuth bool
+	}{
+		{name: "other host", to: target.URL + "/file.tgz"},
+		{name: "extra host", to: target.URL + "/file.tgz", hosts: []string{targetHost}, wantAuth: true},
+		{name: "same host", wantAuth: true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/moved" {
+					got = r.Header.Clone()
+					return
+				}
+				to := tt.to
+				if to == "" {
+					to = "/moved"
+				}
+				http.Redirect(w, r, to, http.StatusFound)
+			}))
+			defer upstream.Close()
+
+			repo := fmt.Sprintf("npm/redirect%d", i)
+			cfg := types.AuthConfig{
+				Token:   types.Secret{Value: "s3cret"},
+				Headers: map[string]types.Secret{"X-Api-Key": {Value: "k3y"}},
+				Hosts:   tt.hosts,
+			}
+			if err := SetAuth(repo, []string{upstream.URL}, cfg); err != nil {
+				t.Fatal(err)
+			}
+			if err := SetClient(repo, types.ClientConfig{}); err != nil {
+				t.Fatal(err)
+			}
+
+			got = nil
+			req, _ := http.NewRequest(http.MethodGet, upstream.URL+"/file.tgz", http.NoBody)
+			response, err := RepoDo(repo+"/file.tgz", req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response.Body.Close()
+			if got == nil {
+				t.Fatal("redirect was not followed")
+			}
+			for _, name := range []string{"Authorization", "X-Api-Key"} {
+				if sent := got.Get(name) != ""; sent != tt.wantAuth {
+					t.Errorf("%s sent after the redirect: %v, want %v", name, sent, tt.wantAuth)
+				}
+			}
+		})
+	}
+}
+
+func TestNetrcAuth(t *testing.T) {
+	netrc := filepath.Join(t.TempDir(), ".netrc")
+	data := "machine registry.example login alice password one\n" +
+		"default login anonymous password two\n" +
+		"machine late.example login bob password three\n"
+	if err := os.WriteFile(netrc, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		url      string
+		token    string
+		wantUser string
+		wantPass string
+	}{
+		{name: "machine", url: "https://registry.example/pkg", wantUser: "alice", wantPass: "one"},
+		{name: "machine with port", url: "https://registry.example:8443/pkg", wantUser: "alice", wantPass: "one"},
+		{name: "machine after default", url: "https://late.example/pkg", wantUser: "bob", wantPass: "three"},
+		{name: "default", url: "https://cdn.example/pkg", wantUser: "anonymous", wantPass: "two"},
+		{name: "token first", url: "https://registry.example/pkg", token: "s3cret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := types.AuthConfig{Netrc: netrc}
+			if tt.token != "" {
+				cfg.Token = types.Secret{Value: tt.token}
+			}
+			if err := SetAuth("pypi/netrc", []string{"https://registry.example"}, cfg); err != nil {
+				t.Fatal(err)
+			}
+			req, _ := http.NewRequest(http.MethodGet, tt.url, http.NoBody)
+			applyAuth("pypi/netrc/pkg", req)
+			user, pass, ok := req.BasicAuth()
+			if tt.wantUser == "" {
+				if ok || req.Header.Get("Authorization") != "Bearer "+tt.token {
+					t.Errorf("Authorization = %q, want the token", req.Header.Get("Authorization"))
+				}
+				return
+			}
+			if !ok || user != tt.wantUser || pass != tt.wantPass {
+				t.Errorf("basic auth = %q:%q (%v), want %q:%q", user, pass, ok, tt.wantUser, tt.wantPass)
+			}
+		})
+	}
+}
+
+func hostOf(t *testing.T, rawURL string) string {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Host
+}
@@ -0,0 +1,121 @@
+package misc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/psvmcc/hub/pkg/types"
+)
+
+// maxRedirects is how many redirects a request follows, like the default
+// of net/http.
+const maxRedirects = 10
+
+// upstreamClient is the client of a repo with its retry settings.
+type upstreamClient struct {
+	*http.Client
+	retries int
+	backoff time.Duration
+}
+
+var clients = struct {
+	sync.RWMutex
+	repos    map[string]*upstreamClient
+	fallback *upstreamClient
+}{repos: map[string]*upstreamClient{}}
+
+// SetClient builds the HTTP client used for the upstreams of repo, such as
+// npm/npmjs. All requests of the repo share its transport.
+func SetClient(repo string, cfg types.ClientConfig) error {
+	client, err := newClient(repo, cfg)
+	if err != nil {
+		return err
+	}
+	clients.Lock()
+	defer clients.Unlock()
+	clients.repos[repo] = client
+	return nil
+}
+
+// repoClient returns the client of the repo of key. Keys of unknown repos
+// get a client with the default settings.
+func repoClient(key string) *upstreamClient {
+	clients.RLock()
+	client, ok := clients.repos[metricsRepo(key)]
+	clients.RUnlock()
+	if ok {
+		return client
+	}
+
+	clients.Lock()
+	defer clients.Unlock()
+	if clients.fallback == nil {
+		clients.fallback, _ = newClient("", types.ClientConfig{})
+	}
+	return clients.fallback
+}
+
+func newClient(repo string, cfg types.ClientConfig) (*upstreamClient, error) {
+	dialer := &net.Dialer{Timeout: cfg.Connect(), KeepAlive: 30 * time.Second}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = cfg.Connect()
+	transport.ResponseHeaderTimeout = cfg.Header()
+	transport.MaxIdleConnsPerHost = 16
+
+	if cfg.Proxy != "" {
+		proxy, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %v", err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(filepath.Clean(cfg.CAFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca_file: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	return &upstreamClient{
+		Client: &http.Client{
+			Transport: transport,
+			Timeout:   cfg.Timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= maxRedirects {
+					return fmt.Errorf("stopped after %d redirects", maxRedirects)
+				}
+				stripAuth(repo, req)
+				return nil
+			},
+		},
+		retries: cfg.Retries,
+		backoff: cfg.Backoff(),
+	}, nil
+}
@@ -0,0 +1,276 @@
+package misc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/psvmcc/hub/pkg/index"
+	"github.com/psvmcc/hub/pkg/storage"
+)
+
+// Digests are the recorded hashes of a cached object. They are valid as
+// long as the object has the recorded size and modification time.
+type Digests struct {
+	Size    int64 `json:"size"`
+	ModTime int64 `json:"mtime"`
+	// Hashes maps algorithms like sha256 to hex digests.
+	Hashes map[string]string `json:"hashes"`
+}
+
+func (d Digests) matches(info storage.ObjectInfo) bool {
+	return d.Size == info.Size && d.ModTime == info.ModTime.UnixNano()
+}
+
+// digestKey returns the sidecar that records the digests of key.
+func digestKey(key string) string {
+	return strings.TrimSuffix(MetaKey(key), ".json") + ".digest"
+}
+
+// readDigests returns the digests recorded for key, or false when there
+// are none or key changed since.
+func readDigests(store storage.Backend, key string, info storage.ObjectInfo) (Digests, bool) {
+	var d Digests
+	data, err := storage.ReadFile(store, digestKey(key))
+	if err != nil || json.Unmarshal(data, &d) != nil || !d.matches(info) {
+		return Digests{}, false
+	}
+	return d, true
+}
+
+// recordDigests stores hashes of key for the size and modification time in
+// info, along with the ones recorded before that are still valid.
+func recordDigests(store storage.Backend, key string, info storage.ObjectInfo, hashes map[string]string) error {
+	d, ok := readDigests(store, key, info)
+	if !ok {
+		d = Digests{Size: info.Size, ModTime: info.ModTime.UnixNano(), Hashes: map[string]string{}}
+	}
+	for algorithm, sum := range hashes {
+		d.Hashes[algorithm] = sum
+	}
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return storage.WriteFile(store, digestKey(key), data)
+}
+
+// ObjectDigest returns the hex digest of the cached object key. The
+// recorded digest is used while key is unchanged, otherwise the object is
+// hashed and the result recorded.
+func ObjectDigest(store storage.Backend, key, algorithm string) (string, error) {
+	newHash, ok := integrityHashes[algorithm]
+	if !ok {
+		return "", fmt.Errorf("unknown hash algorithm %q", algorithm)
+	}
+	obj, info, err := store.Open(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %v", err)
+	}
+	defer obj.Close()
+	if e, ok := index.Get(info.Key); ok && algorithm == "sha256" && e.SHA256 != "" && e.Size == info.Size && e.FetchedAt.Equal(info.ModTime) {
+		return e.SHA256, nil
+	}
+	if d, ok := readDigests(store, key, info); ok && d.Hashes[algorithm] != "" {
+		return d.Hashes[algorithm], nil
+	}
+
+	h := newHash()
+	if _, err := io.Copy(h, obj); err != nil {
+		return "", fmt.Errorf("failed to calculate hash: %v", err)
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	_ = recordDigests(store, key, info, map[string]string{algorithm: sum})
+	if algorithm == "sha256" {
+		index.SetSHA256(info.Key, sum, info.Size, info.ModTime)
+	}
+	return sum, nil
+}
+
+// hashWritten returns the sha256 of data written to a download, which is
+// recorded once the object is committed.
+func hashWritten(r io.ReaderAt, size int64) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, 0, size)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Touch sets the modification time of key, which marks when it was last
+// confirmed by upstream. The recorded digests stay valid, as the content
+// didn't change.
+func Touch(store storage.Backend, key string, modTime time.Time) error {
+	info, err := store.Stat(key)
+	if err != nil {
+		return err
+	}
+	d, recorded := readDigests(store, key, info)
+	if err := store.Chtimes(key, modTime); err != nil {
+		return err
+	}
+	if info, err = store.Stat(key); err != nil {
+		return err
+	}
+	v, _ := ReadValidators(store, key)
+	index.Refreshed(info.Key, info.ModTime, v.ETag, v.LastModified)
+	if !recorded {
+		return nil
+	}
+	d.ModTime = info.ModTime.UnixNano()
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return storage.WriteFile(store, digestKey(key), data)
+}
+
+// RemoveObject removes a cached object along with its sidecars and its
+// index entry. Objects that are already gone are dropped from the index as
+// well and a remembered upstream error is forgotten, the error still
+// matches fs.ErrNotExist.
+func RemoveObject(store storage.Backend, key string) error {
+	err := store.Remove(key)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	_ = store.Remove(MetaKey(key))
+	_ = store.Remove(digestKey(key))
+	_ = store.Remove(negativeKey(key))
+	index.Remove(storage.CleanKey(key))
+	return err
+}
+
+// ScrubResult is the outcome of a scrub.
+type ScrubResult struct {
+	Checked  int
+	Recorded int
+	// Corrupt lists the removed objects, Kept the corrupt ones that have no
+	// upstream to fetch them from again.
+	Corrupt []string
+	Kept    []string
+}
+
+// ScrubScope tells Scrub what to do with the objects below prefixes.
+type ScrubScope struct {
+	// Refetch are the prefixes of proxy repos. Corrupt objects below them
+	// are removed, so they are fetched again. Corrupt objects elsewhere,
+	// like hosted uploads, are only reported.
+	Refetch []string
+	// Skip are prefixes that are not scrubbed at all, like the directories
+	// of dir mode repos inside the cache.
+	Skip []string
+}
+
+func hasPrefix(key string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Scrub hashes every cached object below prefix again. Objects that don't
+// match their recorded digests anymore are reported as a security event
+// and removed when scope allows it. Objects without valid digests get them
+// recorded.
+func Scrub(store storage.Backend, prefix string, scope ScrubScope) (ScrubResult, error) {
+	var result ScrubResult
+	var keys []storage.ObjectInfo
+	err := store.Walk(prefix, func(info storage.ObjectInfo) error {
+		if !IsHiddenKey(info.Key) && !hasPrefix(info.Key, scope.Skip) {
+			keys = append(keys, info)
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	for _, info := range keys {
+		d, recorded := readDigests(store, info.Key, info)
+		algorithms := []string{"sha256"}
+		if recorded {
+			algorithms = algorithms[:0]
+			for algorithm := range d.Hashes {
+				algorithms = append(algorithms, algorithm)
+			}
+		}
+		hashes, err := hashObject(store, info.Key, algorithms)
+		if err != nil {
+			return result, err
+		}
+		result.Checked++
+
+		if !recorded {
+			if err := recordDigests(store, info.Key, info, hashes); err != nil {
+				return result, err
+			}
+			result.Recorded++
+			continue
+		}
+		for algorithm, sum := range hashes {
+			if sum == d.Hashes[algorithm] {
+				continue
+			}
+			_ = IntegrityFailure(info.Key, algorithm+":"+sum, algorithm+":"+d.Hashes[algorithm])
+			if !hasPrefix(info.Key, scope.Refetch) {
+				result.Kept = append(result.Kept, info.Key)
+				break
+			}
+			if err := RemoveObject(store, info.Key); err != nil {
+				return result, err
+			}
+			result.Corrupt = append(result.Corrupt, info.Key)
+			break
+		}
+	}
+	return result, nil
+}
+
+// hashObject hashes the object key with every algorithm in one pass.
+func hashObject(store storage.Backend, key string, algorithms []string) (map[string]string, error) {
+	obj, _, err := store.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	writers := make([]io.Writer, 0, len(algorithms))
+	sums := map[string]func() []byte{}
+	for _, algorithm := range algorithms {
+		newHash, ok := integrityHashes[algorithm]
+		if !ok {
+			continue
+		}
+		h := newHash()
+		writers = append(writers, h)
+		sums[algorithm] = func() []byte { return h.Sum(nil) }
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), obj); err != nil {
+		return nil, err
+	}
+	hashes := make(map[string]string, len(sums))
+	for algorithm, sum := range sums {
+		hashes[algorithm] = hex.EncodeToString(sum())
+	}
+	return hashes, nil
+}
+
+// IsHiddenKey reports whether key belongs to a hidden tree like .meta,
+// which holds sidecars instead of cached objects.
+func IsHiddenKey(key string) bool {
+	for _, part := range strings.Split(key, "/") {
+		if strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+	return false
+}
@@ -1,103 +1,107 @@
 package misc
 
 import (
-	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"math/big"
 	"net/http"
-	"os"
-	"path/filepath"
+	"sync"
 	"time"
 
+	"github.com/psvmcc/hub/pkg/storage"
 	"github.com/psvmcc/hub/pkg/types"
 )
 
-func DownloadFile(url, destination string, headers types.RequestHeaders) (code int, err error) {
-	client := &http.Client{}
-
-	var req *http.Request
-	var response *http.Response
+// DownloadFile fetches url into key. Concurrent calls for the same key are
+// coalesced into one upstream request and all of them get its result.
+func DownloadFile(store storage.Backend, url, key string, headers types.RequestHeaders) (code int, err error) {
+	return startDownload(store, url, key, headers, nil).Wait()
+}
 
-	req, err = http.NewRequest("GET", url, http.NoBody)
-	if err != nil {
-		code = http.StatusBadRequest
+// DownloadFileVerified is DownloadFile with a check of the downloaded
+// content. Content that fails it is dropped and the error of verify is
+// returned. When the call joined a running download without a check, the
+// committed object is checked once the download finished.
+func DownloadFileVerified(store storage.Backend, url, key string, headers types.RequestHeaders, verify Verifier) (code int, err error) {
+	d := startDownload(store, url, key, headers, verify)
+	code, err = d.Wait()
+	if err != nil || verify == nil || d.verify != nil {
 		return code, err
 	}
-	req.Header.Set("User-Agent", "hub")
+	return verifyObject(store, key, verify)
+}
 
-	for k, v := range headers {
-		req.Header.Set(k, v)
+// verifyObject runs verify on the cached object key. An object that fails
+// is removed.
+func verifyObject(store storage.Backend, key string, verify Verifier) (code int, err error) {
+	obj, info, err := store.Open(key)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to open destination: %v", err)
 	}
-
-	response, err = client.Do(req)
+	r, ok := obj.(io.ReaderAt)
+	if !ok {
+		r = &seekReaderAt{obj: obj}
+	}
+	err = verify(r, info.Size)
+	obj.Close()
 	if err != nil {
-		code = http.StatusBadGateway
-		return code, err
+		_ = RemoveObject(store, key)
+		return http.StatusBadGateway, err
 	}
-	defer response.Body.Close()
+	return http.StatusOK, nil
+}
 
-	if response.StatusCode != http.StatusOK {
-		err = fmt.Errorf("upstream returned %s", response.Status)
-		code = response.StatusCode
-		return code, err
-	}
+// seekReaderAt reads a stored object that can only seek at offsets.
+type seekReaderAt struct {
+	mu  sync.Mutex
+	obj storage.Object
+}
 
-	if err = os.MkdirAll(filepath.Dir(destination), 0o750); err != nil {
-		err = fmt.Errorf("failed to create destination directory: %v", err)
-		code = http.StatusConflict
-		return code, err
+func (r *seekReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.obj.Seek(off, io.SeekStart); err != nil {
+		return 0, err
 	}
-
-	n, err := rand.Int(rand.Reader, big.NewInt(1000))
-	if err != nil {
-		code = http.StatusInternalServerError
-		return code, err
+	n, err := io.ReadFull(r.obj, p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
 	}
+	return n, err
+}
 
-	tempFileName := fmt.Sprintf(".tmp.%s.%d.%d", filepath.Base(filepath.Clean(destination)), time.Now().UnixNano(), n.Int64())
-	tempFilePath := fmt.Sprintf("%s/%s", filepath.Dir(filepath.Clean(destination)), tempFileName)
-	tempFile, err := os.Create(filepath.Join(filepath.Dir(destination), filepath.Clean(tempFileName)))
+// storeBody writes the upstream response body under key along with its
+// validators.
+func storeBody(store storage.Backend, key string, response *http.Response) (code int, err error) {
+	w, err := store.Create(key)
 	if err != nil {
 		err = fmt.Errorf("failed to create temporary file: %v", err)
 		code = http.StatusInternalServerError
 		return code, err
 	}
-	defer os.Remove(tempFile.Name())
+	defer func() { _ = w.Abort() }()
 
-	_, err = io.Copy(tempFile, response.Body)
-	if err != nil {
+	hash := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(w, hash), response.Body); err != nil {
 		err = fmt.Errorf("failed to copy response body to file: %v", err)
 		code = http.StatusBadRequest
 		return code, err
 	}
 
-	if lastModifiedHeader := response.Header.Get("Last-Modified"); lastModifiedHeader != "" {
-		var lastModifiedTime time.Time
-		if lastModifiedTime, err = time.Parse(http.TimeFormat, lastModifiedHeader); err == nil {
-			var fileInfo os.FileInfo
-			fileInfo, err = tempFile.Stat()
-			if err != nil {
-				err = fmt.Errorf("failed to get file info: %v", err)
-				code = http.StatusInternalServerError
-				return code, err
-			}
-			if err = os.Chtimes(tempFilePath, fileInfo.ModTime(), lastModifiedTime); err != nil {
-				err = fmt.Errorf("failed to set last-modified time: %v", err)
-				code = http.StatusInternalServerError
-				return code, err
-			}
-		}
+	if err = w.Commit(time.Now()); err != nil {
+		err = fmt.Errorf("failed to commit destination: %v", err)
+		code = http.StatusInternalServerError
+		return code, err
 	}
-	err = tempFile.Close()
-	if err != nil {
-		err = fmt.Errorf("tempFile close error: %v", err)
+	if err = writeValidators(store, key, response.Header); err != nil {
+		err = fmt.Errorf("failed to save validators: %v", err)
 		code = http.StatusInternalServerError
 		return code, err
 	}
-
-	if err := os.Rename(tempFile.Name(), destination); err != nil {
-		err = fmt.Errorf("failed to rename temporary file to destination: %v", err)
+	if err = recordObject(store, key, response.Request.URL.Redacted(), response.Header, hex.EncodeToString(hash.Sum(nil))); err != nil {
+		err = fmt.Errorf("failed to record object: %v", err)
 		code = http.StatusInternalServerError
 		return code, err
 	}
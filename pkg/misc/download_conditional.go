@@ -1,21 +1,23 @@
 package misc
 
 import (
-	"crypto/rand"
 	"fmt"
-	"io"
-	"math/big"
 	"net/http"
-	"os"
-	"path/filepath"
-	"time"
 
+	"github.com/psvmcc/hub/pkg/storage"
 	"github.com/psvmcc/hub/pkg/types"
 )
 
-func DownloadFileConditional(url, destination string, headers types.RequestHeaders, etag, lastModified string) (code int, newETag, newLastModified string, notModified bool, err error) {
-	client := &http.Client{}
+// DownloadFileConditional revalidates key against url. Concurrent calls for
+// the same key are coalesced like in DownloadFile.
+func DownloadFileConditional(store storage.Backend, url, key string, headers types.RequestHeaders, etag, lastModified string) (code int, newETag, newLastModified string, notModified bool, err error) {
+	call := coalesce(key, func(call *inflightCall) {
+		call.code, call.etag, call.lastModified, call.notModified, call.err = downloadFileConditional(store, url, key, headers, etag, lastModified)
+	})
+	return call.code, call.etag, call.lastModified, call.notModified, call.err
+}
 
+func downloadFileConditional(store storage.Backend, url, key string, headers types.RequestHeaders, etag, lastModified string) (code int, newETag, newLastModified string, notModified bool, err error) {
 	var req *http.Request
 	var response *http.Response
 
@@ -35,7 +37,7 @@ func DownloadFileConditional(url, destination string, headers types.RequestHeade
 		req.Header.Set("If-Modified-Since", lastModified)
 	}
 
-	response, err = client.Do(req)
+	response, err = upstreamDo(key, req)
 	if err != nil {
 		code = http.StatusBadGateway
 		return code, "", "", false, err
@@ -56,65 +58,6 @@ func DownloadFileConditional(url, destination string, headers types.RequestHeade
 		return code, newETag, newLastModified, false, err
 	}
 
-	if err = os.MkdirAll(filepath.Dir(destination), 0o750); err != nil {
-		err = fmt.Errorf("failed to create destination directory: %v", err)
-		code = http.StatusConflict
-		return code, newETag, newLastModified, false, err
-	}
-
-	n, err := rand.Int(rand.Reader, big.NewInt(1000))
-	if err != nil {
-		code = http.StatusInternalServerError
-		return code, newETag, newLastModified, false, err
-	}
-
-	tempFileName := fmt.Sprintf(".tmp.%s.%d.%d", filepath.Base(filepath.Clean(destination)), time.Now().UnixNano(), n.Int64())
-	tempFilePath := filepath.Join(filepath.Dir(filepath.Clean(destination)), tempFileName)
-	tempFile, err := os.Create(filepath.Clean(tempFilePath))
-	if err != nil {
-		err = fmt.Errorf("failed to create temporary file: %v", err)
-		code = http.StatusInternalServerError
-		return code, newETag, newLastModified, false, err
-	}
-	defer os.Remove(tempFile.Name())
-
-	_, err = io.Copy(tempFile, response.Body)
-	if err != nil {
-		err = fmt.Errorf("failed to copy response body to file: %v", err)
-		code = http.StatusBadRequest
-		return code, newETag, newLastModified, false, err
-	}
-
-	if newLastModified != "" {
-		var lastModifiedTime time.Time
-		if lastModifiedTime, err = time.Parse(http.TimeFormat, newLastModified); err == nil {
-			var fileInfo os.FileInfo
-			fileInfo, err = tempFile.Stat()
-			if err != nil {
-				err = fmt.Errorf("failed to get file info: %v", err)
-				code = http.StatusInternalServerError
-				return code, newETag, newLastModified, false, err
-			}
-			if err = os.Chtimes(tempFilePath, fileInfo.ModTime(), lastModifiedTime); err != nil {
-				err = fmt.Errorf("failed to set last-modified time: %v", err)
-				code = http.StatusInternalServerError
-				return code, newETag, newLastModified, false, err
-			}
-		}
-	}
-	err = tempFile.Close()
-	if err != nil {
-		err = fmt.Errorf("tempFile close error: %v", err)
-		code = http.StatusInternalServerError
-		return code, newETag, newLastModified, false, err
-	}
-
-	if err := os.Rename(tempFile.Name(), destination); err != nil {
-		err = fmt.Errorf("failed to rename temporary file to destination: %v", err)
-		code = http.StatusInternalServerError
-		return code, newETag, newLastModified, false, err
-	}
-
-	code = http.StatusOK
-	return code, newETag, newLastModified, false, nil
+	code, err = storeBody(store, key, response)
+	return code, newETag, newLastModified, false, err
 }
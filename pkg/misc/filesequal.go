@@ -1,27 +1,28 @@
 package misc
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"net/http"
-	"os"
 	"time"
+
+	"github.com/psvmcc/hub/pkg/storage"
 )
 
-func FilesEqual(url, destination string) (bool, error) {
-	localFileInfo, err := os.Stat(destination)
-	if os.IsNotExist(err) {
+func FilesEqual(store storage.Backend, url, key string) (bool, error) {
+	localFileInfo, err := store.Stat(key)
+	if errors.Is(err, fs.ErrNotExist) {
 		return false, nil
 	} else if err != nil {
 		return false, err
 	}
 
-	client := &http.Client{
-		CheckRedirect: func(_ *http.Request, _ []*http.Request) error {
-			return nil
-		},
+	req, err := http.NewRequest(http.MethodHead, url, http.NoBody)
+	if err != nil {
+		return false, err
 	}
-
-	response, err := client.Head(url)
+	response, err := upstreamDo(key, req)
 	if err != nil {
 		return false, err
 	}
@@ -37,5 +38,7 @@ func FilesEqual(url, destination string) (bool, error) {
 		return false, err
 	}
 
-	return localFileInfo.ModTime().Equal(remoteModTime) && localFileInfo.Size() == response.ContentLength, nil
+	// The cached copy is current when it was fetched after the last upstream
+	// change.
+	return !remoteModTime.After(localFileInfo.ModTime) && localFileInfo.Size == response.ContentLength, nil
 }
@@ -0,0 +1,97 @@
+package misc
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/psvmcc/hub/pkg/index"
+	"github.com/psvmcc/hub/pkg/storage"
+)
+
+// recordObject records a new object of key with its sha256 in the digests
+// sidecar and in the cache index. header is the upstream response the
+// object came from, nil for uploads.
+func recordObject(store storage.Backend, key, upstream string, header http.Header, sum string) error {
+	info, err := store.Stat(key)
+	if err != nil {
+		return err
+	}
+	if err := recordDigests(store, key, info, map[string]string{"sha256": sum}); err != nil {
+		return err
+	}
+	index.Put(indexEntry(info, upstream, header, sum))
+	return nil
+}
+
+func indexEntry(info storage.ObjectInfo, upstream string, header http.Header, sum string) index.Entry {
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(info.Key))
+	}
+	return index.Entry{
+		Key:          info.Key,
+		Repo:         metricsRepo(info.Key),
+		URL:          upstream,
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+		SHA256:       sum,
+		Size:         info.Size,
+		ContentType:  contentType,
+		FetchedAt:    info.ModTime,
+	}
+}
+
+// reconciling serializes ReconcileIndex, which runs on start and on
+// request of the admin API.
+var reconciling sync.Mutex
+
+// ReconcileIndex brings the cache index in line with the objects below
+// prefixes, such as objects written by another replica sharing the
+// storage or before the index existed. Entries of objects that are gone or
+// not below prefixes are dropped. It returns the number of added and
+// removed entries.
+func ReconcileIndex(store storage.Backend, prefixes []string) (added, removed int, err error) {
+	reconciling.Lock()
+	defer reconciling.Unlock()
+	start := time.Now()
+	seen := map[string]bool{}
+	for _, prefix := range prefixes {
+		err = store.Walk(prefix, func(info storage.ObjectInfo) error {
+			if IsHiddenKey(info.Key) {
+				return nil
+			}
+			seen[info.Key] = true
+			e, ok := index.Get(info.Key)
+			if ok && e.Size == info.Size && e.FetchedAt.Equal(info.ModTime) {
+				return nil
+			}
+			header := http.Header{}
+			if v, err := ReadValidators(store, info.Key); err == nil {
+				header.Set("ETag", v.ETag)
+				header.Set("Last-Modified", v.LastModified)
+			}
+			var sum string
+			if d, ok := readDigests(store, info.Key, info); ok {
+				sum = d.Hashes["sha256"]
+			}
+			index.Put(indexEntry(info, e.URL, header, sum))
+			added++
+			return nil
+		})
+		if err != nil {
+			return added, removed, err
+		}
+	}
+	index.Retain(func(e index.Entry) bool {
+		// Objects fetched meanwhile may be missed by the walk.
+		keep := seen[e.Key] || e.FetchedAt.After(start)
+		if !keep {
+			removed++
+		}
+		return keep
+	})
+	return added, removed, nil
+}
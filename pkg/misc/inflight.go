@@ -0,0 +1,65 @@
+package misc
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// inflightCall is one conditional upstream fetch for a cache key. Requests
+// for the same key while it runs wait for it and share its result instead
+// of starting their own request.
+type inflightCall struct {
+	done chan struct{}
+
+	code         int
+	etag         string
+	lastModified string
+	notModified  bool
+	err          error
+}
+
+var inflight = struct {
+	sync.Mutex
+	calls map[string]*inflightCall
+}{calls: map[string]*inflightCall{}}
+
+// coalesce runs fetch for key unless a fetch for the same key is already
+// running, in which case it waits for that one. Callers can't tell the two
+// apart, a shared result is handled like an own one and reported with the
+// same X-Cache-Status. Shared results are counted by
+// hub_download_coalesced_total.
+func coalesce(key string, fetch func(call *inflightCall)) *inflightCall {
+	inflight.Lock()
+	if call, ok := inflight.calls[key]; ok {
+		inflight.Unlock()
+		metrics.GetOrCreateCounter(fmt.Sprintf("hub_download_coalesced_total{repo=%q}", metricsRepo(key))).Inc()
+		<-call.done
+		return call
+	}
+	call := &inflightCall{done: make(chan struct{})}
+	inflight.calls[key] = call
+	inflight.Unlock()
+
+	defer func() {
+		inflight.Lock()
+		delete(inflight.calls, key)
+		inflight.Unlock()
+		close(call.done)
+	}()
+	metrics.GetOrCreateCounter(fmt.Sprintf("hub_download_upstream_total{repo=%q}", metricsRepo(key))).Inc()
+	fetch(call)
+	return call
+}
+
+// metricsRepo returns the ecosystem/repo part of a cache key, such as
+// pypi/pypi.org, to keep metric label cardinality low.
+func metricsRepo(key string) string {
+	parts := strings.SplitN(strings.TrimPrefix(key, "/"), "/", 3)
+	if len(parts) < 2 {
+		return parts[0]
+	}
+	return parts[0] + "/" + parts[1]
+}
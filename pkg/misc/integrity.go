@@ -0,0 +1,154 @@
+package misc
+
+import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec // npm packages published before integrity only have dist.shasum
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/psvmcc/hub/pkg/storage"
+
+	"github.com/VictoriaMetrics/metrics"
+	"go.uber.org/zap"
+)
+
+// ErrIntegrity is returned for content that doesn't match the digest its
+// metadata announces. Such content is never cached.
+var ErrIntegrity = errors.New("integrity check failed")
+
+// Verifier checks the complete content of a download before it is
+// committed to the cache.
+type Verifier func(r io.ReaderAt, size int64) error
+
+// Integrity is the expected digest of an artifact.
+type Integrity struct {
+	Algorithm string
+	Sum       []byte
+}
+
+var integrityHashes = map[string]func() hash.Hash{
+	"sha512": sha512.New,
+	"sha384": sha512.New384,
+	"sha256": sha256.New,
+	"sha1":   sha1.New, //nolint:gosec // see the import
+}
+
+// integrityStrength orders the algorithms of a subresource integrity
+// string, the strongest one is checked.
+var integrityStrength = []string{"sha512", "sha384", "sha256", "sha1"}
+
+// ParseSRI returns the strongest digest of a subresource integrity string
+// such as npm dist.integrity.
+func ParseSRI(sri string) (Integrity, bool) {
+	found := map[string]Integrity{}
+	for _, field := range strings.Fields(sri) {
+		algorithm, value, ok := strings.Cut(field, "-")
+		if !ok {
+			continue
+		}
+		// Options after "?" are reserved and ignored.
+		value, _, _ = strings.Cut(value, "?")
+		sum, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			continue
+		}
+		found[algorithm] = Integrity{Algorithm: algorithm, Sum: sum}
+	}
+	for _, algorithm := range integrityStrength {
+		if i, ok := found[algorithm]; ok {
+			return i, true
+		}
+	}
+	return Integrity{}, false
+}
+
+// ParseHexDigest returns a digest given as hex, like npm dist.shasum.
+func ParseHexDigest(algorithm, value string) (Integrity, bool) {
+	sum, err := hex.DecodeString(value)
+	if err != nil || integrityHashes[algorithm] == nil {
+		return Integrity{}, false
+	}
+	return Integrity{Algorithm: algorithm, Sum: sum}, true
+}
+
+func (i Integrity) IsSet() bool {
+	return len(i.Sum) > 0 && integrityHashes[i.Algorithm] != nil
+}
+
+func (i Integrity) String() string {
+	return i.Algorithm + "-" + base64.StdEncoding.EncodeToString(i.Sum)
+}
+
+// check hashes r and reports a mismatch as a security event of key.
+func (i Integrity) check(key string, r io.Reader) error {
+	h := integrityHashes[i.Algorithm]()
+	if _, err := io.Copy(h, r); err != nil {
+		return fmt.Errorf("failed to calculate hash: %v", err)
+	}
+	got := Integrity{Algorithm: i.Algorithm, Sum: h.Sum(nil)}
+	if bytes.Equal(got.Sum, i.Sum) {
+		return nil
+	}
+	return IntegrityFailure(key, got.String(), i.String())
+}
+
+// IntegrityFailure reports content of key that hashes to got instead of
+// want as a security event and returns the matching ErrIntegrity.
+func IntegrityFailure(key, got, want string) error {
+	zap.S().Named("security").Errorf("[Integrity] %s is %s but its metadata expects %s", key, got, want)
+	metrics.GetOrCreateCounter(fmt.Sprintf("hub_integrity_failures_total{repo=%q}", metricsRepo(key))).Inc()
+	return fmt.Errorf("%w for %s: expected %s", ErrIntegrity, key, want)
+}
+
+// QuarantineKey returns where content of key that failed verification is
+// kept for inspection, in a hidden tree of the repo like sidecars.
+func QuarantineKey(key string) string {
+	parts := strings.SplitN(storage.CleanKey(key), "/", 3)
+	if len(parts) < 3 {
+		return ".quarantine/" + storage.CleanKey(key)
+	}
+	return parts[0] + "/" + parts[1] + "/.quarantine/" + parts[2]
+}
+
+// Quarantine keeps a copy of content of key that failed verification. It
+// is never served.
+func Quarantine(store storage.Backend, key string, r io.ReaderAt, size int64) error {
+	w, err := store.Create(QuarantineKey(key))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, io.NewSectionReader(r, 0, size)); err != nil {
+		_ = w.Abort()
+		return err
+	}
+	return w.Commit(time.Now())
+}
+
+// Verifier returns a check of downloads of key against the digest.
+func (i Integrity) Verifier(key string) Verifier {
+	return func(r io.ReaderAt, size int64) error {
+		return i.check(key, io.NewSectionReader(r, 0, size))
+	}
+}
+
+// CheckObject verifies the cached object key against the digest. The
+// digest recorded for key is used while key is unchanged.
+func (i Integrity) CheckObject(store storage.Backend, key string) error {
+	sum, err := ObjectDigest(store, key, i.Algorithm)
+	if err != nil {
+		return err
+	}
+	got, _ := ParseHexDigest(i.Algorithm, sum)
+	if bytes.Equal(got.Sum, i.Sum) {
+		return nil
+	}
+	return IntegrityFailure(key, got.String(), i.String())
+}
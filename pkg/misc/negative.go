@@ -0,0 +1,86 @@
+package misc
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/psvmcc/hub/pkg/storage"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// ErrNegativeHit is returned instead of asking upstream again while a
+// recent 404 or 410 for a key is remembered.
+var ErrNegativeHit = errors.New("upstream recently answered not found")
+
+var negativeTTL = struct {
+	sync.RWMutex
+	repos map[string]time.Duration
+}{repos: map[string]time.Duration{}}
+
+// SetNegativeTTL sets how long 404 and 410 answers are remembered for the
+// keys of repo, such as npm/npmjs. Zero disables negative caching.
+func SetNegativeTTL(repo string, ttl time.Duration) {
+	negativeTTL.Lock()
+	defer negativeTTL.Unlock()
+	negativeTTL.repos[repo] = ttl
+}
+
+func repoNegativeTTL(key string) time.Duration {
+	negativeTTL.RLock()
+	defer negativeTTL.RUnlock()
+	return negativeTTL.repos[metricsRepo(key)]
+}
+
+// negativeKey returns the marker that remembers a not found answer for
+// key. It lives next to the validators of key.
+func negativeKey(key string) string {
+	return strings.TrimSuffix(MetaKey(key), ".json") + ".negative"
+}
+
+// checkNegative returns the remembered upstream code for key. A marker
+// never hides key once it is cached.
+func checkNegative(store storage.Backend, key string) (code int, ok bool) {
+	ttl := repoNegativeTTL(key)
+	if ttl <= 0 {
+		return 0, false
+	}
+	info, err := store.Stat(negativeKey(key))
+	if err != nil || time.Since(info.ModTime) >= ttl {
+		return 0, false
+	}
+	if storage.Exists(store, key) {
+		return 0, false
+	}
+	data, err := storage.ReadFile(store, negativeKey(key))
+	if err != nil {
+		return 0, false
+	}
+	code, err = strconv.Atoi(string(data))
+	if err != nil {
+		return 0, false
+	}
+	metrics.GetOrCreateCounter(fmt.Sprintf("hub_download_negative_hits_total{repo=%q}", metricsRepo(key))).Inc()
+	return code, true
+}
+
+// updateNegative remembers a not found answer for key, or drops the marker
+// once upstream served key.
+func updateNegative(store storage.Backend, key string, code int) {
+	if repoNegativeTTL(key) <= 0 {
+		return
+	}
+	switch code {
+	case http.StatusOK:
+		_ = store.Remove(negativeKey(key))
+	case http.StatusNotFound, http.StatusGone:
+		if !storage.Exists(store, key) {
+			_ = storage.WriteFile(store, negativeKey(key), []byte(strconv.Itoa(code)))
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package misc
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrOffline is returned instead of contacting upstream for the keys of an
+// offline repo.
+var ErrOffline = errors.New("repo is offline and the content is not cached")
+
+var offline = struct {
+	sync.RWMutex
+	repos map[string]bool
+}{repos: map[string]bool{}}
+
+// SetOffline switches repo, such as npm/npmjs, to serving from the cache
+// only.
+func SetOffline(repo string, enabled bool) {
+	offline.Lock()
+	defer offline.Unlock()
+	offline.repos[repo] = enabled
+}
+
+// IsOffline reports whether upstream must not be contacted for key.
+func IsOffline(key string) bool {
+	offline.RLock()
+	defer offline.RUnlock()
+	return offline.repos[metricsRepo(key)]
+}
@@ -0,0 +1,123 @@
+package misc
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/psvmcc/hub/pkg/storage"
+)
+
+// maxResumes limits how often a single download continues after its body
+// broke off.
+const maxResumes = 5
+
+// partialInfo describes the object whose beginning is kept after a failed
+// download. Size is -1 when upstream didn't tell.
+type partialInfo struct {
+	ETag         string `json:"etag"`
+	LastModified string `json:"last_modified"`
+	Size         int64  `json:"size"`
+}
+
+func newPartialInfo(header http.Header, size int64) partialInfo {
+	return partialInfo{ETag: header.Get("ETag"), LastModified: header.Get("Last-Modified"), Size: size}
+}
+
+// ifRange returns the validator for If-Range. Weak ETags can't be used
+// there, so Last-Modified is the fallback.
+func (p partialInfo) ifRange() string {
+	if p.ETag != "" && !strings.HasPrefix(p.ETag, "W/") {
+		return p.ETag
+	}
+	return p.LastModified
+}
+
+// resumable reports whether the rest of the object can be requested without
+// risking to glue two versions together.
+func (p partialInfo) resumable() bool {
+	return p.ifRange() != ""
+}
+
+// matches reports whether a range answer belongs to the same object.
+func (p partialInfo) matches(header http.Header) bool {
+	if p.ETag != "" {
+		return header.Get("ETag") == p.ETag
+	}
+	return header.Get("Last-Modified") == p.LastModified
+}
+
+// partialKey returns the sidecar that describes the kept data of key.
+func partialKey(key string) string {
+	return strings.TrimSuffix(MetaKey(key), ".json") + ".partial"
+}
+
+// resumePartial claims the data kept for key by an earlier download.
+// Data that can't be continued is dropped.
+func resumePartial(store storage.Backend, key string) (w storage.Writer, offset int64, p partialInfo, ok bool) {
+	resumer, ok := store.(storage.Resumer)
+	if !ok {
+		return nil, 0, p, false
+	}
+	w, offset, err := resumer.Resume(key)
+	if err != nil {
+		return nil, 0, p, false
+	}
+	data, err := storage.ReadFile(store, partialKey(key))
+	if err == nil {
+		err = json.Unmarshal(data, &p)
+	}
+	if err != nil || !p.resumable() || offset == 0 || p.Size >= 0 && offset >= p.Size {
+		_ = w.Abort()
+		_ = store.Remove(partialKey(key))
+		return nil, 0, partialInfo{}, false
+	}
+	return w, offset, p, true
+}
+
+// suspendPartial keeps the data of w for the next download of key, or
+// discards it when the backend can't keep it.
+func suspendPartial(store storage.Backend, key string, w storage.Writer, p partialInfo) {
+	resumer, ok := store.(storage.Resumer)
+	if ok {
+		data, err := json.Marshal(p)
+		if err == nil {
+			err = storage.WriteFile(store, partialKey(key), data)
+		}
+		if err == nil && resumer.Suspend(key, w) == nil {
+			return
+		}
+	}
+	_ = w.Abort()
+	_ = store.Remove(partialKey(key))
+}
+
+// parseContentRange returns the first byte and the total size of a
+// "bytes first-last/total" header. The total is -1 when it is unknown.
+func parseContentRange(value string) (first, total int64, ok bool) {
+	value, found := strings.CutPrefix(value, "bytes ")
+	if !found {
+		return 0, 0, false
+	}
+	byteRange, size, found := strings.Cut(value, "/")
+	if !found {
+		return 0, 0, false
+	}
+	start, _, found := strings.Cut(byteRange, "-")
+	if !found {
+		return 0, 0, false
+	}
+	first, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if size == "*" {
+		return first, -1, true
+	}
+	total, err = strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return first, total, true
+}
@@ -0,0 +1,90 @@
+package misc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/psvmcc/hub/pkg/index"
+	"github.com/psvmcc/hub/pkg/storage"
+)
+
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// SaveFile writes src under key. When expectedSha256 is set the object is
+// only committed if the content matches.
+func SaveFile(store storage.Backend, src io.Reader, key, expectedSha256 string) (sha string, size int64, err error) {
+	w, err := store.Create(key)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer func() { _ = w.Abort() }()
+
+	hash := sha256.New()
+	size, err = io.Copy(io.MultiWriter(w, hash), src)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to copy body to file: %v", err)
+	}
+
+	sha = hex.EncodeToString(hash.Sum(nil))
+	if expectedSha256 != "" && !strings.EqualFold(expectedSha256, sha) {
+		return sha, size, fmt.Errorf("%w: expected %s, got %s", ErrChecksumMismatch, expectedSha256, sha)
+	}
+
+	if err = w.Commit(time.Time{}); err != nil {
+		return "", 0, fmt.Errorf("failed to commit destination: %v", err)
+	}
+	if err = recordObject(store, key, "", nil, sha); err != nil {
+		return "", 0, fmt.Errorf("failed to record object: %v", err)
+	}
+	return sha, size, nil
+}
+
+// ImportObject writes src under key as a copy of an object cached
+// elsewhere. The modification time, upstream URL and validators of the
+// original are kept, so the copy is as fresh as the original and
+// revalidated the same way. The import counts as an access of the copy for
+// eviction. The object is only committed if the content matches sum.
+func ImportObject(store storage.Backend, src io.Reader, key, sum string, modTime time.Time, upstream string, v Validators) error {
+	w, err := store.Create(key)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer func() { _ = w.Abort() }()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, hash), src); err != nil {
+		return fmt.Errorf("failed to copy body to file: %v", err)
+	}
+	if got := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(sum, got) {
+		return fmt.Errorf("%w: expected %s, got %s", ErrChecksumMismatch, sum, got)
+	}
+
+	if err := w.Commit(modTime); err != nil {
+		return fmt.Errorf("failed to commit destination: %v", err)
+	}
+	header := http.Header{}
+	if v.ETag != "" {
+		header.Set("ETag", v.ETag)
+	}
+	if v.LastModified != "" {
+		header.Set("Last-Modified", v.LastModified)
+	}
+	if len(header) == 0 {
+		// Validators of an object replaced by the copy don't apply to it.
+		_ = store.Remove(MetaKey(key))
+	}
+	if err := writeValidators(store, key, header); err != nil {
+		return fmt.Errorf("failed to write validators: %v", err)
+	}
+	if err := recordObject(store, key, upstream, header, strings.ToLower(sum)); err != nil {
+		return fmt.Errorf("failed to record object: %v", err)
+	}
+	index.Imported(storage.CleanKey(key), time.Now())
+	return nil
+}
@@ -7,6 +7,8 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+
+	"github.com/psvmcc/hub/pkg/storage"
 )
 
 func CalculateSHA256(filePath string) (string, error) {
@@ -26,3 +28,9 @@ func CalculateSHA256(filePath string) (string, error) {
 
 	return hashString, nil
 }
+
+// CalculateObjectSHA256 returns the sha256 of a stored object, hashing it
+// only when no digest is recorded for its current content.
+func CalculateObjectSHA256(store storage.Backend, key string) (string, error) {
+	return ObjectDigest(store, key, "sha256")
+}
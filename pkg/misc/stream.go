@@ -0,0 +1,385 @@
+package misc
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/psvmcc/hub/pkg/storage"
+	"github.com/psvmcc/hub/pkg/types"
+
+	"github.com/VictoriaMetrics/metrics"
+	"go.uber.org/zap"
+)
+
+// Download is an upstream fetch written into the cache. Any number of
+// readers can follow it while it runs, the object is only committed once
+// the whole body was received.
+type Download struct {
+	Key string
+	// Header and Size are set once the upstream response is accepted. Size
+	// is -1 when upstream didn't send a Content-Length.
+	Header http.Header
+	Size   int64
+
+	// upstream is the URL the object is fetched from, which is the one of a
+	// fallback upstream when the primary failed.
+	upstream string
+
+	store  storage.Backend
+	w      storage.Writer
+	verify Verifier
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	written   int64
+	streaming bool
+	finished  bool
+	code      int
+	err       error
+
+	// fileMu keeps the writer open while readers use ReadAt on it.
+	fileMu  sync.RWMutex
+	started chan struct{}
+	done    chan struct{}
+}
+
+var downloads = struct {
+	sync.Mutex
+	running map[string]*Download
+}{running: map[string]*Download{}}
+
+// startDownload returns the running download of key, or starts a new one in
+// the background. The download continues when the requesting client goes
+// away, so the cache is still filled. Offline repos and remembered not
+// found answers fail without contacting upstream. A download joined by
+// later calls keeps the verifier it was started with, see
+// DownloadFileVerified for joins that bring one.
+func startDownload(store storage.Backend, url, key string, headers types.RequestHeaders, verify Verifier) *Download {
+	if IsOffline(key) {
+		return finishedDownload(store, key, http.StatusNotFound, ErrOffline)
+	}
+	if code, ok := checkNegative(store, key); ok {
+		return finishedDownload(store, key, code, fmt.Errorf("%w: %d", ErrNegativeHit, code))
+	}
+
+	downloads.Lock()
+	if d, ok := downloads.running[key]; ok {
+		downloads.Unlock()
+		metrics.GetOrCreateCounter(fmt.Sprintf("hub_download_coalesced_total{repo=%q}", metricsRepo(key))).Inc()
+		return d
+	}
+	d := &Download{Key: key, Size: -1, store: store, verify: verify, started: make(chan struct{}), done: make(chan struct{})}
+	d.cond = sync.NewCond(&d.mu)
+	downloads.running[key] = d
+	downloads.Unlock()
+
+	metrics.GetOrCreateCounter(fmt.Sprintf("hub_download_upstream_total{repo=%q}", metricsRepo(key))).Inc()
+	go d.run(url, headers)
+	return d
+}
+
+// finishedDownload returns a download that failed without contacting
+// upstream.
+func finishedDownload(store storage.Backend, key string, code int, err error) *Download {
+	d := &Download{Key: key, Size: -1, store: store, finished: true, code: code, err: err, started: make(chan struct{}), done: make(chan struct{})}
+	close(d.started)
+	close(d.done)
+	return d
+}
+
+func (d *Download) run(url string, headers types.RequestHeaders) {
+	code, err := d.fetch(url, headers)
+	updateNegative(d.store, d.Key, code)
+
+	d.mu.Lock()
+	d.finished = true
+	d.code = code
+	d.err = err
+	d.cond.Broadcast()
+	d.mu.Unlock()
+
+	downloads.Lock()
+	delete(downloads.running, d.Key)
+	downloads.Unlock()
+
+	select {
+	case <-d.started:
+	default:
+		close(d.started)
+	}
+	close(d.done)
+}
+
+// fetch downloads the object, continuing data kept by an earlier download
+// when there is some. A body that breaks off is continued with a range
+// request, and kept for the next download if that doesn't work out.
+func (d *Download) fetch(url string, headers types.RequestHeaders) (code int, err error) {
+	var p partialInfo
+	if w, offset, info, ok := resumePartial(d.store, d.Key); ok {
+		d.w, d.written, p = w, offset, info
+	}
+
+	retryable := false
+	for resumes := 0; ; resumes++ {
+		before := d.written
+		code, retryable, err = d.attempt(url, headers, &p)
+		if err == nil || !retryable || !d.streaming || !p.resumable() || resumes >= maxResumes || d.written == before {
+			break
+		}
+		zap.S().Named("download").Warnf("[Resume] %s broke off at %d bytes (%s), resuming", d.Key, d.written, err)
+		metrics.GetOrCreateCounter(fmt.Sprintf("hub_download_resumed_total{repo=%q}", metricsRepo(d.Key))).Inc()
+	}
+
+	if err != nil {
+		if d.w != nil {
+			d.fileMu.Lock()
+			if retryable && d.written > 0 && p.resumable() {
+				suspendPartial(d.store, d.Key, d.w, p)
+			} else {
+				_ = d.w.Abort()
+				_ = d.store.Remove(partialKey(d.Key))
+			}
+			d.fileMu.Unlock()
+		}
+		return code, err
+	}
+
+	d.fileMu.Lock()
+	defer d.fileMu.Unlock()
+	if d.verify != nil {
+		if err := d.verify(d.w, d.written); err != nil {
+			_ = d.w.Abort()
+			_ = d.store.Remove(partialKey(d.Key))
+			return http.StatusBadGateway, err
+		}
+	}
+	sum, err := hashWritten(d.w, d.written)
+	if err != nil {
+		_ = d.w.Abort()
+		return http.StatusInternalServerError, fmt.Errorf("failed to hash destination: %v", err)
+	}
+	// The modification time records when the object was fetched, freshness
+	// policies are based on it.
+	if err := d.w.Commit(time.Now()); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to commit destination: %v", err)
+	}
+	_ = d.store.Remove(partialKey(d.Key))
+	if err := writeValidators(d.store, d.Key, d.Header); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to save validators: %v", err)
+	}
+	if err := recordObject(d.store, d.Key, d.upstream, d.Header, sum); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to record object: %v", err)
+	}
+	return http.StatusOK, nil
+}
+
+// attempt sends one request for the object, asking for the rest of it when
+// data is already written. retryable reports failures of upstream or the
+// network, after which the written data is worth keeping.
+func (d *Download) attempt(url string, headers types.RequestHeaders, p *partialInfo) (code int, retryable bool, err error) {
+	req, err := http.NewRequest("GET", url, http.NoBody)
+	if err != nil {
+		return http.StatusBadRequest, false, err
+	}
+	req.Header.Set("User-Agent", "hub")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	offset := d.written
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", p.ifRange())
+	}
+
+	response, err := upstreamDo(d.Key, req)
+	if err != nil {
+		return http.StatusBadGateway, true, err
+	}
+	defer response.Body.Close()
+
+	size := response.ContentLength
+	switch {
+	case response.StatusCode == http.StatusOK:
+		if offset > 0 && !d.restart() {
+			return http.StatusBadGateway, false, fmt.Errorf("upstream object changed during the download")
+		}
+	case response.StatusCode == http.StatusPartialContent && offset > 0:
+		first, total, ok := parseContentRange(response.Header.Get("Content-Range"))
+		if ok && first == offset && (p.Size < 0 || total == p.Size) && p.matches(response.Header) {
+			size = total
+			break
+		}
+		if !d.restart() {
+			return http.StatusBadGateway, false, fmt.Errorf("upstream answered the range request with another object")
+		}
+		response.Body.Close()
+		return d.attempt(url, headers, p)
+	case response.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		if !d.restart() {
+			return http.StatusBadGateway, false, fmt.Errorf("upstream rejected the range request")
+		}
+		response.Body.Close()
+		return d.attempt(url, headers, p)
+	default:
+		return response.StatusCode, response.StatusCode >= http.StatusInternalServerError, fmt.Errorf("upstream returned %s", response.Status)
+	}
+
+	if d.w == nil {
+		w, err := d.store.Create(d.Key)
+		if err != nil {
+			return http.StatusInternalServerError, false, fmt.Errorf("failed to create temporary file: %v", err)
+		}
+		d.w = w
+	}
+	*p = newPartialInfo(response.Header, size)
+	if !d.streaming {
+		d.Header = response.Header
+		d.upstream = response.Request.URL.Redacted()
+		d.Size = size
+		d.mu.Lock()
+		d.streaming = true
+		d.mu.Unlock()
+		close(d.started)
+	}
+
+	code, err = d.copy(response.Body)
+	return code, err != nil && code != http.StatusInternalServerError, err
+}
+
+// restart drops the written data so the object is fetched from the start.
+// That is only possible as long as nothing was streamed to readers.
+func (d *Download) restart() bool {
+	if d.streaming {
+		return false
+	}
+	_ = d.w.Abort()
+	d.w = nil
+	d.written = 0
+	return true
+}
+
+func (d *Download) copy(body io.Reader) (code int, err error) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, readErr := body.Read(buf)
+		if n > 0 {
+			if _, err := d.w.Write(buf[:n]); err != nil {
+				return http.StatusInternalServerError, fmt.Errorf("failed to write cache file: %v", err)
+			}
+			d.mu.Lock()
+			d.written += int64(n)
+			d.cond.Broadcast()
+			d.mu.Unlock()
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return http.StatusBadRequest, fmt.Errorf("failed to copy response body to file: %v", readErr)
+		}
+	}
+	if d.Size >= 0 && d.written != d.Size {
+		return http.StatusBadGateway, fmt.Errorf("upstream body truncated: got %d of %d bytes", d.written, d.Size)
+	}
+	return http.StatusOK, nil
+}
+
+// Wait blocks until the download has finished and returns its result.
+func (d *Download) Wait() (code int, err error) {
+	<-d.done
+	return d.code, d.err
+}
+
+// NewReader returns a reader of the whole body. It blocks while it caught up
+// with the download and fails if the download fails. The body of a download
+// with a verifier is only read once it passed, so readers never get content
+// that is dropped afterwards.
+func (d *Download) NewReader() io.ReadCloser {
+	return &downloadReader{d: d}
+}
+
+type downloadReader struct {
+	d      *Download
+	offset int64
+	obj    storage.Object
+}
+
+func (r *downloadReader) Read(p []byte) (int, error) {
+	if r.obj != nil {
+		return r.obj.Read(p)
+	}
+	d := r.d
+
+	d.mu.Lock()
+	for (r.offset >= d.written || d.verify != nil) && !d.finished {
+		d.cond.Wait()
+	}
+	written, finished, err := d.written, d.finished, d.err
+	d.mu.Unlock()
+
+	if finished {
+		if err != nil {
+			return 0, err
+		}
+		// The temporary file is gone, continue from the committed object.
+		obj, _, err := d.store.Open(d.Key)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := obj.Seek(r.offset, io.SeekStart); err != nil {
+			obj.Close()
+			return 0, err
+		}
+		r.obj = obj
+		return r.obj.Read(p)
+	}
+
+	if int64(len(p)) > written-r.offset {
+		p = p[:written-r.offset]
+	}
+	d.fileMu.RLock()
+	n, err := d.w.ReadAt(p, r.offset)
+	d.fileMu.RUnlock()
+	r.offset += int64(n)
+	if err != nil && n > 0 {
+		// The writer was committed or aborted after the check above, the
+		// next Read picks up the final state.
+		err = nil
+	}
+	if n == 0 && err != nil {
+		d.mu.Lock()
+		finished = d.finished
+		d.mu.Unlock()
+		if finished {
+			return r.Read(p)
+		}
+	}
+	return n, err
+}
+
+func (r *downloadReader) Close() error {
+	if r.obj != nil {
+		return r.obj.Close()
+	}
+	return nil
+}
+
+// StreamFile starts or joins the download of url into key and returns it as
+// soon as upstream answered, so the body can be sent to the client while it
+// is cached. On failure before the body starts, the upstream status code
+// and error are returned.
+func StreamFile(store storage.Backend, url, key string, headers types.RequestHeaders) (d *Download, code int, err error) {
+	d = startDownload(store, url, key, headers, nil)
+	<-d.started
+
+	d.mu.Lock()
+	streaming := d.streaming
+	d.mu.Unlock()
+	if !streaming {
+		return nil, d.code, d.err
+	}
+	return d, http.StatusOK, nil
+}
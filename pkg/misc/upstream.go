@@ -0,0 +1,213 @@
+package misc
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"go.uber.org/zap"
+)
+
+// upstreamSet is the ordered list of upstream base URLs of a repo. The
+// first one is the URL handlers build their requests with.
+type upstreamSet struct {
+	bases   []string
+	healthy []atomic.Bool
+}
+
+var upstreams = struct {
+	sync.RWMutex
+	repos map[string]*upstreamSet
+}{repos: map[string]*upstreamSet{}}
+
+// SetUpstreams sets the upstream base URLs of repo, such as pypi/pypi.org,
+// in the order they are tried.
+func SetUpstreams(repo string, bases []string) {
+	set := &upstreamSet{healthy: make([]atomic.Bool, len(bases))}
+	for i, base := range bases {
+		set.bases = append(set.bases, strings.TrimSuffix(base, "/"))
+		set.healthy[i].Store(true)
+	}
+	upstreams.Lock()
+	defer upstreams.Unlock()
+	upstreams.repos[repo] = set
+}
+
+// CheckUpstreams sends a HEAD request for path to every upstream of repo
+// each interval. Unhealthy upstreams are tried last until they answer
+// again.
+func CheckUpstreams(repo, path string, interval time.Duration) {
+	upstreams.RLock()
+	set := upstreams.repos[repo]
+	upstreams.RUnlock()
+	if set == nil {
+		return
+	}
+
+	logger := zap.S().Named("upstream")
+	client := *repoClient(repo + "/").Client
+	client.Timeout = interval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for i, base := range set.bases {
+			healthy := checkUpstream(&client, base+path)
+			if set.healthy[i].Swap(healthy) != healthy {
+				if healthy {
+					logger.Infof("[Health] %s of %s is healthy again", base, repo)
+				} else {
+					logger.Warnf("[Health] %s of %s is unhealthy", base, repo)
+				}
+			}
+			value := 0.0
+			if healthy {
+				value = 1
+			}
+			metrics.GetOrCreateGauge(fmt.Sprintf("hub_upstream_healthy{repo=%q,upstream=%q}", repo, base), nil).Set(value)
+		}
+		<-ticker.C
+	}
+}
+
+func checkUpstream(client *http.Client, url string) bool {
+	req, err := http.NewRequest(http.MethodHead, url, http.NoBody)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("User-Agent", "hub")
+	response, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	response.Body.Close()
+	return response.StatusCode < http.StatusInternalServerError
+}
+
+// upstreamURLs returns rawURL rewritten for every upstream of the repo of
+// key, healthy ones first, along with the base URL of each. URLs that
+// don't start with the first upstream, like PyPI file URLs, are returned
+// as they are.
+func upstreamURLs(key, rawURL string) (urls, bases []string) {
+	upstreams.RLock()
+	set := upstreams.repos[metricsRepo(key)]
+	upstreams.RUnlock()
+
+	if set == nil || len(set.bases) == 0 || !hasBase(rawURL, set.bases[0]) {
+		return []string{rawURL}, []string{urlOrigin(rawURL)}
+	}
+	rest := strings.TrimPrefix(rawURL, set.bases[0])
+	var unhealthy []int
+	for i, base := range set.bases {
+		if !set.healthy[i].Load() {
+			unhealthy = append(unhealthy, i)
+			continue
+		}
+		urls = append(urls, base+rest)
+		bases = append(bases, base)
+	}
+	for _, i := range unhealthy {
+		urls = append(urls, set.bases[i]+rest)
+		bases = append(bases, set.bases[i])
+	}
+	return urls, bases
+}
+
+func hasBase(rawURL, base string) bool {
+	if !strings.HasPrefix(rawURL, base) {
+		return false
+	}
+	rest := rawURL[len(base):]
+	return rest == "" || rest[0] == '/' || rest[0] == '?'
+}
+
+func urlOrigin(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// upstreamDo sends req to the upstreams of the repo of key with the
+// client of the repo. Connection errors and 5xx answers move on to the
+// next upstream. When all of them failed the request is retried with
+// backoff, after that the last answer is returned as it is.
+func upstreamDo(key string, req *http.Request) (*http.Response, error) {
+	client := repoClient(key)
+	backoff := client.backoff
+	for attempt := 0; ; attempt++ {
+		response, err := tryUpstreams(client.Client, key, req)
+		if attempt >= client.retries || err == nil && response.StatusCode < http.StatusInternalServerError {
+			return response, err
+		}
+
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = response.Status
+			response.Body.Close()
+		}
+		zap.S().Named("upstream").Warnf("[Upstream] %s %s failed (%s), retrying in %s", req.Method, key, reason, backoff)
+		metrics.GetOrCreateCounter(fmt.Sprintf("hub_upstream_retries_total{repo=%q}", metricsRepo(key))).Inc()
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// RepoDo sends req with the client of the repo of key, so it takes the
+// proxy, TLS and retry settings of the repo. Requests for other hosts than
+// the repo's upstreams go out without its credentials.
+func RepoDo(key string, req *http.Request) (*http.Response, error) {
+	return upstreamDo(key, req)
+}
+
+func tryUpstreams(client *http.Client, key string, req *http.Request) (*http.Response, error) {
+	logger := zap.S().Named("upstream")
+	repo := metricsRepo(key)
+	urls, bases := upstreamURLs(key, req.URL.String())
+	for i, u := range urls {
+		r, err := http.NewRequestWithContext(req.Context(), req.Method, u, http.NoBody)
+		if err != nil {
+			return nil, err
+		}
+		r.Header = req.Header.Clone()
+		applyAuth(key, r)
+
+		response, err := client.Do(r)
+		last := i == len(urls)-1
+		if err == nil && (response.StatusCode < http.StatusInternalServerError || last) {
+			metrics.GetOrCreateCounter(fmt.Sprintf("hub_upstream_requests_total{repo=%q,upstream=%q}", repo, bases[i])).Inc()
+			if i > 0 {
+				// Answers of fallbacks show that the primary is failing.
+				logger.Infof("[Upstream] %s %s served by fallback %s with status %d", req.Method, key, bases[i], response.StatusCode)
+			} else {
+				logger.Debugf("[Upstream] %s %s served by %s with status %d", req.Method, key, bases[i], response.StatusCode)
+			}
+			return response, nil
+		}
+		if last {
+			return nil, err
+		}
+
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = response.Status
+			response.Body.Close()
+		}
+		metrics.GetOrCreateCounter(fmt.Sprintf("hub_upstream_failovers_total{repo=%q,upstream=%q}", repo, bases[i])).Inc()
+		logger.Warnf("[Upstream] %s failed for %s (%s), trying %s", bases[i], key, reason, bases[i+1])
+	}
+	return nil, fmt.Errorf("no upstream for %s", key)
+}
@@ -0,0 +1,69 @@
+package misc
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/psvmcc/hub/pkg/storage"
+	"github.com/psvmcc/hub/pkg/types"
+)
+
+const metaDir = ".meta"
+
+// Validators are the cache validators upstream sent with a cached object.
+type Validators struct {
+	ETag         string `json:"etag"`
+	LastModified string `json:"last_modified"`
+}
+
+// MetaKey returns the key of the sidecar that describes key. Sidecars live
+// in a hidden tree of the repo, static/k8s/release/stable.txt is described
+// by static/k8s/.meta/release/stable.txt.json.
+func MetaKey(key string) string {
+	parts := strings.SplitN(storage.CleanKey(key), "/", 3)
+	if len(parts) < 3 {
+		return metaDir + "/" + storage.CleanKey(key) + ".json"
+	}
+	return parts[0] + "/" + parts[1] + "/" + metaDir + "/" + parts[2] + ".json"
+}
+
+func ReadValidators(store storage.Backend, key string) (Validators, error) {
+	v := Validators{}
+	data, err := storage.ReadFile(store, MetaKey(key))
+	if err != nil {
+		return v, err
+	}
+	err = json.Unmarshal(data, &v)
+	return v, err
+}
+
+func writeValidators(store storage.Backend, key string, header http.Header) error {
+	v := Validators{ETag: header.Get("ETag"), LastModified: header.Get("Last-Modified")}
+	if v.ETag == "" && v.LastModified == "" {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return storage.WriteFile(store, MetaKey(key), data)
+}
+
+// Revalidate sends a conditional request for key with its stored
+// validators. When upstream answers 304 the cached copy is marked as
+// fetched now and notModified is true, otherwise the new body is stored.
+func Revalidate(store storage.Backend, url, key string, headers types.RequestHeaders) (code int, notModified bool, err error) {
+	if IsOffline(key) {
+		return http.StatusNotFound, false, ErrOffline
+	}
+	v, _ := ReadValidators(store, key)
+	code, _, _, notModified, err = DownloadFileConditional(store, url, key, headers, v.ETag, v.LastModified)
+	if err == nil && notModified {
+		if err := Touch(store, key, time.Now()); err != nil {
+			return http.StatusInternalServerError, false, err
+		}
+	}
+	return code, notModified, err
+}
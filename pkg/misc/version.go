@@ -0,0 +1,64 @@
+package misc
+
+import (
+	"cmp"
+	"strconv"
+	"strings"
+)
+
+// CompareVersions compares two dotted version strings such as 1.10.0 and
+// 1.9.2-rc.1. Numeric parts are compared as numbers and a version with a
+// pre-release suffix sorts before the same version without one. It returns
+// -1, 0 or 1.
+func CompareVersions(a, b string) int {
+	a, b = strings.TrimPrefix(a, "v"), strings.TrimPrefix(b, "v")
+	aCore, aPre, _ := strings.Cut(strings.SplitN(a, "+", 2)[0], "-")
+	bCore, bPre, _ := strings.Cut(strings.SplitN(b, "+", 2)[0], "-")
+
+	if r := compareParts(strings.Split(aCore, "."), strings.Split(bCore, ".")); r != 0 {
+		return r
+	}
+	switch {
+	case aPre == bPre:
+		return 0
+	case aPre == "":
+		return 1
+	case bPre == "":
+		return -1
+	}
+	return compareParts(strings.Split(aPre, "."), strings.Split(bPre, "."))
+}
+
+// IsPrerelease reports whether a version has a pre-release suffix.
+func IsPrerelease(version string) bool {
+	core := strings.SplitN(strings.TrimPrefix(version, "v"), "+", 2)[0]
+	return strings.Contains(core, "-")
+}
+
+func compareParts(a, b []string) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		if i >= len(a) {
+			return -1
+		}
+		if i >= len(b) {
+			return 1
+		}
+		an, aErr := strconv.Atoi(a[i])
+		bn, bErr := strconv.Atoi(b[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if an != bn {
+				return cmp.Compare(an, bn)
+			}
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if r := strings.Compare(a[i], b[i]); r != 0 {
+				return r
+			}
+		}
+	}
+	return 0
+}
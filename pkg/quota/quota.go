@@ -0,0 +1,153 @@
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"time"
+
+	"github.com/psvmcc/hub/pkg/index"
+	"github.com/psvmcc/hub/pkg/misc"
+	"github.com/psvmcc/hub/pkg/storage"
+	"github.com/psvmcc/hub/pkg/types"
+
+	"github.com/VictoriaMetrics/metrics"
+	"go.uber.org/zap"
+)
+
+// Repo is the cached content of one proxy repo, such as npm/npmjs.
+type Repo struct {
+	Prefix  string
+	MaxSize int64
+}
+
+// Evictor keeps the cache below its quotas by removing the least recently
+// used objects. Objects are listed from the cache index, only the prefixes
+// of proxy repos are looked at, so hosted and local directory content is
+// never touched.
+type Evictor struct {
+	store   storage.Backend
+	maxSize int64
+	repos   []Repo
+	logger  *zap.SugaredLogger
+}
+
+type entry struct {
+	repo   string
+	key    string
+	size   int64
+	access time.Time
+}
+
+// Repos returns the proxy repos of the config. Repos in hosted or dir mode
+// are left out.
+func Repos(cfg types.ConfigFile) []Repo {
+	repos := []Repo{}
+	for ecosystem, list := range map[string]map[string]types.Repo{
+		"pypi":     cfg.Server.PYPI,
+		"npm":      cfg.Server.NPM,
+		"static":   cfg.Server.Static,
+		"goproxy":  cfg.Server.GOPROXY,
+		"rubygems": cfg.Server.RUBYGEMS,
+		"galaxy":   cfg.Server.Galaxy,
+	} {
+		for k, v := range list {
+			if v.IsHosted() || v.IsDir() {
+				continue
+			}
+			repos = append(repos, Repo{Prefix: fmt.Sprintf("%s/%s", ecosystem, k), MaxSize: int64(v.MaxSize)})
+		}
+	}
+	sort.Slice(repos, func(i, j int) bool { return repos[i].Prefix < repos[j].Prefix })
+	return repos
+}
+
+// New returns an evictor, or nil when no quota is configured.
+func New(store storage.Backend, cfg types.ConfigFile, logger *zap.SugaredLogger) *Evictor {
+	e := &Evictor{store: store, maxSize: int64(cfg.Cache.MaxSize), repos: Repos(cfg), logger: logger}
+	limited := e.maxSize > 0
+	for _, repo := range e.repos {
+		limited = limited || repo.MaxSize > 0
+	}
+	if !limited {
+		return nil
+	}
+	return e
+}
+
+// Run checks the quotas every interval.
+func (e *Evictor) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		e.Evict()
+		<-ticker.C
+	}
+}
+
+// Evict runs one pass. Repos over their own quota are trimmed first, then
+// the least recently used objects of all repos are removed until the
+// global quota is met.
+func (e *Evictor) Evict() {
+	all := []entry{}
+	var total int64
+	for _, repo := range e.repos {
+		entries := list(repo.Prefix)
+		size := sumSize(entries)
+		if repo.MaxSize > 0 && size > repo.MaxSize {
+			entries, size = e.evict(entries, size, repo.MaxSize)
+		}
+		all = append(all, entries...)
+		total += size
+	}
+	if e.maxSize > 0 && total > e.maxSize {
+		e.evict(all, total, e.maxSize)
+	}
+}
+
+// list returns the indexed objects of a repo. Objects that were not served
+// yet are ranked by the time they were fetched.
+func list(prefix string) []entry {
+	entries := []entry{}
+	for _, en := range index.List(prefix + "/") {
+		entries = append(entries, entry{repo: prefix, key: en.Key, size: en.Size, access: en.Used()})
+	}
+	return entries
+}
+
+// evict removes the least recently used entries until size is at most
+// limit and returns the entries that are left.
+func (e *Evictor) evict(entries []entry, size, limit int64) ([]entry, int64) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].access.Before(entries[j].access) })
+	left := []entry{}
+	for _, en := range entries {
+		if size <= limit {
+			left = append(left, en)
+			continue
+		}
+		if err := misc.RemoveObject(e.store, en.key); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				// Removed meanwhile, it doesn't count anymore.
+				size -= en.size
+				continue
+			}
+			e.logger.Errorf("[Quota] Removing %s error: %s", en.key, err)
+			left = append(left, en)
+			continue
+		}
+		size -= en.size
+		metrics.GetOrCreateCounter(fmt.Sprintf("hub_cache_evictions_total{repo=%q}", en.repo)).Inc()
+		metrics.GetOrCreateCounter(fmt.Sprintf("hub_cache_evicted_bytes_total{repo=%q}", en.repo)).AddInt64(en.size)
+		e.logger.Debugf("[Quota] Evicted %s (%d bytes)", en.key, en.size)
+	}
+	return left, size
+}
+
+func sumSize(entries []entry) int64 {
+	var size int64
+	for _, en := range entries {
+		size += en.size
+	}
+	return size
+}
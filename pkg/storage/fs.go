@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FS keeps objects as plain files below a root directory.
+type FS struct {
+	root string
+}
+
+func NewFS(root string) *FS {
+	return &FS{root: root}
+}
+
+func (s *FS) path(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(CleanKey(key)))
+}
+
+func (s *FS) Stat(key string) (ObjectInfo, error) {
+	info, err := os.Stat(s.path(key))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if info.IsDir() {
+		return ObjectInfo{}, notExist(key)
+	}
+	return ObjectInfo{Key: CleanKey(key), Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *FS) Open(key string) (Object, ObjectInfo, error) {
+	info, err := s.Stat(key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	file, err := os.Open(s.path(key))
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	return file, info, nil
+}
+
+func (s *FS) Create(key string) (Writer, error) {
+	destination := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(destination), 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create destination directory: %v", err)
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(destination), fmt.Sprintf(".tmp.%s.*", filepath.Base(destination)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	return &fsWriter{File: tempFile, destination: destination}, nil
+}
+
+// partialPath is where the data of a suspended writer of key is kept.
+// Like temporary files it is skipped by Walk.
+func (s *FS) partialPath(key string) string {
+	destination := s.path(key)
+	return filepath.Join(filepath.Dir(destination), fmt.Sprintf(".tmp.%s.partial", filepath.Base(destination)))
+}
+
+func (s *FS) Suspend(key string, w Writer) error {
+	fw, ok := w.(*fsWriter)
+	if !ok {
+		return fmt.Errorf("foreign writer for %s", key)
+	}
+	if fw.done {
+		return nil
+	}
+	fw.done = true
+	if err := fw.Close(); err != nil {
+		_ = os.Remove(fw.Name())
+		return err
+	}
+	return os.Rename(fw.Name(), s.partialPath(key))
+}
+
+func (s *FS) Resume(key string) (Writer, int64, error) {
+	if _, err := os.Stat(s.partialPath(key)); err != nil {
+		return nil, 0, err
+	}
+	w, err := s.Create(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	fw := w.(*fsWriter)
+	file, size, err := claimPartial(s.partialPath(key), fw.File)
+	if err != nil {
+		_ = fw.Abort()
+		return nil, 0, err
+	}
+	fw.File = file
+	return fw, size, nil
+}
+
+// claimPartial moves the kept data at partial over the new temporary file
+// and reopens it for appending.
+func claimPartial(partial string, temp *os.File) (*os.File, int64, error) {
+	if err := os.Rename(partial, temp.Name()); err != nil {
+		return nil, 0, err
+	}
+	_ = temp.Close()
+	file, err := os.OpenFile(temp.Name(), os.O_RDWR, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, size, nil
+}
+
+func (s *FS) Remove(key string) error {
+	return os.Remove(s.path(key))
+}
+
+func (s *FS) Chtimes(key string, modTime time.Time) error {
+	return os.Chtimes(s.path(key), time.Now(), modTime)
+}
+
+func (s *FS) Walk(prefix string, fn func(ObjectInfo) error) error {
+	prefix = strings.TrimPrefix(prefix, "/")
+	start := s.root
+	// Walk from the deepest directory of the prefix to avoid scanning the
+	// whole tree for narrow prefixes.
+	if idx := strings.LastIndex(prefix, "/"); idx >= 0 {
+		start = s.path(prefix[:idx])
+	}
+	err := filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".tmp.") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		return fn(ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+	})
+	return err
+}
+
+type fsWriter struct {
+	*os.File
+	destination string
+	done        bool
+}
+
+func (w *fsWriter) Commit(modTime time.Time) error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	defer os.Remove(w.Name())
+
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("tempFile close error: %v", err)
+	}
+	if !modTime.IsZero() {
+		if err := os.Chtimes(w.Name(), time.Now(), modTime); err != nil {
+			return fmt.Errorf("failed to set last-modified time: %v", err)
+		}
+	}
+	if err := os.Rename(w.Name(), w.destination); err != nil {
+		return fmt.Errorf("failed to rename temporary file to destination: %v", err)
+	}
+	return nil
+}
+
+func (w *fsWriter) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	_ = w.Close()
+	return os.Remove(w.Name())
+}
@@ -0,0 +1,385 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/psvmcc/hub/pkg/types"
+)
+
+const s3MtimeHeader = "X-Amz-Meta-Mtime"
+
+// S3 keeps objects in an S3-compatible bucket, so several HUB replicas can
+// share one cache. New objects are spooled to a local temporary file and
+// uploaded on commit.
+type S3 struct {
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	prefix    string
+	accessKey string
+	secretKey string
+	pathStyle bool
+	spoolDir  string
+	client    *http.Client
+}
+
+func NewS3(cfg types.S3Config) (*S3, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3 storage needs endpoint and bucket params")
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("bad s3 endpoint: %v", err)
+	}
+	s := &S3{
+		endpoint:  endpoint,
+		bucket:    cfg.Bucket,
+		region:    cfg.Region,
+		prefix:    strings.Trim(cfg.Prefix, "/"),
+		accessKey: cfg.AccessKey,
+		secretKey: cfg.SecretKey,
+		pathStyle: cfg.PathStyle,
+		spoolDir:  cfg.SpoolDir,
+		client:    &http.Client{},
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+	if s.prefix != "" {
+		s.prefix += "/"
+	}
+	if s.accessKey == "" {
+		s.accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if s.secretKey == "" {
+		s.secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	return s, nil
+}
+
+func (s *S3) objectKey(key string) string {
+	return s.prefix + CleanKey(key)
+}
+
+func (s *S3) url(objectKey string, query url.Values) *url.URL {
+	u := *s.endpoint
+	p := "/" + objectKey
+	if s.pathStyle {
+		p = "/" + s.bucket + p
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	u.Path = p
+	u.RawPath = s3EscapePath(p)
+	u.RawQuery = s3CanonicalQuery(query)
+	return &u
+}
+
+func (s *S3) do(method string, u *url.URL, headers http.Header, body io.Reader, size int64) (*http.Response, error) {
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	signV4(req, s.accessKey, s.secretKey, s.region, s3UnsignedPayload, time.Now())
+	return s.client.Do(req)
+}
+
+func s3Error(key string, response *http.Response) error {
+	if response.StatusCode == http.StatusNotFound {
+		return notExist(key)
+	}
+	message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+	return fmt.Errorf("s3 returned %s for %s: %s", response.Status, key, strings.TrimSpace(string(message)))
+}
+
+func (s *S3) Stat(key string) (ObjectInfo, error) {
+	response, err := s.do(http.MethodHead, s.url(s.objectKey(key), nil), nil, nil, 0)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return ObjectInfo{}, s3Error(key, response)
+	}
+	return ObjectInfo{Key: CleanKey(key), Size: response.ContentLength, ModTime: s3ModTime(response.Header)}, nil
+}
+
+func s3ModTime(header http.Header) time.Time {
+	if mtime, err := strconv.ParseInt(header.Get(s3MtimeHeader), 10, 64); err == nil {
+		return time.Unix(0, mtime)
+	}
+	modTime, _ := http.ParseTime(header.Get("Last-Modified"))
+	return modTime
+}
+
+func (s *S3) Open(key string) (Object, ObjectInfo, error) {
+	info, err := s.Stat(key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	return &s3Object{s: s, key: key, size: info.Size}, info, nil
+}
+
+func (s *S3) Create(key string) (Writer, error) {
+	spool, err := os.CreateTemp(s.spoolDir, "hub-s3-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool file: %v", err)
+	}
+	return &s3Writer{File: spool, s: s, key: key}, nil
+}
+
+// partialPath is the spool file that keeps the data of a suspended writer
+// of key on this host.
+func (s *S3) partialPath(key string) string {
+	dir := s.spoolDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	sum := sha256.Sum256([]byte(s.bucket + "/" + s.objectKey(key)))
+	return filepath.Join(dir, "hub-s3-partial-"+hex.EncodeToString(sum[:16]))
+}
+
+func (s *S3) Suspend(key string, w Writer) error {
+	sw, ok := w.(*s3Writer)
+	if !ok {
+		return fmt.Errorf("foreign writer for %s", key)
+	}
+	if sw.done {
+		return nil
+	}
+	sw.done = true
+	if err := sw.Close(); err != nil {
+		_ = os.Remove(sw.Name())
+		return err
+	}
+	return os.Rename(sw.Name(), s.partialPath(key))
+}
+
+func (s *S3) Resume(key string) (Writer, int64, error) {
+	if _, err := os.Stat(s.partialPath(key)); err != nil {
+		return nil, 0, err
+	}
+	w, err := s.Create(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	sw := w.(*s3Writer)
+	file, size, err := claimPartial(s.partialPath(key), sw.File)
+	if err != nil {
+		_ = sw.Abort()
+		return nil, 0, err
+	}
+	sw.File = file
+	return sw, size, nil
+}
+
+func (s *S3) Remove(key string) error {
+	if _, err := s.Stat(key); err != nil {
+		return err
+	}
+	response, err := s.do(http.MethodDelete, s.url(s.objectKey(key), nil), nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusNoContent && response.StatusCode != http.StatusOK {
+		return s3Error(key, response)
+	}
+	return nil
+}
+
+// Chtimes copies the object onto itself with new metadata, S3 has no way to
+// update metadata in place.
+func (s *S3) Chtimes(key string, modTime time.Time) error {
+	objectKey := s.objectKey(key)
+	headers := http.Header{}
+	headers.Set("X-Amz-Copy-Source", s3EscapePath("/"+s.bucket+"/"+objectKey))
+	headers.Set("X-Amz-Metadata-Directive", "REPLACE")
+	headers.Set(s3MtimeHeader, strconv.FormatInt(modTime.UnixNano(), 10))
+	response, err := s.do(http.MethodPut, s.url(objectKey, nil), headers, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return s3Error(key, response)
+	}
+	return nil
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// Walk lists objects with ListObjectsV2. Listings don't carry user metadata,
+// so ModTime is the upload time of each object.
+func (s *S3) Walk(prefix string, fn func(ObjectInfo) error) error {
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.prefix + strings.TrimPrefix(prefix, "/")}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		u := s.url("", query)
+		if s.pathStyle {
+			u.Path, u.RawPath = "/"+s.bucket+"/", ""
+		}
+		response, err := s.do(http.MethodGet, u, nil, nil, 0)
+		if err != nil {
+			return err
+		}
+		var result s3ListResult
+		if response.StatusCode != http.StatusOK {
+			err = s3Error(prefix, response)
+		} else {
+			err = xml.NewDecoder(response.Body).Decode(&result)
+		}
+		response.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, obj := range result.Contents {
+			if err := fn(ObjectInfo{Key: strings.TrimPrefix(obj.Key, s.prefix), Size: obj.Size, ModTime: obj.LastModified}); err != nil {
+				return err
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// s3Object reads an object with ranged GET requests starting at the current
+// offset, so http.ServeContent can seek for range requests.
+type s3Object struct {
+	s      *S3
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		headers := http.Header{}
+		headers.Set("Range", fmt.Sprintf("bytes=%d-", o.offset))
+		response, err := o.s.do(http.MethodGet, o.s.url(o.s.objectKey(o.key), nil), headers, nil, 0)
+		if err != nil {
+			return 0, err
+		}
+		if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusPartialContent {
+			defer response.Body.Close()
+			return 0, s3Error(o.key, response)
+		}
+		o.body = response.Body
+	}
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	var next int64
+	switch whence {
+	case io.SeekStart:
+		next = offset
+	case io.SeekCurrent:
+		next = o.offset + offset
+	case io.SeekEnd:
+		next = o.size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if next < 0 {
+		return 0, errors.New("negative position")
+	}
+	if next != o.offset && o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	o.offset = next
+	return next, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body != nil {
+		return o.body.Close()
+	}
+	return nil
+}
+
+type s3Writer struct {
+	*os.File
+	s    *S3
+	key  string
+	done bool
+}
+
+func (w *s3Writer) Commit(modTime time.Time) error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	defer os.Remove(w.Name())
+	defer w.Close()
+
+	size, err := w.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+	headers := http.Header{}
+	headers.Set(s3MtimeHeader, strconv.FormatInt(modTime.UnixNano(), 10))
+	response, err := w.s.do(http.MethodPut, w.s.url(w.s.objectKey(w.key), nil), headers, io.NopCloser(w.File), size)
+	if err != nil {
+		return fmt.Errorf("s3 upload error: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return s3Error(w.key, response)
+	}
+	return nil
+}
+
+func (w *s3Writer) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	_ = w.Close()
+	return os.Remove(w.Name())
+}
@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/psvmcc/hub/pkg/types"
+)
+
+// fakeBucket is an S3 bucket served with path-style URLs. It keeps objects
+// in memory and only checks that requests are signed for its credentials.
+type fakeBucket struct {
+	t       *testing.T
+	name    string
+	mu      sync.Mutex
+	objects map[string]fakeObject
+}
+
+type fakeObject struct {
+	data  []byte
+	mtime string
+}
+
+func (b *fakeBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	credential := "Credential=" + awsExampleAccessKey + "/"
+	if auth := r.Header.Get("Authorization"); !strings.HasPrefix(auth, s3Algorithm+" "+credential) || !strings.Contains(auth, "/us-east-1/s3/aws4_request") {
+		b.t.Errorf("%s %s is not signed: %q", r.Method, r.URL, auth)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+b.name+"/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2" {
+		b.list(w, r.URL.Query().Get("prefix"))
+		return
+	}
+	obj, found := b.objects[key]
+	switch r.Method {
+	case http.MethodPut:
+		if source := r.Header.Get("X-Amz-Copy-Source"); source != "" {
+			source, _ = url.PathUnescape(source)
+			src, ok := b.objects[strings.TrimPrefix(source, "/"+b.name+"/")]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			b.objects[key] = fakeObject{data: src.data, mtime: r.Header.Get(s3MtimeHeader)}
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		b.objects[key] = fakeObject{data: data, mtime: r.Header.Get(s3MtimeHeader)}
+	case http.MethodHead, http.MethodGet:
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set(s3MtimeHeader, obj.mtime)
+		data := obj.data
+		var first int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &first); err == nil {
+			data = data[first:]
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, len(obj.data)-1, len(obj.data)))
+			w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+			w.WriteHeader(http.StatusPartialContent)
+		} else {
+			w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		}
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	case http.MethodDelete:
+		delete(b.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (b *fakeBucket) list(w http.ResponseWriter, prefix string) {
+	var result s3ListResult
+	keys := []string{}
+	for k := range b.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		result.Contents = append(result.Contents, struct {
+			Key          string    `xml:"Key"`
+			Size         int64     `xml:"Size"`
+			LastModified time.Time `xml:"LastModified"`
+		}{Key: k, Size: int64(len(b.objects[k].data)), LastModified: time.Now().UTC()})
+	}
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+func TestS3(t *testing.T) {
+	bucket := &fakeBucket{t: t, name: "cache", objects: map[string]fakeObject{}}
+	server := httptest.NewServer(bucket)
+	defer server.Close()
+
+	s, err := NewS3(types.S3Config{
+		Endpoint:  server.URL,
+		Bucket:    "cache",
+		Prefix:    "hub",
+		AccessKey: awsExampleAccessKey,
+		SecretKey: awsExampleSecretKey,
+		PathStyle: true,
+		SpoolDir:  t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const key = "npm/npmjs/@scope/pkg/-/pkg 1.0.0.tgz"
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if _, err := s.Stat(key); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Stat of a missing object: %v", err)
+	}
+
+	w, err := s.Create(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	if Exists(s, key) {
+		t.Fatalf("object is visible before Commit")
+	}
+	if err := w.Commit(modTime); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := bucket.objects["hub/"+key]; !ok {
+		t.Fatalf("object is not stored below the prefix: %v", bucket.objects)
+	}
+
+	info, err := s.Stat(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 10 || !info.ModTime.Equal(modTime) {
+		t.Errorf("Stat = %+v", info)
+	}
+
+	obj, _, err := s.Open(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := obj.Seek(4, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(obj)
+	obj.Close()
+	if err != nil || string(data) != "456789" {
+		t.Errorf("read after Seek = %q, %v", data, err)
+	}
+
+	later := modTime.Add(time.Hour)
+	if err := s.Chtimes(key, later); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := s.Stat(key); err != nil || !info.ModTime.Equal(later) {
+		t.Errorf("Stat after Chtimes = %+v, %v", info, err)
+	}
+
+	var walked []string
+	if err := s.Walk("npm/", func(info ObjectInfo) error {
+		walked = append(walked, info.Key)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(walked) != 1 || walked[0] != key {
+		t.Errorf("Walk = %q", walked)
+	}
+
+	if err := s.Remove(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Remove(key); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Remove of a missing object: %v", err)
+	}
+}
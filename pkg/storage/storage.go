@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/psvmcc/hub/pkg/types"
+)
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Object is an open stored object. Seeking is supported so objects can be
+// served with http.ServeContent, including range requests.
+type Object interface {
+	io.ReadSeekCloser
+}
+
+// Writer receives the content of a new object. Nothing is visible under the
+// key until Commit succeeds, so readers never see partial content. Data
+// written so far can be read back with ReadAt until Commit or Abort.
+type Writer interface {
+	io.Writer
+	io.ReaderAt
+	// Commit publishes the object. A non-zero modTime is kept as the object
+	// modification time, like Last-Modified of the upstream response.
+	Commit(modTime time.Time) error
+	// Abort discards the written data. It's a no-op after Commit.
+	Abort() error
+}
+
+// Resumer is implemented by backends that can keep the data of an
+// interrupted Writer, so a download continues where it stopped instead of
+// starting over.
+type Resumer interface {
+	// Suspend closes w, a writer of key, and keeps its data.
+	Suspend(key string, w Writer) error
+	// Resume returns a writer that appends to the data kept for key, along
+	// with its size. The kept data is claimed, so it's only resumed once.
+	// Errors match fs.ErrNotExist when nothing is kept.
+	Resume(key string) (Writer, int64, error)
+}
+
+// Backend stores cached objects under slash separated keys such as
+// pypi/pypi.org/requests/index.json. Missing objects are reported with
+// errors matching fs.ErrNotExist.
+type Backend interface {
+	Stat(key string) (ObjectInfo, error)
+	Open(key string) (Object, ObjectInfo, error)
+	Create(key string) (Writer, error)
+	Remove(key string) error
+	Chtimes(key string, modTime time.Time) error
+	// Walk calls fn for every object whose key starts with prefix.
+	Walk(prefix string, fn func(ObjectInfo) error) error
+}
+
+func New(cfg types.ConfigFile) (Backend, error) {
+	switch cfg.Storage.Type {
+	case "", "fs":
+		return NewFS(cfg.Dir), nil
+	case "s3":
+		return NewS3(cfg.Storage.S3)
+	default:
+		return nil, fmt.Errorf("unknown storage type %q", cfg.Storage.Type)
+	}
+}
+
+// CleanKey normalizes a key and drops any attempt to leave the storage root.
+func CleanKey(key string) string {
+	return strings.TrimPrefix(path.Clean("/"+key), "/")
+}
+
+func Exists(b Backend, key string) bool {
+	_, err := b.Stat(key)
+	return err == nil
+}
+
+func ReadFile(b Backend, key string) ([]byte, error) {
+	obj, _, err := b.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	return io.ReadAll(obj)
+}
+
+func WriteFile(b Backend, key string, data []byte) error {
+	w, err := b.Create(key)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		_ = w.Abort()
+		return err
+	}
+	return w.Commit(time.Time{})
+}
+
+func notExist(key string) error {
+	return &fs.PathError{Op: "stat", Path: key, Err: fs.ErrNotExist}
+}
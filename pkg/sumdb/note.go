@@ -0,0 +1,77 @@
+package sumdb
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// algEd25519 is the first byte of ed25519 verifier keys.
+const algEd25519 = 1
+
+// Verifier checks the signed notes of a checksum database. Its key has the
+// form of GOSUMDB keys, like
+// sum.golang.org+033de0ae+Ac4zctda0e5eza+HJyk9SxEdh+s3Ql18F0ryjQfT4d+.
+type Verifier struct {
+	Name string
+	hash uint32
+	key  ed25519.PublicKey
+}
+
+func ParseVerifier(vkey string) (Verifier, error) {
+	name, rest, ok := strings.Cut(vkey, "+")
+	hashHex, keyData, ok2 := strings.Cut(rest, "+")
+	if !ok || !ok2 || name == "" || len(hashHex) != 8 {
+		return Verifier{}, fmt.Errorf("malformed verifier key %q", vkey)
+	}
+	hash, err := strconv.ParseUint(hashHex, 16, 32)
+	if err != nil {
+		return Verifier{}, fmt.Errorf("malformed verifier key %q", vkey)
+	}
+	key, err := base64.StdEncoding.DecodeString(keyData)
+	if err != nil || len(key) != 1+ed25519.PublicKeySize || key[0] != algEd25519 {
+		return Verifier{}, fmt.Errorf("verifier key %q is not an ed25519 key", vkey)
+	}
+	if keyHash(name, key) != uint32(hash) {
+		return Verifier{}, fmt.Errorf("verifier key %q has a wrong hash", vkey)
+	}
+	return Verifier{Name: name, hash: uint32(hash), key: key[1:]}, nil
+}
+
+// keyHash identifies a key in signatures. key starts with the algorithm.
+func keyHash(name string, key []byte) uint32 {
+	h := sha256.New()
+	h.Write([]byte(name + "\n"))
+	h.Write(key)
+	return binary.BigEndian.Uint32(h.Sum(nil))
+}
+
+// Open returns the text of the signed note msg if one of its signatures is
+// a valid one of v. Signatures of other keys are ignored.
+func (v Verifier) Open(msg []byte) ([]byte, error) {
+	i := bytes.LastIndex(msg, []byte("\n\n"))
+	if i < 0 {
+		return nil, fmt.Errorf("%w: note is not signed", ErrVerify)
+	}
+	text, signatures := msg[:i+1], msg[i+2:]
+	for line := range strings.SplitSeq(string(signatures), "\n") {
+		rest, ok := strings.CutPrefix(line, "— ")
+		name, encoded, ok2 := strings.Cut(rest, " ")
+		if !ok || !ok2 || name != v.Name {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(sig) != 4+ed25519.SignatureSize || binary.BigEndian.Uint32(sig) != v.hash {
+			continue
+		}
+		if ed25519.Verify(v.key, text, sig[4:]) {
+			return text, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no valid signature of %s", ErrVerify, v.Name)
+}
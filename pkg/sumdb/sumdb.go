@@ -0,0 +1,212 @@
+// Package sumdb checks Go modules against a checksum database such as
+// sum.golang.org.
+package sumdb
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// requestTimeout bounds each request to the database, retries included.
+const requestTimeout = 30 * time.Second
+
+// ErrNotFound is returned for modules the database doesn't know.
+var ErrNotFound = errors.New("module not found in checksum database")
+
+// ErrVerify is returned for answers that aren't signed by the verifier key
+// or don't prove their record.
+var ErrVerify = errors.New("checksum database answer failed verification")
+
+// Hashes are the go.sum lines of a module version.
+type Hashes struct {
+	Zip string
+	Mod string
+}
+
+// Hash1 is the "h1:" hash of go.sum: the sha256 of a listing of the sorted
+// file names with the sha256 of each file.
+func Hash1(files []string, open func(string) (io.ReadCloser, error)) (string, error) {
+	files = append([]string(nil), files...)
+	sort.Strings(files)
+	h := sha256.New()
+	for _, file := range files {
+		if strings.Contains(file, "\n") {
+			return "", errors.New("file names with newlines are not supported")
+		}
+		r, err := open(file)
+		if err != nil {
+			return "", err
+		}
+		hf := sha256.New()
+		_, err = io.Copy(hf, r)
+		r.Close()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%x  %s\n", hf.Sum(nil), file)
+	}
+	return "h1:" + base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// HashZip returns the h1 hash of a module zip.
+func HashZip(r io.ReaderAt, size int64) (string, error) {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return "", err
+	}
+	files := make([]string, 0, len(z.File))
+	byName := make(map[string]*zip.File, len(z.File))
+	for _, f := range z.File {
+		files = append(files, f.Name)
+		byName[f.Name] = f
+	}
+	return Hash1(files, func(name string) (io.ReadCloser, error) {
+		return byName[name].Open()
+	})
+}
+
+// HashMod returns the h1 hash of a go.mod file.
+func HashMod(r io.ReaderAt, size int64) (string, error) {
+	return Hash1([]string{"go.mod"}, func(string) (io.ReadCloser, error) {
+		return io.NopCloser(io.NewSectionReader(r, 0, size)), nil
+	})
+}
+
+// Client looks up modules in the database at URL, whose answers must be
+// signed by Verifier. Do sends the requests, like the Do method of an
+// http.Client.
+type Client struct {
+	URL      string
+	Verifier Verifier
+	Do       func(req *http.Request) (*http.Response, error)
+}
+
+// Lookup asks the database for the hashes of a module version. escapedPath
+// and escapedVersion are in the case-encoded form of proxy URLs, like
+// github.com/!azure/azure-sdk-for-go. The answer is only returned once its
+// tree is signed by the verifier and the tiles of the database prove that
+// the tree holds its record.
+func (c Client) Lookup(escapedPath, escapedVersion string) ([]byte, error) {
+	data, err := c.get(fmt.Sprintf("lookup/%s@%s", escapedPath, escapedVersion))
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("%w: %s@%s", ErrNotFound, escapedPath, escapedVersion)
+	}
+	if err != nil {
+		return nil, err
+	}
+	id, text, tree, err := c.Verifier.openLookup(data)
+	if err != nil {
+		return nil, err
+	}
+	tiles := &tileReader{tree: tree, fetch: c.get}
+	if err := tiles.checkRecord(id, text); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// get returns the body of the database answer for path.
+func (c Client) get(path string) ([]byte, error) {
+	url := strings.TrimSuffix(c.URL, "/") + "/" + path
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "hub")
+	response, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusGone:
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, path)
+	default:
+		return nil, fmt.Errorf("checksum database returned %s for %s", response.Status, path)
+	}
+	return io.ReadAll(io.LimitReader(response.Body, 1<<20))
+}
+
+// CheckLookup checks the signature of an answer of Lookup, such as a cached
+// one.
+func (v Verifier) CheckLookup(data []byte) error {
+	_, _, _, err := v.openLookup(data)
+	return err
+}
+
+func (v Verifier) openLookup(data []byte) (id int64, text []byte, tree Tree, err error) {
+	id, text, note, err := parseRecord(data)
+	if err != nil {
+		return 0, nil, tree, err
+	}
+	treeText, err := v.Open(note)
+	if err != nil {
+		return 0, nil, tree, err
+	}
+	tree, err = parseTree(treeText)
+	return id, text, tree, err
+}
+
+// ParseLookup returns the hashes of modulePath at version from the record
+// of a lookup answer. The answer must have been verified.
+func ParseLookup(data []byte, modulePath, version string) (Hashes, error) {
+	var hashes Hashes
+	_, text, _, err := parseRecord(data)
+	if err != nil {
+		return hashes, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(text))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || fields[0] != modulePath || !strings.HasPrefix(fields[2], "h1:") {
+			continue
+		}
+		switch fields[1] {
+		case version:
+			hashes.Zip = fields[2]
+		case version + "/go.mod":
+			hashes.Mod = fields[2]
+		}
+	}
+	if hashes.Zip == "" && hashes.Mod == "" {
+		return hashes, fmt.Errorf("no hashes for %s@%s in checksum database answer", modulePath, version)
+	}
+	return hashes, nil
+}
+
+// MatchPrefix reports whether a pattern of a GONOSUMDB style list matches
+// modulePath or one of its parents.
+func MatchPrefix(patterns []string, modulePath string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(pattern, "/")
+		if pattern == "" {
+			continue
+		}
+		n := strings.Count(pattern, "/") + 1
+		elems := strings.SplitN(modulePath, "/", n+1)
+		if len(elems) < n {
+			continue
+		}
+		prefix := strings.Join(elems[:n], "/")
+		if ok, _ := path.Match(pattern, prefix); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,209 @@
+package sumdb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// tileHeight is the height of the tiles sum.golang.org serves, each holds
+// up to 256 hashes of one level of the tree.
+const tileHeight = 8
+
+// Tree is a signed tree head of the checksum database, a Merkle tree of
+// every record like RFC 6962 describes.
+type Tree struct {
+	N    int64
+	Hash [sha256.Size]byte
+}
+
+func parseTree(text []byte) (Tree, error) {
+	lines := strings.SplitN(string(text), "\n", 4)
+	if len(lines) < 4 || lines[0] != "go.sum database tree" {
+		return Tree{}, fmt.Errorf("%w: malformed tree note", ErrVerify)
+	}
+	n, err := strconv.ParseInt(lines[1], 10, 64)
+	if err != nil || n < 1 {
+		return Tree{}, fmt.Errorf("%w: malformed tree size", ErrVerify)
+	}
+	hash, err := base64.StdEncoding.DecodeString(lines[2])
+	if err != nil || len(hash) != sha256.Size {
+		return Tree{}, fmt.Errorf("%w: malformed tree hash", ErrVerify)
+	}
+	tree := Tree{N: n}
+	copy(tree.Hash[:], hash)
+	return tree, nil
+}
+
+// parseRecord splits a lookup answer into the record id, the record text
+// and the signed tree note.
+func parseRecord(data []byte) (id int64, text, tree []byte, err error) {
+	line, rest, ok := bytes.Cut(data, []byte("\n"))
+	if !ok {
+		return 0, nil, nil, fmt.Errorf("%w: malformed lookup answer", ErrVerify)
+	}
+	id, err = strconv.ParseInt(string(line), 10, 64)
+	if err != nil || id < 0 {
+		return 0, nil, nil, fmt.Errorf("%w: malformed record id", ErrVerify)
+	}
+	i := bytes.Index(rest, []byte("\n\n"))
+	if i < 0 {
+		return 0, nil, nil, fmt.Errorf("%w: malformed lookup answer", ErrVerify)
+	}
+	return id, rest[:i+1], rest[i+2:], nil
+}
+
+func recordHash(data []byte) [sha256.Size]byte {
+	return sha256.Sum256(append([]byte{0}, data...))
+}
+
+func nodeHash(left, right [sha256.Size]byte) [sha256.Size]byte {
+	return sha256.Sum256(append(append([]byte{1}, left[:]...), right[:]...))
+}
+
+// tileReader reads the hashes of a tree from the tiles of the database.
+// Tiles aren't trusted, whatever is built from them is checked against the
+// signed tree hash.
+type tileReader struct {
+	tree  Tree
+	fetch func(path string) ([]byte, error)
+	tiles map[string][]byte
+}
+
+// checkRecord proves that data is record id of the tree. The root built
+// from the hash of data and the hashes of the tiles must be the signed one.
+func (r *tileReader) checkRecord(id int64, data []byte) error {
+	if id >= r.tree.N {
+		return fmt.Errorf("%w: record %d is not in tree of size %d", ErrVerify, id, r.tree.N)
+	}
+	root, err := r.rangeHash(0, r.tree.N, id, recordHash(data))
+	if err != nil {
+		return err
+	}
+	if root != r.tree.Hash {
+		return fmt.Errorf("%w: record %d is not in the signed tree", ErrVerify, id)
+	}
+	return nil
+}
+
+// rangeHash returns the hash of the records lo to hi with record id
+// hashing to leaf.
+func (r *tileReader) rangeHash(lo, hi, id int64, leaf [sha256.Size]byte) ([sha256.Size]byte, error) {
+	if id < lo || id >= hi {
+		return r.subtree(lo, hi)
+	}
+	if hi-lo == 1 {
+		return leaf, nil
+	}
+	k := split(hi - lo)
+	left, err := r.rangeHash(lo, lo+k, id, leaf)
+	if err != nil {
+		return left, err
+	}
+	right, err := r.rangeHash(lo+k, hi, id, leaf)
+	if err != nil {
+		return right, err
+	}
+	return nodeHash(left, right), nil
+}
+
+// subtree returns the hash of the records lo to hi read from the tiles.
+func (r *tileReader) subtree(lo, hi int64) ([sha256.Size]byte, error) {
+	n := hi - lo
+	if n&(n-1) == 0 {
+		level := bits.TrailingZeros64(uint64(n))
+		return r.node(level, lo>>level)
+	}
+	k := split(n)
+	left, err := r.subtree(lo, lo+k)
+	if err != nil {
+		return left, err
+	}
+	right, err := r.subtree(lo+k, hi)
+	if err != nil {
+		return right, err
+	}
+	return nodeHash(left, right), nil
+}
+
+// split returns the largest power of two smaller than n.
+func split(n int64) int64 {
+	return 1 << (63 - bits.LeadingZeros64(uint64(n-1)))
+}
+
+// node returns the hash of the complete subtree of 2^level records at
+// index of its level. Tiles hold every eighth level, the levels between
+// are hashed from the one below.
+func (r *tileReader) node(level int, index int64) ([sha256.Size]byte, error) {
+	var hash [sha256.Size]byte
+	tileLevel, sub := level/tileHeight, level%tileHeight
+	first, count := index<<sub, int64(1)<<sub
+	tile := first >> tileHeight
+	width := min(r.tree.N>>(tileLevel*tileHeight)-tile<<tileHeight, 1<<tileHeight)
+	offset := first - tile<<tileHeight
+	if offset+count > width {
+		return hash, fmt.Errorf("%w: node %d/%d is outside of the tree", ErrVerify, level, index)
+	}
+	data, err := r.read(tileLevel, tile, width)
+	if err != nil {
+		return hash, err
+	}
+	hashes := make([][sha256.Size]byte, count)
+	for i := range hashes {
+		copy(hashes[i][:], data[(offset+int64(i))*sha256.Size:])
+	}
+	for len(hashes) > 1 {
+		for i := range len(hashes) / 2 {
+			hashes[i] = nodeHash(hashes[2*i], hashes[2*i+1])
+		}
+		hashes = hashes[:len(hashes)/2]
+	}
+	return hashes[0], nil
+}
+
+// read returns the first width hashes of a tile. A partial tile the
+// database doesn't serve anymore is read from the full one.
+func (r *tileReader) read(level int, index, width int64) ([]byte, error) {
+	path := tilePath(level, index, width)
+	if data, ok := r.tiles[path]; ok {
+		return data, nil
+	}
+	data, err := r.fetch(path)
+	if errors.Is(err, ErrNotFound) && width < 1<<tileHeight {
+		data, err = r.fetch(tilePath(level, index, 1<<tileHeight))
+		if len(data) > int(width)*sha256.Size {
+			data = data[:width*sha256.Size]
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != int(width)*sha256.Size {
+		return nil, fmt.Errorf("%w: tile %s has %d bytes", ErrVerify, path, len(data))
+	}
+	if r.tiles == nil {
+		r.tiles = map[string][]byte{}
+	}
+	r.tiles[path] = data
+	return data, nil
+}
+
+// tilePath returns the path of a tile like tile/8/1/x001/234.p/5. The
+// index is split in groups of three digits.
+func tilePath(level int, index, width int64) string {
+	n := fmt.Sprintf("%03d", index%1000)
+	for index >= 1000 {
+		index /= 1000
+		n = fmt.Sprintf("x%03d/%s", index%1000, n)
+	}
+	path := fmt.Sprintf("tile/%d/%d/%s", tileHeight, level, n)
+	if width < 1<<tileHeight {
+		path += fmt.Sprintf(".p/%d", width)
+	}
+	return path
+}
@@ -14,7 +14,7 @@ const PypiHTML = `<!DOCTYPE html>
   <meta name="api-version" value="2"/>
 </head>
 <body><h1>Links for {{ .Name }}</h1>
-{{range .Files}}  <a href="{{ .URL }}#sha256={{ .Hashes.Sha256 }}" rel="internal" {{if eq (kindIs .Yanked "bool") false}}data-yanked="{{.Yanked}}"{{end}} {{ $length := len .RequiresPython }} {{ if eq $length 0 }}{{else}}data-requires-python="{{.RequiresPython}}"{{end}}>{{ .Filename }}</a><br/>
+{{range .Files}}  <a href="{{ .URL }}#sha256={{ .Hashes.Sha256 }}" rel="internal" {{with coreMetadata .CoreMetadata}}data-core-metadata="{{ . }}" data-dist-info-metadata="{{ . }}"{{end}} {{if eq (kindIs .Yanked "bool") false}}data-yanked="{{.Yanked}}"{{end}} {{ $length := len .RequiresPython }} {{ if eq $length 0 }}{{else}}data-requires-python="{{.RequiresPython}}"{{end}}>{{ .Filename }}</a><br/>
 {{end}}
 </body>
 </html>
@@ -31,3 +31,14 @@ func (t *TemplateRegistry) Render(w io.Writer, name string, data any, _ echo.Con
 func KindIs(v any, kind string) bool {
 	return reflect.TypeOf(v).Kind().String() == kind
 }
+
+// CoreMetadata returns the PEP 658 attribute value for a file whose metadata
+// hash is known, or an empty string otherwise.
+func CoreMetadata(v any) string {
+	if m, ok := v.(map[string]any); ok {
+		if sha, ok := m["sha256"].(string); ok {
+			return "sha256=" + sha
+		}
+	}
+	return ""
+}
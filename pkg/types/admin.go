@@ -0,0 +1,76 @@
+package types
+
+import "time"
+
+// AdminConfig enables the admin API under /-/admin/. Requests must send
+// one of the tokens as "Authorization: Bearer <token>".
+type AdminConfig struct {
+	Tokens []Secret `yaml:"tokens"`
+}
+
+func (a AdminConfig) Enabled() bool {
+	return len(a.Tokens) > 0
+}
+
+// AdminRepo describes a repo and what it has cached.
+type AdminRepo struct {
+	Repo      string   `json:"repo"`
+	Ecosystem string   `json:"ecosystem"`
+	Name      string   `json:"name"`
+	Mode      string   `json:"mode"`
+	Upstreams []string `json:"upstreams,omitempty"`
+	Dir       string   `json:"dir,omitempty"`
+	Offline   bool     `json:"offline,omitempty"`
+	Objects   int64    `json:"objects"`
+	Size      int64    `json:"size_bytes"`
+	MaxSize   int64    `json:"max_size_bytes,omitempty"`
+}
+
+// AdminPackage sums up the cached objects of a package. Keys is only set
+// when a single package is requested.
+type AdminPackage struct {
+	Name       string         `json:"name"`
+	Objects    int            `json:"objects"`
+	Size       int64          `json:"size_bytes"`
+	LastAccess time.Time      `json:"last_access,omitzero"`
+	Versions   []AdminVersion `json:"versions"`
+	Keys       []string       `json:"keys,omitempty"`
+}
+
+// AdminVersion sums up the cached artifacts of a package version.
+type AdminVersion struct {
+	Version    string    `json:"version"`
+	Objects    int       `json:"objects"`
+	Size       int64     `json:"size_bytes"`
+	LastAccess time.Time `json:"last_access,omitzero"`
+}
+
+// AdminObject describes a cached object.
+type AdminObject struct {
+	Key          string    `json:"key"`
+	Package      string    `json:"package,omitempty"`
+	Version      string    `json:"version,omitempty"`
+	Size         int64     `json:"size_bytes"`
+	ModTime      time.Time `json:"mtime"`
+	SHA256       string    `json:"sha256,omitempty"`
+	URL          string    `json:"url,omitempty"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	ContentType  string    `json:"content_type,omitempty"`
+	FetchedAt    time.Time `json:"fetched_at,omitzero"`
+	LastAccess   time.Time `json:"last_access,omitzero"`
+	Hits         int64     `json:"hits,omitempty"`
+}
+
+// AdminPurge lists the objects a purge removed, or would remove on a dry
+// run.
+type AdminPurge struct {
+	Purged []string `json:"purged"`
+	DryRun bool     `json:"dry_run"`
+}
+
+// AdminReconcile counts the index entries a reconcile added and removed.
+type AdminReconcile struct {
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+}
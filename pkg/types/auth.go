@@ -0,0 +1,88 @@
+package types
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Secret is a credential read from an environment variable or a file. A
+// plain string is used as it is, which is meant for values like custom
+// headers that aren't secret.
+type Secret struct {
+	Value string `yaml:"value"`
+	Env   string `yaml:"env"`
+	File  string `yaml:"file"`
+}
+
+func (s *Secret) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		s.Value = value.Value
+		return nil
+	}
+	type plain Secret
+	return value.Decode((*plain)(s))
+}
+
+func (s Secret) IsSet() bool {
+	return s.Value != "" || s.Env != "" || s.File != ""
+}
+
+// Read returns the secret. Trailing newlines of secret files are dropped.
+func (s Secret) Read() (string, error) {
+	switch {
+	case s.Env != "":
+		v, ok := os.LookupEnv(s.Env)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", s.Env)
+		}
+		return v, nil
+	case s.File != "":
+		data, err := os.ReadFile(filepath.Clean(s.File))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	return s.Value, nil
+}
+
+// AuthConfig holds the credentials sent to the upstreams of a repo. They
+// are only added to requests for the hosts of the repo's upstreams and the
+// extra Hosts, never to redirects or absolute file URLs elsewhere.
+type AuthConfig struct {
+	Username Secret `yaml:"username"`
+	Password Secret `yaml:"password"`
+	// Token is sent as "Authorization: <TokenScheme> <token>". The scheme
+	// is Bearer by default, Automation Hub expects Token.
+	Token       Secret            `yaml:"token"`
+	TokenScheme string            `yaml:"token_scheme"`
+	Headers     map[string]Secret `yaml:"headers"`
+	// Netrc is the path of a .netrc file. Its machine entries are matched
+	// against the host of every upstream request without an Authorization.
+	Netrc string   `yaml:"netrc"`
+	Hosts []string `yaml:"hosts"`
+}
+
+func (a AuthConfig) Validate() error {
+	if a.Username.IsSet() != a.Password.IsSet() {
+		return fmt.Errorf("auth username and password must be set together")
+	}
+	if a.Username.IsSet() && a.Token.IsSet() {
+		return fmt.Errorf("please don't use auth username and token together")
+	}
+	if a.TokenScheme != "" && !a.Token.IsSet() {
+		return fmt.Errorf("auth token_scheme needs a token")
+	}
+	return nil
+}
+
+func (a AuthConfig) Scheme() string {
+	if a.TokenScheme == "" {
+		return "Bearer"
+	}
+	return a.TokenScheme
+}
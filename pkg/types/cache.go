@@ -0,0 +1,48 @@
+package types
+
+import (
+	"path/filepath"
+	"time"
+)
+
+// DefaultEvictInterval is how often the cache size is checked against the
+// quotas unless cache.evict_interval is set.
+const DefaultEvictInterval = 5 * time.Minute
+
+// DefaultIndexFile is the name of the cache index journal in dir unless
+// cache.index is set.
+const DefaultIndexFile = ".hub-index.jsonl"
+
+// CacheConfig limits the space used by cached upstream content. Repos can
+// have their own max_size on top of the global one.
+type CacheConfig struct {
+	MaxSize       ByteSize       `yaml:"max_size"`
+	EvictInterval *time.Duration `yaml:"evict_interval"`
+	// Reconcile turns off reconciling the index with the storage on start
+	// when false. It can still be run through the admin API.
+	Reconcile *bool `yaml:"reconcile"`
+	// Index is the path of the cache index journal. It is always a local
+	// file, also when the cache itself is kept in S3.
+	Index string `yaml:"index"`
+}
+
+// IndexPath returns the path of the cache index journal.
+func (c CacheConfig) IndexPath(dir string) string {
+	if c.Index != "" {
+		return c.Index
+	}
+	return filepath.Join(dir, DefaultIndexFile)
+}
+
+func (c CacheConfig) Interval() time.Duration {
+	if c.EvictInterval == nil || *c.EvictInterval <= 0 {
+		return DefaultEvictInterval
+	}
+	return *c.EvictInterval
+}
+
+// ReconcileOnStart reports whether the index is reconciled with the
+// storage on start, which is the default.
+func (c CacheConfig) ReconcileOnStart() bool {
+	return c.Reconcile == nil || *c.Reconcile
+}
@@ -0,0 +1,120 @@
+package types
+
+import (
+	"path"
+	"strings"
+	"unicode"
+)
+
+// CachedPackage is the package a cached object belongs to.
+type CachedPackage struct {
+	Ecosystem string
+	Repo      string
+	Name      string
+	// Version is empty for metadata that covers every version, like the
+	// index.json of a PyPI project or an npm packument.
+	Version string
+}
+
+// ParseCacheKey returns the package of the cached object key, following
+// the cache layout of its ecosystem. Objects that belong to no single
+// package, like the RubyGems compact index or npm search results, return
+// false. Static files are their own package.
+func ParseCacheKey(key string) (CachedPackage, bool) {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) < 3 || parts[2] == "" {
+		return CachedPackage{}, false
+	}
+	p := CachedPackage{Ecosystem: parts[0], Repo: parts[1]}
+	rest := parts[2]
+	switch p.Ecosystem {
+	case "pypi":
+		name, file, ok := strings.Cut(rest, "/")
+		if !ok || strings.Contains(file, "/") {
+			return p, false
+		}
+		p.Name = name
+		if file != "index.json" {
+			_, p.Version, _ = PypiParseFilename(file)
+		}
+		return p, true
+	case "npm":
+		if tarball, ok := strings.CutPrefix(rest, "tarballs/"); ok {
+			i := strings.Index(tarball, "/-/")
+			if i <= 0 {
+				return p, false
+			}
+			p.Name = tarball[:i]
+			file := strings.TrimSuffix(strings.TrimSuffix(path.Base(tarball), ".tgz"), ".tar.gz")
+			p.Version = strings.TrimPrefix(file, path.Base(p.Name)+"-")
+			return p, true
+		}
+		if dir, ok := strings.CutPrefix(rest, "metadata/"); ok && strings.Contains(dir, "/") {
+			p.Name = path.Dir(dir)
+			return p, true
+		}
+	case "goproxy":
+		i := strings.Index(rest, "/@")
+		if i <= 0 {
+			return p, false
+		}
+		p.Name = GoproxyUnescape(rest[:i])
+		if file, ok := strings.CutPrefix(rest[i:], "/@v/"); ok && file != "list" {
+			p.Version = GoproxyUnescape(strings.TrimSuffix(file, path.Ext(file)))
+		}
+		return p, true
+	case "rubygems":
+		if name, ok := strings.CutPrefix(rest, "info/"); ok {
+			p.Name = name
+			return p, true
+		}
+		for _, f := range []struct{ dir, ext string }{{"gems/", ".gem"}, {"quick/Marshal.4.8/", ".gemspec.rz"}} {
+			if file, ok := strings.CutPrefix(rest, f.dir); ok && strings.HasSuffix(file, f.ext) {
+				p.Name, p.Version = RubyGemsSplitFilename(strings.TrimSuffix(file, f.ext))
+				return p, p.Version != ""
+			}
+		}
+	case "galaxy":
+		// Collections are cached below index/ and binary/ by namespace and
+		// name.
+		segments := strings.Split(rest, "/")
+		if len(segments) < 4 || segments[0] != "index" && segments[0] != "binary" {
+			return p, false
+		}
+		p.Name = segments[1] + "." + segments[2]
+		switch {
+		case segments[0] == "binary":
+			file := strings.TrimSuffix(segments[3], ".tar.gz")
+			p.Version = strings.TrimPrefix(file, segments[1]+"-"+segments[2]+"-")
+		case len(segments) == 6 && segments[3] == "versions" && segments[5] == "index.json":
+			p.Version = segments[4]
+		}
+		return p, true
+	case "static":
+		p.Name = rest
+		return p, true
+	}
+	return p, false
+}
+
+// RubyGemsSplitFilename splits a gem file name without extension into the
+// gem name and its version, including the platform. Versions start with a
+// digit, gem names rarely have a segment that does.
+func RubyGemsSplitFilename(filename string) (name, version string) {
+	parts := strings.Split(filename, "-")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" && unicode.IsDigit(rune(parts[i][0])) {
+			return strings.Join(parts[:i], "-"), strings.Join(parts[i:], "-")
+		}
+	}
+	return filename, ""
+}
+
+// SamePackage reports whether the package names a and b of ecosystem are
+// the same, PyPI names are compared normalized.
+func SamePackage(ecosystem, a, b string) bool {
+	if ecosystem == "pypi" {
+		return PypiNormalizeName(a) == PypiNormalizeName(b)
+	}
+	return a == b
+}
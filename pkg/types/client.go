@@ -0,0 +1,72 @@
+package types
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	DefaultConnectTimeout = 10 * time.Second
+	DefaultHeaderTimeout  = time.Minute
+	DefaultRetryBackoff   = time.Second
+)
+
+// ClientConfig tunes the HTTP client used for the upstreams of a repo.
+// Timeout limits a whole request including the body, it is unset by
+// default so large downloads are not cut off.
+type ClientConfig struct {
+	ConnectTimeout *time.Duration `yaml:"connect_timeout"`
+	HeaderTimeout  *time.Duration `yaml:"header_timeout"`
+	Timeout        time.Duration  `yaml:"timeout"`
+	// Retries is how many times a request is repeated after network errors
+	// and 5xx answers. The wait starts at RetryBackoff and doubles.
+	Retries      int            `yaml:"retries"`
+	RetryBackoff *time.Duration `yaml:"retry_backoff"`
+	// Proxy overrides the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment.
+	Proxy    string `yaml:"proxy"`
+	CAFile   string `yaml:"ca_file"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+func (c ClientConfig) Validate() error {
+	if c.Retries < 0 {
+		return fmt.Errorf("retries can't be negative")
+	}
+	for name, d := range map[string]*time.Duration{"connect_timeout": c.ConnectTimeout, "header_timeout": c.HeaderTimeout, "timeout": &c.Timeout, "retry_backoff": c.RetryBackoff} {
+		if d != nil && *d < 0 {
+			return fmt.Errorf("%s can't be negative", name)
+		}
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("cert_file and key_file must be set together")
+	}
+	if c.Proxy != "" {
+		if u, err := url.Parse(c.Proxy); err != nil || u.Host == "" {
+			return fmt.Errorf("invalid proxy %q", c.Proxy)
+		}
+	}
+	return nil
+}
+
+func (c ClientConfig) Connect() time.Duration {
+	if c.ConnectTimeout == nil {
+		return DefaultConnectTimeout
+	}
+	return *c.ConnectTimeout
+}
+
+func (c ClientConfig) Header() time.Duration {
+	if c.HeaderTimeout == nil {
+		return DefaultHeaderTimeout
+	}
+	return *c.HeaderTimeout
+}
+
+func (c ClientConfig) Backoff() time.Duration {
+	if c.RetryBackoff == nil {
+		return DefaultRetryBackoff
+	}
+	return *c.RetryBackoff
+}
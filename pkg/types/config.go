@@ -9,20 +9,40 @@ import (
 )
 
 type ConfigFile struct {
-	Dir    string `yaml:"dir"`
-	Server struct {
-		Galaxy map[string]struct {
-			URL string `yaml:"url"`
-			Dir string `yaml:"dir"`
-		} `yaml:"galaxy"`
-		PYPI     map[string]string `yaml:"pypi"`
-		RUBYGEMS map[string]string `yaml:"rubygems"`
-		Static   map[string]string `yaml:"static"`
-		GOPROXY  map[string]string `yaml:"goproxy"`
-		NPM      map[string]string `yaml:"npm"`
+	Dir     string        `yaml:"dir"`
+	Storage StorageConfig `yaml:"storage"`
+	Cache   CacheConfig   `yaml:"cache"`
+	Admin   AdminConfig   `yaml:"admin"`
+	Server  struct {
+		Galaxy   map[string]Repo `yaml:"galaxy"`
+		PYPI     map[string]Repo `yaml:"pypi"`
+		RUBYGEMS map[string]Repo `yaml:"rubygems"`
+		Static   map[string]Repo `yaml:"static"`
+		GOPROXY  map[string]Repo `yaml:"goproxy"`
+		NPM      map[string]Repo `yaml:"npm"`
+		Groups   struct {
+			PYPI map[string]Group `yaml:"pypi"`
+			NPM  map[string]Group `yaml:"npm"`
+		} `yaml:"groups"`
+		Rules struct {
+			PYPI     RoutingRules `yaml:"pypi"`
+			NPM      RoutingRules `yaml:"npm"`
+			GOPROXY  RoutingRules `yaml:"goproxy"`
+			RUBYGEMS RoutingRules `yaml:"rubygems"`
+		} `yaml:"rules"`
 	} `yaml:"server"`
 }
 
+// SetOffline switches every repo to offline mode.
+func (c *ConfigFile) SetOffline() {
+	for _, repos := range []map[string]Repo{c.Server.Galaxy, c.Server.PYPI, c.Server.RUBYGEMS, c.Server.Static, c.Server.GOPROXY, c.Server.NPM} {
+		for k, v := range repos {
+			v.Offline = true
+			repos[k] = v
+		}
+	}
+}
+
 func (c *ConfigFile) Load(cfgFile string) {
 	yamlFile, err := os.ReadFile(filepath.Clean(cfgFile))
 	if err != nil {
@@ -3,8 +3,6 @@ package types
 import (
 	"encoding/json"
 	"fmt"
-	"os"
-	"path/filepath"
 	"time"
 )
 
@@ -23,13 +21,8 @@ type GalaxyCollection struct {
 	DownloadCount int       `json:"download_count"`
 }
 
-func (c *GalaxyCollection) ReadFromJSONFile(filePath string) error {
-	fileContent, err := os.ReadFile(filepath.Clean(filePath))
-	if err != nil {
-		return fmt.Errorf("error reading file: %v", err)
-	}
-
-	err = json.Unmarshal(fileContent, c)
+func (c *GalaxyCollection) ReadFromJSON(fileContent []byte) error {
+	err := json.Unmarshal(fileContent, c)
 	if err != nil {
 		return fmt.Errorf("error unmarshalling JSON: %v", err)
 	}
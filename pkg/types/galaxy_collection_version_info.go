@@ -3,8 +3,6 @@ package types
 import (
 	"encoding/json"
 	"fmt"
-	"os"
-	"path/filepath"
 	"time"
 )
 
@@ -87,13 +85,8 @@ type GalaxyCollectionVersionInfo struct {
 	Files        GalaxyCollectionVersionInfoFiles    `json:"files"`
 }
 
-func (c *GalaxyCollectionVersionInfo) ReadFromJSONFile(filePath string) error {
-	fileContent, err := os.ReadFile(filepath.Clean(filePath))
-	if err != nil {
-		return fmt.Errorf("error reading file: %v", err)
-	}
-
-	err = json.Unmarshal(fileContent, c)
+func (c *GalaxyCollectionVersionInfo) ReadFromJSON(fileContent []byte) error {
+	err := json.Unmarshal(fileContent, c)
 	if err != nil {
 		return fmt.Errorf("error unmarshalling JSON: %v", err)
 	}
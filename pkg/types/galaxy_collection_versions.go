@@ -4,8 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/url"
-	"os"
-	"path/filepath"
 	"time"
 )
 
@@ -31,13 +29,8 @@ type GalaxyCollectionVersions struct {
 	Data []GalaxyCollectionVersion `json:"data"`
 }
 
-func (c *GalaxyCollectionVersions) ReadFromJSONFile(filePath, key, namespace, name string) error {
-	fileContent, err := os.ReadFile(filepath.Clean(filePath))
-	if err != nil {
-		return fmt.Errorf("error reading file: %v", err)
-	}
-
-	err = json.Unmarshal(fileContent, c)
+func (c *GalaxyCollectionVersions) ReadFromJSON(fileContent []byte, key, namespace, name string) error {
+	err := json.Unmarshal(fileContent, c)
 	if err != nil {
 		return fmt.Errorf("error unmarshalling JSON: %v", err)
 	}
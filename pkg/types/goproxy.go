@@ -1,7 +1,9 @@
 package types
 
 import (
+	"strings"
 	"time"
+	"unicode"
 )
 
 // GoProxyInfo represents the .info file format for a module version
@@ -9,3 +11,36 @@ type GoProxyInfo struct {
 	Version string    `json:"Version"`
 	Time    time.Time `json:"Time"`
 }
+
+// GoproxyEscape applies the case encoding of module proxy paths, so
+// github.com/Azure becomes github.com/!azure.
+func GoproxyEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if unicode.IsUpper(r) {
+			b.WriteByte('!')
+			b.WriteRune(unicode.ToLower(r))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// GoproxyUnescape reverses GoproxyEscape.
+func GoproxyUnescape(s string) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range s {
+		if r == '!' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,24 @@
+package types
+
+import (
+	"fmt"
+)
+
+// Group combines several repositories of one ecosystem behind a single key.
+// Members are listed in precedence order: when two members serve the same
+// file or version, the one listed first wins.
+type Group struct {
+	Members []string `yaml:"members"`
+}
+
+func (g Group) Validate(repos map[string]bool) error {
+	if len(g.Members) == 0 {
+		return fmt.Errorf("members list is empty")
+	}
+	for _, m := range g.Members {
+		if !repos[m] {
+			return fmt.Errorf("member %q is not defined", m)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package types
+
+import "time"
+
+// DefaultHealthCheckInterval is how often the upstreams of a repo with
+// more than one upstream are checked unless health_check.interval is set.
+const DefaultHealthCheckInterval = 30 * time.Second
+
+// HealthCheck configures the active checks of a repo's upstreams. Each
+// upstream gets a HEAD request for its URL with Path appended.
+type HealthCheck struct {
+	Interval *time.Duration `yaml:"interval"`
+	Path     string         `yaml:"path"`
+}
+
+func (h HealthCheck) Every() time.Duration {
+	if h.Interval == nil || *h.Interval <= 0 {
+		return DefaultHealthCheckInterval
+	}
+	return *h.Interval
+}
@@ -0,0 +1,115 @@
+package types
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LocalScanInterval is how long the listing of a dir mode repo is reused
+// before its directory tree is walked again.
+const LocalScanInterval = 10 * time.Second
+
+// localScan is the last listing of a directory tree.
+type localScan[T any] struct {
+	sync.Mutex
+	scanned time.Time
+	value   T
+}
+
+// localScans keeps the listing of each directory of a kind of dir repo.
+type localScans[T any] struct {
+	sync.Mutex
+	dirs map[string]*localScan[T]
+}
+
+// get returns the listing of dir, walking it with scan when the last
+// listing is older than LocalScanInterval. Requests for the same dir wait
+// for a running walk instead of starting their own. Failed walks are not
+// kept.
+func (s *localScans[T]) get(dir string, scan func(dir string) (T, error)) (T, error) {
+	s.Lock()
+	if s.dirs == nil {
+		s.dirs = map[string]*localScan[T]{}
+	}
+	d, ok := s.dirs[dir]
+	if !ok {
+		d = &localScan[T]{}
+		s.dirs[dir] = d
+	}
+	s.Unlock()
+
+	d.Lock()
+	defer d.Unlock()
+	if !d.scanned.IsZero() && time.Since(d.scanned) < LocalScanInterval {
+		return d.value, nil
+	}
+	value, err := scan(dir)
+	if err != nil {
+		return value, err
+	}
+	d.scanned, d.value = time.Now(), value
+	return value, nil
+}
+
+// localFile is what was read from a file at a given size and modification
+// time.
+type localFile[T any] struct {
+	size    int64
+	modTime time.Time
+	value   T
+}
+
+// localFiles remembers what was read from the files of dir repos, so a
+// file is only read again once it changes.
+type localFiles[T any] struct {
+	sync.Mutex
+	files map[string]localFile[T]
+}
+
+func (f *localFiles[T]) get(path string, size int64, modTime time.Time, read func(path string) (T, error)) (T, error) {
+	f.Lock()
+	cached, ok := f.files[path]
+	f.Unlock()
+	if ok && cached.size == size && cached.modTime.Equal(modTime) {
+		return cached.value, nil
+	}
+
+	value, err := read(path)
+	if err != nil {
+		return value, err
+	}
+	f.Lock()
+	if f.files == nil {
+		f.files = map[string]localFile[T]{}
+	}
+	f.files[path] = localFile[T]{size: size, modTime: modTime, value: value}
+	f.Unlock()
+	return value, nil
+}
+
+var localSHA256s localFiles[string]
+
+// LocalSHA256 returns the sha256 of a file of a dir repo that has size and
+// modTime. The file is only hashed again once it changed.
+func LocalSHA256(path string, size int64, modTime time.Time) (string, error) {
+	return localSHA256s.get(path, size, modTime, localReadSHA256)
+}
+
+func localReadSHA256(path string) (string, error) {
+	file, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,150 @@
+package types
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// DefaultMirrorConcurrency is how many artifacts a mirror run fetches at
+// the same time unless mirror.concurrency is set.
+const DefaultMirrorConcurrency = 4
+
+// MirrorConfig selects packages of a repo that are fetched on a schedule,
+// before anyone asks for them.
+type MirrorConfig struct {
+	Schedule    string          `yaml:"schedule"`
+	Concurrency int             `yaml:"concurrency"`
+	Packages    []MirrorPackage `yaml:"packages"`
+}
+
+// MirrorPackage selects versions of a package. Name may be a glob like
+// django-* or @types/*, which is matched against the packages cached in
+// the repo.
+type MirrorPackage struct {
+	Name string `yaml:"name"`
+	// Versions is a constraint like ">=2.0, <3", every version when empty.
+	Versions string `yaml:"versions"`
+	// Latest limits the mirror to the newest matching versions, zero means
+	// all of them.
+	Latest int `yaml:"latest"`
+	// Prereleases are skipped unless set.
+	Prereleases bool `yaml:"prereleases"`
+}
+
+func (m MirrorConfig) Enabled() bool {
+	return len(m.Packages) > 0
+}
+
+// IsGlob reports whether the name selects several packages.
+func (p MirrorPackage) IsGlob() bool {
+	return strings.ContainsAny(p.Name, "*?[")
+}
+
+func (m MirrorConfig) Validate() error {
+	if !m.Enabled() {
+		if m.Schedule != "" {
+			return fmt.Errorf("mirror has a schedule but no packages")
+		}
+		return nil
+	}
+	if m.Schedule == "" {
+		return fmt.Errorf("mirror needs a schedule")
+	}
+	schedule, err := ParseSchedule(m.Schedule)
+	if err != nil {
+		return err
+	}
+	if schedule.Next(time.Now()).IsZero() {
+		return fmt.Errorf("mirror schedule %q never fires", m.Schedule)
+	}
+	if m.Concurrency < 0 {
+		return fmt.Errorf("mirror concurrency can't be negative")
+	}
+	for _, p := range m.Packages {
+		if p.Name == "" {
+			return fmt.Errorf("mirror package without name")
+		}
+		if _, err := path.Match(p.Name, ""); err != nil {
+			return fmt.Errorf("invalid mirror package %q: %w", p.Name, err)
+		}
+		if p.Latest < 0 {
+			return fmt.Errorf("mirror package %q: latest can't be negative", p.Name)
+		}
+		if _, err := ParseVersionConstraint(p.Versions); err != nil {
+			return fmt.Errorf("mirror package %q: %w", p.Name, err)
+		}
+	}
+	return nil
+}
+
+func (m MirrorConfig) Workers() int {
+	if m.Concurrency <= 0 {
+		return DefaultMirrorConcurrency
+	}
+	return m.Concurrency
+}
+
+// VersionConstraint is a list of comparisons like >=1.2, <2 that a version
+// has to satisfy all of.
+type VersionConstraint []VersionComparison
+
+type VersionComparison struct {
+	Op      string
+	Version string
+}
+
+var versionOps = []string{">=", "<=", "==", "!=", ">", "<", "="}
+
+func ParseVersionConstraint(s string) (VersionConstraint, error) {
+	var c VersionConstraint
+	if strings.TrimSpace(s) == "" {
+		return c, nil
+	}
+	for part := range strings.SplitSeq(s, ",") {
+		part = strings.TrimSpace(part)
+		op := "=="
+		for _, o := range versionOps {
+			if rest, ok := strings.CutPrefix(part, o); ok {
+				op, part = o, strings.TrimSpace(rest)
+				break
+			}
+		}
+		if op == "=" {
+			op = "=="
+		}
+		if part == "" || strings.ContainsAny(part, " <>=!") {
+			return nil, fmt.Errorf("invalid version constraint %q", s)
+		}
+		c = append(c, VersionComparison{Op: op, Version: part})
+	}
+	return c, nil
+}
+
+// Allows reports whether version satisfies every comparison, compare
+// orders two versions like strings.Compare.
+func (c VersionConstraint) Allows(version string, compare func(a, b string) int) bool {
+	for _, v := range c {
+		r := compare(version, v.Version)
+		ok := false
+		switch v.Op {
+		case ">=":
+			ok = r >= 0
+		case "<=":
+			ok = r <= 0
+		case ">":
+			ok = r > 0
+		case "<":
+			ok = r < 0
+		case "==":
+			ok = r == 0
+		case "!=":
+			ok = r != 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
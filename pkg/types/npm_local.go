@@ -0,0 +1,158 @@
+package types
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha1" //nolint:gosec // npm clients still verify dist.shasum
+	"crypto/sha512"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// NpmLocalTarball is a package tarball found in an npm dir repo.
+type NpmLocalTarball struct {
+	Name     string
+	Version  string
+	Path     string
+	Size     int64
+	Time     time.Time
+	Manifest map[string]any
+}
+
+// NpmLocal groups the tarballs of a directory tree by package name, as read
+// from each tarball's package.json.
+// Tarballs that can't be read are skipped and reported in Errors.
+type NpmLocal struct {
+	Packages map[string][]NpmLocalTarball
+	Errors   []error
+}
+
+// npmLocalListing is a walk of an npm dir repo.
+type npmLocalListing struct {
+	packages map[string][]NpmLocalTarball
+	errors   []error
+}
+
+// npmDigests are the sha1 and sha512 of a tarball.
+type npmDigests struct {
+	sha1   []byte
+	sha512 []byte
+}
+
+var (
+	npmLocalScans     localScans[npmLocalListing]
+	npmLocalManifests localFiles[map[string]any]
+	npmLocalDigests   localFiles[npmDigests]
+)
+
+// List reads the tarballs of dest. The tree is walked at most once per
+// LocalScanInterval and package.json is only read again from tarballs that
+// changed. The slices and manifests of Packages are shared with other
+// callers and must not be modified.
+func (n *NpmLocal) List(dest string) error {
+	listing, err := npmLocalScans.get(dest, npmLocalScan)
+	if err != nil {
+		return err
+	}
+	n.Packages, n.Errors = listing.packages, listing.errors
+	return nil
+}
+
+func npmLocalScan(dest string) (npmLocalListing, error) {
+	n := npmLocalListing{packages: map[string][]NpmLocalTarball{}}
+	err := filepath.Walk(dest, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") || !strings.HasSuffix(info.Name(), ".tgz") {
+			return nil
+		}
+		manifest, err := npmLocalManifests.get(filePath, info.Size(), info.ModTime(), NpmReadPackageJSON)
+		if err != nil {
+			n.errors = append(n.errors, fmt.Errorf("%s: %v", filePath, err))
+			return nil
+		}
+		name, _ := manifest["name"].(string)
+		version, _ := manifest["version"].(string)
+		if name == "" || version == "" {
+			n.errors = append(n.errors, fmt.Errorf("%s: package.json has no name or version", filePath))
+			return nil
+		}
+		n.packages[name] = append(n.packages[name], NpmLocalTarball{
+			Name:     name,
+			Version:  version,
+			Path:     filePath,
+			Size:     info.Size(),
+			Time:     info.ModTime(),
+			Manifest: manifest,
+		})
+		return nil
+	})
+	if err != nil {
+		return n, fmt.Errorf("unable to parse directory %s, got error: %s", dest, err)
+	}
+	return n, nil
+}
+
+// Digests returns the sha1 and sha512 of the tarball. They are only
+// computed again once the tarball changed.
+func (t NpmLocalTarball) Digests() (sha1Sum, sha512Sum []byte, err error) {
+	d, err := npmLocalDigests.get(t.Path, t.Size, t.Time, npmReadDigests)
+	return d.sha1, d.sha512, err
+}
+
+func npmReadDigests(tarballPath string) (npmDigests, error) {
+	file, err := os.Open(filepath.Clean(tarballPath))
+	if err != nil {
+		return npmDigests{}, err
+	}
+	defer file.Close()
+
+	sha1Hash := sha1.New() //nolint:gosec // npm clients still verify dist.shasum
+	sha512Hash := sha512.New()
+	if _, err := io.Copy(io.MultiWriter(sha1Hash, sha512Hash), file); err != nil {
+		return npmDigests{}, err
+	}
+	return npmDigests{sha1: sha1Hash.Sum(nil), sha512: sha512Hash.Sum(nil)}, nil
+}
+
+// NpmReadPackageJSON reads package.json from the top-level directory of a
+// package tarball. npm uses `package/` but accepts any single directory.
+func NpmReadPackageJSON(tarballPath string) (map[string]any, error) {
+	file, err := os.Open(filepath.Clean(tarballPath))
+	if err != nil {
+		return nil, fmt.Errorf("open tarball error: %v", err)
+	}
+	defer file.Close()
+
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("reader gzip error: %v", err)
+	}
+	defer gzipReader.Close()
+
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no package.json found")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading tar: %v", err)
+		}
+		dir, name, found := strings.Cut(strings.TrimPrefix(header.Name, "./"), "/")
+		if !found || dir == "" || name != "package.json" {
+			continue
+		}
+		var manifest map[string]any
+		if err := json.NewDecoder(tarReader).Decode(&manifest); err != nil {
+			return nil, fmt.Errorf("error parsing package.json: %v", err)
+		}
+		return manifest, nil
+	}
+}
@@ -0,0 +1,123 @@
+package types
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// RevalidateTTL serves the cached copy until its TTL ran out and
+	// downloads it again afterwards.
+	RevalidateTTL = "ttl"
+	// RevalidateETag sends a conditional request with the stored ETag and
+	// Last-Modified once the TTL ran out.
+	RevalidateETag = "etag"
+	// RevalidateAlways contacts upstream on every request.
+	RevalidateAlways = "always"
+)
+
+// Policy tunes how long cached content of a repo is served without asking
+// upstream. Unset fields keep the defaults of each endpoint.
+type Policy struct {
+	Metadata  CachePolicy `yaml:"metadata"`
+	Artifacts CachePolicy `yaml:"artifacts"`
+	Search    CachePolicy `yaml:"search"`
+	// NegativeTTL is how long upstream 404 and 410 answers are remembered.
+	// Unset or zero disables negative caching.
+	NegativeTTL *time.Duration `yaml:"negative_ttl"`
+}
+
+// CachePolicy is the freshness configuration of one resource class.
+type CachePolicy struct {
+	TTL        *time.Duration `yaml:"ttl"`
+	Revalidate string         `yaml:"revalidate"`
+	// MaxStale limits the age of a cached copy that is still served when
+	// upstream fails. Zero means no limit.
+	MaxStale *time.Duration `yaml:"max_stale"`
+	// StaleWhileRevalidate is how long after the TTL ran out a cached copy
+	// is still served right away while it is refreshed in the background.
+	StaleWhileRevalidate *time.Duration `yaml:"stale_while_revalidate"`
+	// Immutable content is never revalidated once cached.
+	Immutable *bool `yaml:"immutable"`
+}
+
+// Freshness is a CachePolicy with all fields resolved.
+type Freshness struct {
+	TTL                  time.Duration
+	Revalidate           string
+	MaxStale             time.Duration
+	StaleWhileRevalidate time.Duration
+	Immutable            bool
+}
+
+func (p Policy) Validate() error {
+	for name, cp := range map[string]CachePolicy{"metadata": p.Metadata, "artifacts": p.Artifacts, "search": p.Search} {
+		switch cp.Revalidate {
+		case "", RevalidateTTL, RevalidateETag, RevalidateAlways:
+		default:
+			return fmt.Errorf("unknown %s revalidate mode %q", name, cp.Revalidate)
+		}
+		if cp.TTL != nil && *cp.TTL < 0 || cp.MaxStale != nil && *cp.MaxStale < 0 || cp.StaleWhileRevalidate != nil && *cp.StaleWhileRevalidate < 0 {
+			return fmt.Errorf("%s durations can't be negative", name)
+		}
+	}
+	if p.NegativeTTL != nil && *p.NegativeTTL < 0 {
+		return fmt.Errorf("negative_ttl can't be negative")
+	}
+	return nil
+}
+
+func (p Policy) NegativeCacheTTL() time.Duration {
+	if p.NegativeTTL == nil {
+		return 0
+	}
+	return *p.NegativeTTL
+}
+
+// Resolve fills the unset fields of p from the endpoint defaults.
+func (p CachePolicy) Resolve(defaults Freshness) Freshness {
+	f := defaults
+	if p.TTL != nil {
+		f.TTL = *p.TTL
+		if p.Revalidate == "" && f.Revalidate == RevalidateAlways {
+			f.Revalidate = RevalidateTTL
+		}
+	}
+	if p.Revalidate != "" {
+		f.Revalidate = p.Revalidate
+	}
+	if p.MaxStale != nil {
+		f.MaxStale = *p.MaxStale
+	}
+	if p.StaleWhileRevalidate != nil {
+		f.StaleWhileRevalidate = *p.StaleWhileRevalidate
+	}
+	if p.Immutable != nil {
+		f.Immutable = *p.Immutable
+	}
+	return f
+}
+
+// Fresh reports whether a copy fetched at fetchedAt can be served without
+// contacting upstream.
+func (f Freshness) Fresh(fetchedAt time.Time) bool {
+	if f.Immutable {
+		return true
+	}
+	if f.Revalidate == RevalidateAlways {
+		return false
+	}
+	return time.Since(fetchedAt) < f.TTL
+}
+
+// Revalidating reports whether a copy fetched at fetchedAt that is not
+// fresh anymore can be served while it is refreshed in the background.
+func (f Freshness) Revalidating(fetchedAt time.Time) bool {
+	return f.StaleWhileRevalidate > 0 && time.Since(fetchedAt) < f.TTL+f.StaleWhileRevalidate
+}
+
+// ServeStale reports whether a copy fetched at fetchedAt may still be
+// served after upstream failed.
+func (f Freshness) ServeStale(fetchedAt time.Time) bool {
+	return f.MaxStale == 0 || time.Since(fetchedAt) < f.MaxStale
+}
@@ -3,26 +3,35 @@ package types
 import (
 	"encoding/json"
 	"fmt"
-	"os"
-	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
 )
 
+var pypiNameSeparators = regexp.MustCompile(`[-_.]+`)
+
+// PypiNormalizeName returns the PEP 503 normalized form of a project name.
+func PypiNormalizeName(name string) string {
+	return strings.ToLower(pypiNameSeparators.ReplaceAllString(name, "-"))
+}
+
+type PypiFile struct {
+	CoreMetadata         any    `json:"core-metadata"`
+	DataDistInfoMetadata any    `json:"data-dist-info-metadata"`
+	Filename             string `json:"filename"`
+	Hashes               struct {
+		Sha256 string `json:"sha256"`
+	} `json:"hashes"`
+	RequiresPython string    `json:"requires-python"`
+	Size           int       `json:"size"`
+	UploadTime     time.Time `json:"upload-time"`
+	URL            string    `json:"url"`
+	Yanked         any       `json:"yanked"`
+}
+
 type PypiMetadata struct {
-	Files []struct {
-		CoreMetadata         any    `json:"core-metadata"`
-		DataDistInfoMetadata any    `json:"data-dist-info-metadata"`
-		Filename             string `json:"filename"`
-		Hashes               struct {
-			Sha256 string `json:"sha256"`
-		} `json:"hashes"`
-		RequiresPython string    `json:"requires-python"`
-		Size           int       `json:"size"`
-		UploadTime     time.Time `json:"upload-time"`
-		URL            string    `json:"url"`
-		Yanked         any       `json:"yanked"`
-	} `json:"files"`
-	Meta struct {
+	Files []PypiFile `json:"files"`
+	Meta  struct {
 		LastSerial int    `json:"_last-serial"`
 		APIVersion string `json:"api-version"`
 	} `json:"meta"`
@@ -30,13 +39,8 @@ type PypiMetadata struct {
 	Versions []string `json:"versions"`
 }
 
-func (p *PypiMetadata) ReadFromJSONFile(filePath string) error {
-	fileContent, err := os.ReadFile(filepath.Clean(filePath))
-	if err != nil {
-		return fmt.Errorf("error reading file: %v", err)
-	}
-
-	err = json.Unmarshal(fileContent, p)
+func (p *PypiMetadata) ReadFromJSON(fileContent []byte) error {
+	err := json.Unmarshal(fileContent, p)
 	if err != nil {
 		return fmt.Errorf("error unmarshalling JSON: %v", err)
 	}
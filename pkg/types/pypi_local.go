@@ -0,0 +1,164 @@
+package types
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// PypiLocalFile is a wheel or sdist found in a PyPI dir repo.
+type PypiLocalFile struct {
+	Filename string
+	Path     string
+	Project  string
+	Version  string
+	Size     int64
+	Time     time.Time
+}
+
+func (f PypiLocalFile) IsWheel() bool {
+	return strings.HasSuffix(f.Filename, ".whl")
+}
+
+// Metadata returns the METADATA file of a wheel. It is only extracted again
+// once the wheel changed.
+func (f PypiLocalFile) Metadata() ([]byte, error) {
+	return pypiWheelMetadata.get(f.Path, f.Size, f.Time, PypiWheelMetadata)
+}
+
+// SHA256 returns the sha256 of the file. It is only hashed again once the
+// file changed.
+func (f PypiLocalFile) SHA256() (string, error) {
+	return LocalSHA256(f.Path, f.Size, f.Time)
+}
+
+// PypiLocal groups the distributions of a directory tree by PEP 503
+// normalized project name.
+type PypiLocal struct {
+	Projects map[string][]PypiLocalFile
+}
+
+var (
+	pypiLocalScans    localScans[map[string][]PypiLocalFile]
+	pypiWheelMetadata localFiles[[]byte]
+)
+
+// List reads the distributions of dest. The tree is walked at most once
+// per LocalScanInterval, the slices of Projects are shared with other
+// callers and must not be modified.
+func (p *PypiLocal) List(dest string) error {
+	projects, err := pypiLocalScans.get(dest, pypiLocalScan)
+	if err != nil {
+		return err
+	}
+	p.Projects = projects
+	return nil
+}
+
+func pypiLocalScan(dest string) (map[string][]PypiLocalFile, error) {
+	projects := map[string][]PypiLocalFile{}
+	err := filepath.Walk(dest, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+		project, version, ok := PypiParseFilename(info.Name())
+		if !ok {
+			return nil
+		}
+		name := PypiNormalizeName(project)
+		projects[name] = append(projects[name], PypiLocalFile{
+			Filename: info.Name(),
+			Path:     filePath,
+			Project:  project,
+			Version:  version,
+			Size:     info.Size(),
+			Time:     info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse directory %s, got error: %s", dest, err)
+	}
+	return projects, nil
+}
+
+// PypiParseFilename extracts the project name and version from a wheel or
+// sdist file name.
+func PypiParseFilename(filename string) (project, version string, ok bool) {
+	if base, found := strings.CutSuffix(filename, ".whl"); found {
+		parts := strings.Split(base, "-")
+		if len(parts) < 5 {
+			return "", "", false
+		}
+		return parts[0], parts[1], true
+	}
+
+	base := ""
+	for _, ext := range []string{".tar.gz", ".zip", ".tar.bz2"} {
+		if b, found := strings.CutSuffix(filename, ext); found {
+			base = b
+			break
+		}
+	}
+	if base == "" {
+		return "", "", false
+	}
+	// sdist names may contain dashes, the version starts after the last
+	// dash that is followed by a digit.
+	for i := len(base) - 1; i > 0; i-- {
+		if base[i] == '-' && i+1 < len(base) && unicode.IsDigit(rune(base[i+1])) {
+			return base[:i], base[i+1:], true
+		}
+	}
+	return "", "", false
+}
+
+// PypiWheelMetadata returns the METADATA file of a wheel's .dist-info
+// directory.
+func PypiWheelMetadata(wheelPath string) ([]byte, error) {
+	archive, err := zip.OpenReader(filepath.Clean(wheelPath))
+	if err != nil {
+		return nil, fmt.Errorf("unable to open wheel: %v", err)
+	}
+	defer archive.Close()
+
+	for _, f := range archive.File {
+		dir, name, found := strings.Cut(f.Name, "/")
+		if !found || name != "METADATA" || !strings.HasSuffix(dir, ".dist-info") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("unable to open %s: %v", f.Name, err)
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+	return nil, fmt.Errorf("no .dist-info/METADATA in %s", filepath.Base(wheelPath))
+}
+
+// PypiMetadataField returns the first value of a core metadata header.
+func PypiMetadataField(metadata []byte, field string) string {
+	scanner := bufio.NewScanner(bytes.NewReader(metadata))
+	prefix := field + ":"
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		if value, found := strings.CutPrefix(line, prefix); found {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
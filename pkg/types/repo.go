@@ -0,0 +1,122 @@
+package types
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	RepoModeProxy  = "proxy"
+	RepoModeHosted = "hosted"
+)
+
+// Repo is a single repository definition. A plain string value is treated
+// as the upstream URL, so short definitions like `k8s: https://dl.k8s.io`
+// keep working next to the extended mapping form.
+type Repo struct {
+	URL string `yaml:"url"`
+	// URLs lists several upstreams, tried in order on connection errors
+	// and 5xx answers. URL is set to the first one.
+	URLs        []string     `yaml:"urls"`
+	HealthCheck HealthCheck  `yaml:"health_check"`
+	Client      ClientConfig `yaml:"client"`
+	Auth        AuthConfig   `yaml:"auth"`
+	Dir         string       `yaml:"dir"`
+	Mode        string       `yaml:"mode"`
+	Policy      Policy       `yaml:"policy"`
+	// MaxSize limits the cached content of a proxy repo. Zero means it is
+	// only limited by the global cache quota.
+	MaxSize ByteSize `yaml:"max_size"`
+	// Offline repos only serve what is cached and never contact upstream.
+	Offline bool `yaml:"offline"`
+	// SumDB is only used by goproxy repos.
+	SumDB SumDBConfig `yaml:"sumdb"`
+	// Mirror is only used by pypi, npm and goproxy repos.
+	Mirror MirrorConfig `yaml:"mirror"`
+	// UploadTokens guard uploads and deletes of hosted static repos. They
+	// are sent as "Authorization: Bearer <token>".
+	UploadTokens []Secret `yaml:"upload_tokens"`
+}
+
+func (r *Repo) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		r.URL = value.Value
+		return nil
+	}
+	type plain Repo
+	if err := value.Decode((*plain)(r)); err != nil {
+		return err
+	}
+	if len(r.URLs) > 0 {
+		if r.URL != "" {
+			return fmt.Errorf("please don't use url and urls params together")
+		}
+		r.URL = r.URLs[0]
+	}
+	return nil
+}
+
+// Upstreams returns the upstream base URLs in the order they are tried.
+func (r Repo) Upstreams() []string {
+	if len(r.URLs) > 0 {
+		return r.URLs
+	}
+	if r.URL == "" {
+		return nil
+	}
+	return []string{r.URL}
+}
+
+func (r Repo) IsHosted() bool {
+	return r.Mode == RepoModeHosted
+}
+
+// IsDir reports whether the repo serves files from a local directory
+// instead of an upstream.
+func (r Repo) IsDir() bool {
+	return r.Dir != ""
+}
+
+func (r Repo) Validate() error {
+	switch r.Mode {
+	case "", RepoModeProxy:
+		if r.URL != "" && r.Dir != "" {
+			return fmt.Errorf("please don't use url and dir params together")
+		}
+		if r.URL == "" && r.Dir == "" {
+			return fmt.Errorf("please use url or dir param")
+		}
+		if r.Dir != "" && r.MaxSize != 0 {
+			return fmt.Errorf("max_size can't be used with dir param")
+		}
+	case RepoModeHosted:
+		if r.URL != "" || r.Dir != "" {
+			return fmt.Errorf("url and dir params can't be used in %s mode", RepoModeHosted)
+		}
+		if r.MaxSize != 0 {
+			return fmt.Errorf("max_size can't be used in %s mode", RepoModeHosted)
+		}
+	default:
+		return fmt.Errorf("unknown mode %q", r.Mode)
+	}
+	if err := r.Client.Validate(); err != nil {
+		return err
+	}
+	if err := r.Auth.Validate(); err != nil {
+		return err
+	}
+	if err := r.SumDB.Validate(); err != nil {
+		return err
+	}
+	if len(r.UploadTokens) > 0 && !r.IsHosted() {
+		return fmt.Errorf("upload_tokens can only be used in %s mode", RepoModeHosted)
+	}
+	if r.Mirror.Enabled() && (r.Dir != "" || r.IsHosted()) {
+		return fmt.Errorf("mirror can only be used with url param")
+	}
+	if err := r.Mirror.Validate(); err != nil {
+		return err
+	}
+	return r.Policy.Validate()
+}
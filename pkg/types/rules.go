@@ -0,0 +1,67 @@
+package types
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// RoutingRule restricts the packages matching Match to the listed repos.
+type RoutingRule struct {
+	Match string   `yaml:"match"`
+	Repos []string `yaml:"repos"`
+}
+
+// RoutingRules is an ordered rule list of one ecosystem. The first rule that
+// matches a package name decides which repos may serve it; names that match
+// no rule can be served by any repo.
+type RoutingRules []RoutingRule
+
+// Allowed reports whether repo may serve name. When it may not, the rule
+// that denied the request is returned.
+func (rules RoutingRules) Allowed(repo, name string) (RoutingRule, bool) {
+	for _, rule := range rules {
+		if !rule.Matches(name) {
+			continue
+		}
+		for _, r := range rule.Repos {
+			if r == repo {
+				return rule, true
+			}
+		}
+		return rule, false
+	}
+	return RoutingRule{}, true
+}
+
+// Matches reports whether the pattern matches name or one of its parent
+// paths, so `github.com/corp` covers every module below it.
+func (rule RoutingRule) Matches(name string) bool {
+	for {
+		if ok, err := path.Match(rule.Match, name); err == nil && ok {
+			return true
+		}
+		idx := strings.LastIndex(name, "/")
+		if idx <= 0 {
+			return false
+		}
+		name = name[:idx]
+	}
+}
+
+func (rules RoutingRules) Validate(repos map[string]bool) error {
+	for _, rule := range rules {
+		if _, err := path.Match(rule.Match, ""); err != nil {
+			return fmt.Errorf("bad pattern %q: %v", rule.Match, err)
+		}
+		if len(rule.Repos) == 0 {
+			return fmt.Errorf("rule %q has no repos", rule.Match)
+		}
+		for _, r := range rule.Repos {
+			if !repos[r] {
+				return fmt.Errorf("rule %q refers to undefined repo %q", rule.Match, r)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package types
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a cron expression with minute, hour, day of month, month and
+// day of week fields, like 30 2 * * 1-5, in the local time of the server.
+// The descriptors @hourly, @daily, @weekly, @monthly, @yearly and
+// @every <duration> are accepted as well.
+type Schedule struct {
+	every                         time.Duration
+	minute, hour, dom, month, dow uint64
+	domRestricted, dowRestricted  bool
+}
+
+var scheduleDescriptors = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+func ParseSchedule(s string) (Schedule, error) {
+	s = strings.TrimSpace(s)
+	if d, ok := strings.CutPrefix(s, "@every "); ok {
+		every, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil || every < time.Minute {
+			return Schedule{}, fmt.Errorf("invalid schedule %q, @every needs a duration of at least 1m", s)
+		}
+		return Schedule{every: every}, nil
+	}
+	if expr, ok := scheduleDescriptors[s]; ok {
+		s = expr
+	}
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return Schedule{}, fmt.Errorf("invalid schedule %q, expected 5 fields", s)
+	}
+	var sched Schedule
+	var err error
+	for i, f := range []struct {
+		bits     *uint64
+		min, max int
+	}{{&sched.minute, 0, 59}, {&sched.hour, 0, 23}, {&sched.dom, 1, 31}, {&sched.month, 1, 12}, {&sched.dow, 0, 7}} {
+		if *f.bits, err = parseScheduleField(fields[i], f.min, f.max); err != nil {
+			return Schedule{}, fmt.Errorf("invalid schedule %q: %w", s, err)
+		}
+	}
+	// 7 is another name for Sunday.
+	if sched.dow&(1<<7) != 0 {
+		sched.dow |= 1
+	}
+	sched.domRestricted = fields[2] != "*"
+	sched.dowRestricted = fields[4] != "*"
+	return sched, nil
+}
+
+// parseScheduleField reads a comma separated list of *, values and ranges
+// with optional steps, like 1-5 or */15.
+func parseScheduleField(field string, minValue, maxValue int) (uint64, error) {
+	var bits uint64
+	for part := range strings.SplitSeq(field, ",") {
+		rng, stepValue, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepValue)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			step = n
+		}
+		first, last := minValue, maxValue
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+			var err error
+			if first, err = strconv.Atoi(from); err != nil {
+				return 0, fmt.Errorf("invalid value in %q", part)
+			}
+			last = first
+			if isRange {
+				if last, err = strconv.Atoi(to); err != nil {
+					return 0, fmt.Errorf("invalid value in %q", part)
+				}
+			} else if hasStep {
+				last = maxValue
+			}
+		}
+		if first < minValue || last > maxValue || first > last {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, minValue, maxValue)
+		}
+		for v := first; v <= last; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// Next returns the first time after t the schedule fires.
+func (s Schedule) Next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Add(s.every)
+	}
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// Impossible dates like February 30 never fire.
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		switch {
+		case s.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches follows cron, a day matches either field when both the day
+// of month and the day of week are restricted.
+func (s Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<int(t.Weekday())) != 0
+	if s.domRestricted && s.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
@@ -0,0 +1,24 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// StaticHostedFile describes a file uploaded into a hosted static repo.
+type StaticHostedFile struct {
+	Path        string    `json:"path"`
+	Size        int64     `json:"size"`
+	Sha256      string    `json:"sha256"`
+	ContentType string    `json:"content_type"`
+	UploadedAt  time.Time `json:"uploaded_at"`
+}
+
+func (f *StaticHostedFile) ReadFromJSON(fileContent []byte) error {
+	err := json.Unmarshal(fileContent, f)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling JSON: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package types
+
+// StorageConfig selects where cached objects are kept. The default "fs"
+// type stores them below the top-level dir.
+type StorageConfig struct {
+	Type string   `yaml:"type"`
+	S3   S3Config `yaml:"s3"`
+}
+
+// S3Config points to an S3-compatible bucket such as AWS S3 or MinIO. When
+// access_key or secret_key are empty, AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY are used.
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	Prefix    string `yaml:"prefix"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	PathStyle bool   `yaml:"path_style"`
+	SpoolDir  string `yaml:"spool_dir"`
+}
@@ -0,0 +1,75 @@
+package types
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	// DefaultSumDBURL is the checksum database used when a repo doesn't set
+	// one.
+	DefaultSumDBURL = "https://sum.golang.org"
+	// DefaultSumDBKey is the verifier key of DefaultSumDBURL, as listed in
+	// the documentation of GOSUMDB.
+	DefaultSumDBKey = "sum.golang.org+033de0ae+Ac4zctda0e5eza+HJyk9SxEdh+s3Ux18htTTAD8OuAn8"
+)
+
+// SumDBConfig sets the checksum database goproxy repos check module zips
+// and go.mod files against.
+type SumDBConfig struct {
+	// URL is https://sum.golang.org by default. A local stand-in has to
+	// answer /lookup/ and /tile/ requests.
+	URL string `yaml:"url"`
+	// Key is the verifier key the answers of the database must be signed
+	// with, in the form of GOSUMDB keys. It is only optional for the
+	// default database.
+	Key      string `yaml:"key"`
+	Disabled bool   `yaml:"disabled"`
+	// Private lists module path patterns, like GONOSUMDB, that are not
+	// looked up. When unset, GONOSUMDB or else GOPRIVATE of the environment
+	// is used, like the go command does.
+	Private []string `yaml:"private"`
+}
+
+func (s SumDBConfig) Validate() error {
+	if s.URL != "" {
+		if u, err := url.Parse(s.URL); err != nil || u.Host == "" {
+			return fmt.Errorf("invalid sumdb url %q", s.URL)
+		}
+	}
+	if !s.Disabled && s.VerifierKey() == "" {
+		return fmt.Errorf("sumdb url %q needs a key", s.URL)
+	}
+	return nil
+}
+
+// BaseURL returns the URL of the checksum database.
+func (s SumDBConfig) BaseURL() string {
+	if s.URL == "" {
+		return DefaultSumDBURL
+	}
+	return strings.TrimSuffix(s.URL, "/")
+}
+
+// VerifierKey returns the key the database signs with.
+func (s SumDBConfig) VerifierKey() string {
+	if s.Key == "" && s.BaseURL() == DefaultSumDBURL {
+		return DefaultSumDBKey
+	}
+	return s.Key
+}
+
+// PrivatePatterns returns the module path patterns that are not looked up.
+func (s SumDBConfig) PrivatePatterns() []string {
+	if s.Private != nil {
+		return s.Private
+	}
+	for _, env := range []string{"GONOSUMDB", "GOPRIVATE"} {
+		if value := os.Getenv(env); value != "" {
+			return strings.Split(value, ",")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package warm
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/psvmcc/hub/pkg/types"
+
+	"gopkg.in/yaml.v3"
+)
+
+var galaxyExactVersion = regexp.MustCompile(`^=*\s*(\d+\.\d+\.\d+[0-9A-Za-z.+-]*)$`)
+
+type galaxyRequirement struct {
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+	Type    string `yaml:"type"`
+}
+
+// UnmarshalYAML accepts the short form of a requirement, just its name.
+func (r *galaxyRequirement) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		r.Name = value.Value
+		return nil
+	}
+	type plain galaxyRequirement
+	return value.Decode((*plain)(r))
+}
+
+// parseGalaxyRequirements reads the collections of an ansible-galaxy
+// requirements.yml. Collections without a version get their highest
+// version. Roles and collections from git, URLs or local paths are not
+// served by Galaxy repos.
+func parseGalaxyRequirements(data []byte, repo string) (targets []target, skipped []string, err error) {
+	var requirements struct {
+		Collections []galaxyRequirement `yaml:"collections"`
+		Roles       []galaxyRequirement `yaml:"roles"`
+	}
+	if err := yaml.Unmarshal(data, &requirements); err != nil {
+		// The old format is a plain list of roles.
+		var roles []galaxyRequirement
+		if yaml.Unmarshal(data, &roles) != nil {
+			return nil, nil, err
+		}
+		requirements.Roles = roles
+	}
+	for _, role := range requirements.Roles {
+		skipped = append(skipped, fmt.Sprintf("galaxy role %s: roles are not supported", role.Name))
+	}
+
+	for _, r := range requirements.Collections {
+		if r.Type != "" && r.Type != "galaxy" {
+			skipped = append(skipped, fmt.Sprintf("galaxy %s: %s collections are not supported", r.Name, r.Type))
+			continue
+		}
+		namespace, name, ok := strings.Cut(r.Name, ".")
+		if !ok || strings.ContainsAny(r.Name, "/:") {
+			skipped = append(skipped, fmt.Sprintf("galaxy %s: not a namespace.name collection", r.Name))
+			continue
+		}
+		version := strings.TrimSpace(r.Version)
+		if m := galaxyExactVersion.FindStringSubmatch(version); m != nil {
+			targets = append(targets, galaxyTarget(repo, namespace, name, m[1]))
+			continue
+		}
+		if version != "" && version != "*" {
+			skipped = append(skipped, fmt.Sprintf("galaxy %s: version range %q, pin the version to warm it", r.Name, version))
+			continue
+		}
+		targets = append(targets, target{
+			name: fmt.Sprintf("galaxy %s.%s", namespace, name),
+			pkg:  Package{Ecosystem: "galaxy", Name: r.Name},
+			resolve: func(c *client) ([]string, error) {
+				var collection types.GalaxyCollection
+				if err := c.getJSON(fmt.Sprintf("%s/api/v3/collections/%s/%s/", repo, namespace, name), nil, &collection); err != nil {
+					return nil, err
+				}
+				if collection.HighestVersion.Version == "" {
+					return nil, fmt.Errorf("no highest version")
+				}
+				return galaxyTarget(repo, namespace, name, collection.HighestVersion.Version).paths, nil
+			},
+		})
+	}
+	return targets, skipped, nil
+}
+
+// galaxyTarget fetches a collection version along with the API answers
+// ansible-galaxy asks for before downloading it.
+func galaxyTarget(repo, namespace, name, version string) target {
+	api := fmt.Sprintf("%s/api/v3/collections/%s/%s/", repo, namespace, name)
+	return target{
+		name: fmt.Sprintf("galaxy %s.%s:%s", namespace, name, version),
+		pkg:  Package{Ecosystem: "galaxy", Name: namespace + "." + name, Version: version},
+		paths: []string{
+			api,
+			api + "versions/",
+			fmt.Sprintf("%sversions/%s/", api, version),
+			fmt.Sprintf("%s/get/%s/%s/%s", repo, namespace, name, version),
+		},
+	}
+}
@@ -0,0 +1,67 @@
+package warm
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/psvmcc/hub/pkg/misc"
+	"github.com/psvmcc/hub/pkg/types"
+)
+
+// parseGoSum reads a go.sum. Versions with a module hash get their .info
+// and .zip, go.mod hashes their .mod, which is what go mod download
+// fetches.
+func parseGoSum(data []byte, repo string) (targets []target, skipped []string, err error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			skipped = append(skipped, fmt.Sprintf("goproxy %q: not a go.sum line", scanner.Text()))
+			continue
+		}
+		modulePath, version := fields[0], fields[1]
+		base := fmt.Sprintf("%s/%s/@v/", repo, types.GoproxyEscape(modulePath))
+		if v, ok := strings.CutSuffix(version, "/go.mod"); ok {
+			targets = append(targets, target{
+				name:  fmt.Sprintf("goproxy %s@%s/go.mod", modulePath, v),
+				pkg:   Package{Ecosystem: "goproxy", Name: modulePath, Version: v},
+				paths: []string{base + types.GoproxyEscape(v) + ".mod"},
+			})
+			continue
+		}
+		targets = append(targets, target{
+			name:  fmt.Sprintf("goproxy %s@%s", modulePath, version),
+			pkg:   Package{Ecosystem: "goproxy", Name: modulePath, Version: version},
+			paths: []string{base + types.GoproxyEscape(version) + ".info", base + types.GoproxyEscape(version) + ".zip"},
+		})
+	}
+	return targets, skipped, scanner.Err()
+}
+
+// goproxyMirror returns the .info, .mod and .zip of the versions of a
+// module p selects.
+func goproxyMirror(c *client, repo, module string, p types.MirrorPackage) ([]string, error) {
+	base := fmt.Sprintf("%s/%s/@v/", repo, types.GoproxyEscape(module))
+	response, err := c.get(base+"list", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, version := range pickVersions(p, strings.Fields(string(data)), misc.IsPrerelease) {
+		for _, ext := range []string{".info", ".mod", ".zip"} {
+			paths = append(paths, base+types.GoproxyEscape(version)+ext)
+		}
+	}
+	return paths, nil
+}
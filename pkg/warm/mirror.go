@@ -0,0 +1,278 @@
+package warm
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/psvmcc/hub/pkg/index"
+	"github.com/psvmcc/hub/pkg/misc"
+	"github.com/psvmcc/hub/pkg/types"
+
+	"github.com/VictoriaMetrics/metrics"
+	"go.uber.org/zap"
+)
+
+// MirrorStatus reports the runs of a mirror.
+type MirrorStatus struct {
+	Repo     string `json:"repo"`
+	Schedule string `json:"schedule"`
+	Running  bool   `json:"running"`
+	// Done and Total count the artifacts of the current or last run.
+	Done        int       `json:"done"`
+	Total       int       `json:"total"`
+	NextRun     time.Time `json:"next_run,omitzero"`
+	LastStart   time.Time `json:"last_start,omitzero"`
+	LastEnd     time.Time `json:"last_end,omitzero"`
+	LastSuccess time.Time `json:"last_success,omitzero"`
+	// LastStatus is success or failed, empty before the first run ended.
+	LastStatus string `json:"last_status,omitempty"`
+	// Fetched counts the artifacts of the run by their X-Cache-Status.
+	Fetched map[string]int `json:"fetched,omitempty"`
+	Errors  []string       `json:"errors,omitempty"`
+}
+
+// mirror fetches the selected packages of a repo on every run of its
+// schedule.
+type mirror struct {
+	ecosystem string
+	key       string
+	cfg       types.MirrorConfig
+	schedule  types.Schedule
+	client    *client
+	logger    *zap.SugaredLogger
+
+	mu     sync.Mutex
+	status MirrorStatus
+}
+
+var mirrors = struct {
+	sync.Mutex
+	list []*mirror
+}{}
+
+// StartMirror mirrors the packages cfg selects from the repo key of
+// ecosystem. Packages are fetched through the server at server, just like
+// clients fetch them, so they are cached, verified and routed the same way.
+func StartMirror(server, ecosystem, key string, cfg types.MirrorConfig, logger *zap.SugaredLogger) error {
+	schedule, err := types.ParseSchedule(cfg.Schedule)
+	if err != nil {
+		return err
+	}
+	m := &mirror{
+		ecosystem: ecosystem,
+		key:       key,
+		cfg:       cfg,
+		schedule:  schedule,
+		client:    newClient(server, "hub-mirror", DefaultTimeout),
+		logger:    logger,
+		status:    MirrorStatus{Repo: fmt.Sprintf("%s/%s", ecosystem, key), Schedule: cfg.Schedule},
+	}
+	repo := m.status.Repo
+	metrics.GetOrCreateGauge(fmt.Sprintf("hub_mirror_running{repo=%q}", repo), func() float64 {
+		if m.snapshot().Running {
+			return 1
+		}
+		return 0
+	})
+	metrics.GetOrCreateGauge(fmt.Sprintf("hub_mirror_progress_ratio{repo=%q}", repo), func() float64 {
+		s := m.snapshot()
+		if s.Total == 0 {
+			return 0
+		}
+		return float64(s.Done) / float64(s.Total)
+	})
+	metrics.GetOrCreateGauge(fmt.Sprintf("hub_mirror_last_run_timestamp_seconds{repo=%q}", repo), func() float64 {
+		return unixSeconds(m.snapshot().LastEnd)
+	})
+	metrics.GetOrCreateGauge(fmt.Sprintf("hub_mirror_last_success_timestamp_seconds{repo=%q}", repo), func() float64 {
+		return unixSeconds(m.snapshot().LastSuccess)
+	})
+
+	mirrors.Lock()
+	mirrors.list = append(mirrors.list, m)
+	mirrors.Unlock()
+	go m.loop()
+	return nil
+}
+
+func unixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.Unix())
+}
+
+// MirrorStatuses returns the status of every mirror, sorted by repo.
+func MirrorStatuses() []MirrorStatus {
+	mirrors.Lock()
+	statuses := make([]MirrorStatus, 0, len(mirrors.list))
+	for _, m := range mirrors.list {
+		statuses = append(statuses, m.snapshot())
+	}
+	mirrors.Unlock()
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Repo < statuses[j].Repo })
+	return statuses
+}
+
+func (m *mirror) snapshot() MirrorStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.status
+	s.Fetched = make(map[string]int, len(m.status.Fetched))
+	for status, n := range m.status.Fetched {
+		s.Fetched[status] = n
+	}
+	s.Errors = append([]string(nil), m.status.Errors...)
+	return s
+}
+
+func (m *mirror) update(fn func(s *MirrorStatus)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fn(&m.status)
+}
+
+func (m *mirror) loop() {
+	for {
+		next := m.schedule.Next(time.Now())
+		if next.IsZero() {
+			m.logger.Errorf("[Mirror] %s: schedule %q never fires", m.status.Repo, m.cfg.Schedule)
+			return
+		}
+		m.update(func(s *MirrorStatus) { s.NextRun = next })
+		time.Sleep(time.Until(next))
+		m.run()
+	}
+}
+
+// run fetches every artifact of the selected package versions.
+func (m *mirror) run() {
+	repo := m.status.Repo
+	start := time.Now()
+	m.update(func(s *MirrorStatus) {
+		s.Running, s.LastStart, s.Done, s.Total = true, start, 0, 0
+		s.Fetched, s.Errors = map[string]int{}, nil
+	})
+	m.logger.Infof("[Mirror] %s: run started", repo)
+
+	base := "/" + repo
+	paths := []string{}
+	seen := map[string]bool{}
+	for _, p := range m.cfg.Packages {
+		names := []string{p.Name}
+		if p.IsGlob() {
+			names = m.cachedPackages(p.Name)
+		}
+		for _, name := range names {
+			found, err := m.resolve(base, name, p)
+			if err != nil {
+				m.fail(fmt.Sprintf("%s: %s", name, err))
+				continue
+			}
+			for _, artifact := range found {
+				if !seen[artifact] {
+					seen[artifact] = true
+					paths = append(paths, artifact)
+				}
+			}
+		}
+	}
+	m.update(func(s *MirrorStatus) { s.Total = len(paths) })
+
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for range m.cfg.Workers() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range work {
+				status, err := m.client.fetch(p)
+				if err != nil {
+					m.fail(fmt.Sprintf("%s: %s", p, err))
+				} else {
+					metrics.GetOrCreateCounter(fmt.Sprintf("hub_mirror_artifacts_total{repo=%q,cache_status=%q}", repo, status)).Inc()
+					m.update(func(s *MirrorStatus) { s.Fetched[status]++ })
+				}
+				m.update(func(s *MirrorStatus) { s.Done++ })
+			}
+		}()
+	}
+	for _, p := range paths {
+		work <- p
+	}
+	close(work)
+	wg.Wait()
+
+	end := time.Now()
+	result := "success"
+	m.update(func(s *MirrorStatus) {
+		if len(s.Errors) > 0 {
+			result = "failed"
+		} else {
+			s.LastSuccess = end
+		}
+		s.Running, s.LastEnd, s.LastStatus = false, end, result
+	})
+	metrics.GetOrCreateCounter(fmt.Sprintf("hub_mirror_runs_total{repo=%q,status=%q}", repo, result)).Inc()
+	s := m.snapshot()
+	m.logger.Infof("[Mirror] %s: run %s in %s, %d artifacts fetched, %d errors", repo, result, end.Sub(start).Round(time.Millisecond), Report{Fetched: s.Fetched}.Total(), len(s.Errors))
+}
+
+func (m *mirror) fail(message string) {
+	m.logger.Errorf("[Mirror] %s: %s", m.status.Repo, message)
+	metrics.GetOrCreateCounter(fmt.Sprintf("hub_mirror_errors_total{repo=%q}", m.status.Repo)).Inc()
+	m.update(func(s *MirrorStatus) { s.Errors = append(s.Errors, message) })
+}
+
+// resolve returns the artifacts of the versions of the package name that
+// p selects, below the repo path base.
+func (m *mirror) resolve(base, name string, p types.MirrorPackage) ([]string, error) {
+	switch m.ecosystem {
+	case "pypi":
+		return pypiMirror(m.client, base, name, p)
+	case "npm":
+		return npmMirror(m.client, base, name, p)
+	case "goproxy":
+		return goproxyMirror(m.client, base, name, p)
+	}
+	return nil, fmt.Errorf("%s repos can't be mirrored", m.ecosystem)
+}
+
+// cachedPackages returns the names of the packages cached in the repo that
+// match glob.
+func (m *mirror) cachedPackages(glob string) []string {
+	prefix := m.status.Repo + "/"
+	names := map[string]bool{}
+	for _, e := range index.List(prefix) {
+		p, ok := types.ParseCacheKey(e.Key)
+		if !ok {
+			continue
+		}
+		if match, _ := path.Match(glob, p.Name); match {
+			names[p.Name] = true
+		}
+	}
+	return sortedKeys(names)
+}
+
+// pickVersions returns the versions p selects, newest first.
+func pickVersions(p types.MirrorPackage, versions []string, prerelease func(string) bool) []string {
+	constraint, _ := types.ParseVersionConstraint(p.Versions)
+	picked := []string{}
+	for _, v := range versions {
+		if !p.Prereleases && prerelease(v) {
+			continue
+		}
+		if constraint.Allows(v, misc.CompareVersions) {
+			picked = append(picked, v)
+		}
+	}
+	sort.Slice(picked, func(i, j int) bool { return misc.CompareVersions(picked[i], picked[j]) > 0 })
+	if p.Latest > 0 && len(picked) > p.Latest {
+		picked = picked[:p.Latest]
+	}
+	return picked
+}
@@ -0,0 +1,190 @@
+package warm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/psvmcc/hub/pkg/misc"
+	"github.com/psvmcc/hub/pkg/types"
+
+	"gopkg.in/yaml.v3"
+)
+
+type npmLockPackage struct {
+	Name         string                    `json:"name"`
+	Version      string                    `json:"version"`
+	Resolved     string                    `json:"resolved"`
+	Link         bool                      `json:"link"`
+	InBundle     bool                      `json:"inBundle"`
+	Bundled      bool                      `json:"bundled"`
+	Dependencies map[string]npmLockPackage `json:"dependencies"`
+}
+
+// parsePackageLock reads a package-lock.json or npm-shrinkwrap.json. The
+// packages list of lockfile version 2 and 3 is used, the dependencies tree
+// of version 1 otherwise.
+func parsePackageLock(data []byte, repo string) (targets []target, skipped []string, err error) {
+	var lock struct {
+		Packages     map[string]json.RawMessage `json:"packages"`
+		Dependencies map[string]npmLockPackage  `json:"dependencies"`
+	}
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, nil, err
+	}
+
+	add := func(name string, p npmLockPackage) {
+		if p.Link || p.InBundle || p.Bundled {
+			return
+		}
+		t, reason := npmTarget(repo, name, p.Version, p.Resolved)
+		if reason != "" {
+			skipped = append(skipped, fmt.Sprintf("npm %s@%s: %s", name, p.Version, reason))
+			return
+		}
+		targets = append(targets, t)
+	}
+
+	if len(lock.Packages) > 0 {
+		for _, key := range sortedKeys(lock.Packages) {
+			i := strings.LastIndex(key, "node_modules/")
+			if i < 0 {
+				// The root project or a workspace.
+				continue
+			}
+			var p npmLockPackage
+			if err := json.Unmarshal(lock.Packages[key], &p); err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", key, err)
+			}
+			name := key[i+len("node_modules/"):]
+			if p.Name != "" {
+				// Aliased installs like "foo": "npm:bar@1" keep the real name.
+				name = p.Name
+			}
+			add(name, p)
+		}
+		return targets, skipped, nil
+	}
+
+	var walk func(deps map[string]npmLockPackage)
+	walk = func(deps map[string]npmLockPackage) {
+		for _, name := range sortedKeys(deps) {
+			add(name, deps[name])
+			walk(deps[name].Dependencies)
+		}
+	}
+	walk(lock.Dependencies)
+	return targets, skipped, nil
+}
+
+// parsePnpmLock reads a pnpm-lock.yaml. Package keys look like
+// /name/1.0.0 in lockfile version 5, /name@1.0.0(peer@2) in version 6 and
+// name@1.0.0 in version 9.
+func parsePnpmLock(data []byte, repo string) (targets []target, skipped []string, err error) {
+	var lock struct {
+		Packages map[string]struct {
+			Name       string `yaml:"name"`
+			Version    string `yaml:"version"`
+			Resolution struct {
+				Tarball string `yaml:"tarball"`
+			} `yaml:"resolution"`
+		} `yaml:"packages"`
+	}
+	if err := yaml.Unmarshal(data, &lock); err != nil {
+		return nil, nil, err
+	}
+	for _, key := range sortedKeys(lock.Packages) {
+		p := lock.Packages[key]
+		name, version := pnpmPackageKey(key)
+		if p.Name != "" {
+			name = p.Name
+		}
+		if p.Version != "" {
+			version = p.Version
+		}
+		t, reason := npmTarget(repo, name, version, p.Resolution.Tarball)
+		if reason != "" {
+			skipped = append(skipped, fmt.Sprintf("npm %s: %s", key, reason))
+			continue
+		}
+		targets = append(targets, t)
+	}
+	return targets, skipped, nil
+}
+
+func pnpmPackageKey(key string) (name, version string) {
+	key = strings.TrimPrefix(key, "/")
+	if i := strings.Index(key, "("); i >= 0 {
+		key = key[:i]
+	}
+	if i := strings.LastIndex(key, "@"); i > 0 {
+		return key[:i], key[i+1:]
+	}
+	// Version 5 separates the version with a slash and appends peer
+	// dependencies after an underscore.
+	i := strings.LastIndex(key, "/")
+	if i < 0 {
+		return key, ""
+	}
+	version, _, _ = strings.Cut(key[i+1:], "_")
+	return key[:i], version
+}
+
+// npmTarget returns the packument and tarball of a locked package.
+// Packages resolved from git, local paths or other hosts than a registry
+// can't be fetched through a repo and return the reason.
+func npmTarget(repo, name, version, resolved string) (target, string) {
+	if name == "" || version == "" {
+		return target{}, "no name or version"
+	}
+	filename := fmt.Sprintf("%s-%s.tgz", path.Base(name), version)
+	if resolved != "" {
+		u, err := url.Parse(resolved)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || !strings.Contains(u.Path, "/-/") {
+			return target{}, fmt.Sprintf("not resolved from a registry (%s)", resolved)
+		}
+		filename = path.Base(u.Path)
+	}
+	return target{
+		name:  fmt.Sprintf("npm %s@%s", name, version),
+		pkg:   Package{Ecosystem: "npm", Name: name, Version: version},
+		paths: []string{fmt.Sprintf("%s/%s", repo, name), fmt.Sprintf("%s/%s/-/%s", repo, name, filename)},
+	}, ""
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// npmMirror returns the tarballs of the versions of a package p selects.
+func npmMirror(c *client, repo, name string, p types.MirrorPackage) ([]string, error) {
+	var packument struct {
+		Versions map[string]struct {
+			Dist struct {
+				Tarball string `json:"tarball"`
+			} `json:"dist"`
+		} `json:"versions"`
+	}
+	header := http.Header{"Accept": {"application/vnd.npm.install-v1+json"}}
+	if err := c.getJSON(fmt.Sprintf("%s/%s", repo, name), header, &packument); err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, version := range pickVersions(p, sortedKeys(packument.Versions), misc.IsPrerelease) {
+		t, reason := npmTarget(repo, name, version, packument.Versions[version].Dist.Tarball)
+		if reason != "" {
+			return nil, fmt.Errorf("%s: %s", version, reason)
+		}
+		paths = append(paths, t.paths[1])
+	}
+	return paths, nil
+}
@@ -0,0 +1,222 @@
+package warm
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/psvmcc/hub/pkg/types"
+)
+
+var (
+	pinnedRequirement = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9._-]*)\s*(\[[^\]]*\])?\s*===?\s*([^\s;,]+)$`)
+	requirementHash   = regexp.MustCompile(`--hash[=\s]+sha256:([0-9a-fA-F]{64})`)
+	pylockHash        = regexp.MustCompile(`sha256\s*=\s*"([0-9a-fA-F]{64})"`)
+	pylockString      = regexp.MustCompile(`^(name|version)\s*=\s*"([^"]*)"`)
+	pypiPrerelease    = regexp.MustCompile(`(?i)\d(a|b|c|rc|alpha|beta|pre|preview)\d*|dev`)
+)
+
+// parseRequirements reads a pip requirements file. Only pinned
+// requirements can be warmed. With --hash options just the files with
+// those hashes are fetched, otherwise every file of the release.
+func parseRequirements(data []byte, repo string) (targets []target, skipped []string, err error) {
+	var lines []string
+	var current strings.Builder
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
+		}
+		if strings.HasSuffix(line, "\\") {
+			current.WriteString(strings.TrimSuffix(line, "\\") + " ")
+			continue
+		}
+		current.WriteString(line)
+		lines = append(lines, strings.TrimSpace(current.String()))
+		current.Reset()
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	lines = append(lines, strings.TrimSpace(current.String()))
+
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "-") {
+			// Index options don't name packages, nested and editable
+			// requirements are not followed.
+			for _, option := range []string{"-r", "--requirement", "-c", "--constraint", "-e", "--editable"} {
+				if line == option || strings.HasPrefix(line, option+" ") || strings.HasPrefix(line, option+"=") {
+					skipped = append(skipped, fmt.Sprintf("pypi %q: not followed", line))
+				}
+			}
+			continue
+		}
+		hashes := map[string]bool{}
+		for _, m := range requirementHash.FindAllStringSubmatch(line, -1) {
+			hashes[strings.ToLower(m[1])] = true
+		}
+		spec := line
+		if i := strings.Index(spec, " --"); i >= 0 {
+			spec = spec[:i]
+		}
+		spec, _, _ = strings.Cut(spec, ";")
+		m := pinnedRequirement.FindStringSubmatch(strings.TrimSpace(spec))
+		if m == nil {
+			skipped = append(skipped, fmt.Sprintf("pypi %q: not pinned with ==", strings.TrimSpace(spec)))
+			continue
+		}
+		targets = append(targets, pypiTarget(repo, m[1], m[3], hashes))
+	}
+	return targets, skipped, nil
+}
+
+// parsePylock reads a PEP 751 pylock.toml. The file is scanned line by
+// line for the name and version of every [[packages]] table and the sha256
+// hashes of its files, which is all a warm-up needs.
+func parsePylock(data []byte, repo string) (targets []target, skipped []string, err error) {
+	var name, version string
+	hashes := map[string]bool{}
+	inPackage := false
+	flush := func() {
+		if name == "" {
+			return
+		}
+		if version == "" {
+			skipped = append(skipped, fmt.Sprintf("pypi %s: no version", name))
+		} else {
+			targets = append(targets, pypiTarget(repo, name, version, hashes))
+		}
+		name, version, hashes = "", "", map[string]bool{}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			header := strings.Trim(line, "[] ")
+			if line == "[[packages]]" {
+				flush()
+				inPackage = true
+			} else if !strings.HasPrefix(header, "packages.") {
+				flush()
+				inPackage = false
+			}
+			continue
+		}
+		if !inPackage {
+			continue
+		}
+		for _, m := range pylockHash.FindAllStringSubmatch(line, -1) {
+			hashes[strings.ToLower(m[1])] = true
+		}
+		// Sub-tables like [[packages.wheels]] have their own names, only
+		// the keys of the package itself come before them.
+		if m := pylockString.FindStringSubmatch(line); m != nil && !strings.Contains(line, "{") {
+			switch {
+			case m[1] == "name" && name == "":
+				name = m[2]
+			case m[1] == "version" && version == "":
+				version = m[2]
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	flush()
+	return targets, skipped, nil
+}
+
+// pypiTarget resolves the files of a release from the simple index of the
+// server.
+func pypiTarget(repo, name, version string, hashes map[string]bool) target {
+	project := types.PypiNormalizeName(name)
+	return target{
+		name: fmt.Sprintf("pypi %s==%s", project, version),
+		pkg:  Package{Ecosystem: "pypi", Name: project, Version: version},
+		resolve: func(c *client) ([]string, error) {
+			var metadata types.PypiMetadata
+			header := http.Header{"Accept": {"application/vnd.pypi.simple.v1+json"}}
+			if err := c.getJSON(fmt.Sprintf("%s/simple/%s/", repo, project), header, &metadata); err != nil {
+				return nil, err
+			}
+			paths := []string{}
+			for _, f := range metadata.Files {
+				if len(hashes) > 0 && !hashes[strings.ToLower(f.Hashes.Sha256)] {
+					continue
+				}
+				if len(hashes) == 0 && !strings.EqualFold(pypiFileVersion(f.Filename), version) {
+					continue
+				}
+				u, err := url.Parse(f.URL)
+				if err != nil {
+					return nil, err
+				}
+				paths = append(paths, u.Path)
+			}
+			if len(paths) == 0 {
+				return nil, fmt.Errorf("no files of version %s in the index", version)
+			}
+			return paths, nil
+		},
+	}
+}
+
+// pypiFileVersion returns the version part of a wheel or sdist file name.
+func pypiFileVersion(filename string) string {
+	if strings.HasSuffix(filename, ".whl") {
+		if parts := strings.Split(filename, "-"); len(parts) >= 5 {
+			return parts[1]
+		}
+		return ""
+	}
+	for _, ext := range []string{".tar.gz", ".tar.bz2", ".tgz", ".zip", ".tar"} {
+		if strings.HasSuffix(filename, ext) {
+			base := strings.TrimSuffix(path.Base(filename), ext)
+			if i := strings.LastIndex(base, "-"); i >= 0 {
+				return base[i+1:]
+			}
+		}
+	}
+	return ""
+}
+
+// pypiMirror returns the files of the versions of a project p selects.
+// Yanked files are left out.
+func pypiMirror(c *client, repo, name string, p types.MirrorPackage) ([]string, error) {
+	var metadata types.PypiMetadata
+	header := http.Header{"Accept": {"application/vnd.pypi.simple.v1+json"}}
+	if err := c.getJSON(fmt.Sprintf("%s/simple/%s/", repo, types.PypiNormalizeName(name)), header, &metadata); err != nil {
+		return nil, err
+	}
+	files := map[string][]string{}
+	for _, f := range metadata.Files {
+		version := pypiFileVersion(f.Filename)
+		if version == "" || f.Yanked != nil && f.Yanked != false {
+			continue
+		}
+		u, err := url.Parse(f.URL)
+		if err != nil {
+			return nil, err
+		}
+		files[version] = append(files[version], u.Path)
+	}
+	paths := []string{}
+	for _, version := range pickVersions(p, sortedKeys(files), pypiPrerelease.MatchString) {
+		paths = append(paths, files[version]...)
+	}
+	return paths, nil
+}
@@ -0,0 +1,47 @@
+package warm
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var gemSpec = regexp.MustCompile(`^    ([^ (]+) \(([^)]+)\)$`)
+
+// parseGemfileLock reads a Gemfile.lock. The specs of GEM sections are
+// fetched along with the compact index entries bundler asks for, gems
+// from GIT and PATH sections can't be fetched through a repo.
+func parseGemfileLock(data []byte, repo string) (targets []target, skipped []string, err error) {
+	section := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line != "" && !strings.HasPrefix(line, " ") {
+			section = line
+			continue
+		}
+		m := gemSpec.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		name, version := m[1], m[2]
+		if section != "GEM" {
+			skipped = append(skipped, fmt.Sprintf("rubygems %s (%s): from a %s section", name, version, section))
+			continue
+		}
+		// Platform gems have the platform in the version, like
+		// 1.15.4-x86_64-linux, just as in their file name.
+		targets = append(targets, target{
+			name: fmt.Sprintf("rubygems %s (%s)", name, version),
+			pkg:  Package{Ecosystem: "rubygems", Name: name, Version: version},
+			paths: []string{
+				repo + "/versions",
+				fmt.Sprintf("%s/info/%s", repo, name),
+				fmt.Sprintf("%s/gems/%s-%s.gem", repo, name, version),
+			},
+		})
+	}
+	return targets, skipped, scanner.Err()
+}
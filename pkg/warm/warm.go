@@ -0,0 +1,293 @@
+// Package warm fills the cache of a running HUB by requesting artifacts
+// like a package manager would: every artifact that lockfiles reference,
+// or the selected packages of mirrored repos on a schedule.
+package warm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultTimeout limits each request to the server, including the body of
+// the artifact, unless Options.Timeout is set.
+const DefaultTimeout = 10 * time.Minute
+
+// Options configure a warm-up.
+type Options struct {
+	// Server is the base URL of the running HUB, like http://127.0.0.1:6587.
+	Server string
+	// Repos maps ecosystems like pypi or npm to the repo or group that
+	// artifacts are fetched through.
+	Repos       map[string]string
+	Concurrency int
+	Timeout     time.Duration
+	Logger      *zap.SugaredLogger
+}
+
+// target is a locked package. Its artifacts are the paths below the
+// server, resolve adds the ones that can only be found by asking the
+// server, like the files of a PyPI release.
+type target struct {
+	name    string
+	pkg     Package
+	paths   []string
+	resolve func(c *client) ([]string, error)
+}
+
+// Package is a locked package. Version is empty for packages locked
+// without one, like Galaxy collections that get their highest version.
+type Package struct {
+	Ecosystem string
+	Name      string
+	Version   string
+}
+
+// Report sums up a warm-up.
+type Report struct {
+	// Fetched counts the fetched artifacts by their X-Cache-Status.
+	Fetched map[string]int
+	Failed  []string
+	Skipped []string
+}
+
+// Total returns the number of fetched artifacts.
+func (r Report) Total() int {
+	total := 0
+	for _, n := range r.Fetched {
+		total += n
+	}
+	return total
+}
+
+func (r Report) String() string {
+	statuses := make([]string, 0, len(r.Fetched))
+	for status, n := range r.Fetched {
+		statuses = append(statuses, fmt.Sprintf("%s=%d", status, n))
+	}
+	sort.Strings(statuses)
+	fetched := fmt.Sprintf("%d artifacts fetched", r.Total())
+	if len(statuses) > 0 {
+		fetched += " (" + strings.Join(statuses, " ") + ")"
+	}
+	return fmt.Sprintf("%s, %d failed, %d skipped", fetched, len(r.Failed), len(r.Skipped))
+}
+
+// lockfile is a parser of one lockfile format. It returns the locked
+// packages with their artifacts below repo, and the entries it can't warm
+// with the reason.
+type lockfile struct {
+	ecosystem string
+	parse     func(data []byte, repo string) (targets []target, skipped []string, err error)
+}
+
+// detect returns the parser for a lockfile by its name. Plain text and
+// YAML files are only taken for requirements when they are named like
+// them, so other files given by mistake are not parsed as such.
+func detect(file string) (lockfile, error) {
+	base := filepath.Base(file)
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	requirements := strings.HasPrefix(stem, "requirements") || strings.HasSuffix(stem, "requirements")
+	switch {
+	case base == "pylock.toml" || strings.HasPrefix(base, "pylock.") && ext == ".toml":
+		return lockfile{"pypi", parsePylock}, nil
+	case ext == ".txt" && (requirements || strings.HasPrefix(stem, "constraints") || filepath.Base(filepath.Dir(file)) == "requirements"):
+		return lockfile{"pypi", parseRequirements}, nil
+	case base == "package-lock.json" || base == "npm-shrinkwrap.json":
+		return lockfile{"npm", parsePackageLock}, nil
+	case base == "pnpm-lock.yaml":
+		return lockfile{"npm", parsePnpmLock}, nil
+	case base == "go.sum":
+		return lockfile{"goproxy", parseGoSum}, nil
+	case base == "Gemfile.lock" || base == "gems.locked":
+		return lockfile{"rubygems", parseGemfileLock}, nil
+	case (ext == ".yml" || ext == ".yaml") && requirements:
+		return lockfile{"galaxy", parseGalaxyRequirements}, nil
+	}
+	return lockfile{}, fmt.Errorf("unknown lockfile format of %s", file)
+}
+
+// parse reads the targets of file, with their paths below the repo that
+// repo returns for the ecosystem of the lockfile.
+func parse(file string, repo func(ecosystem string) (string, error)) (targets []target, skipped []string, err error) {
+	lf, err := detect(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	base, err := repo(lf.ecosystem)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := os.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, nil, err
+	}
+	targets, skipped, err = lf.parse(data, base)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing %s: %w", file, err)
+	}
+	return targets, skipped, nil
+}
+
+// Packages returns the packages locked by files without fetching anything,
+// along with the entries that name no package that could be fetched.
+func Packages(files []string) (packages []Package, skipped []string, err error) {
+	for _, file := range files {
+		found, s, err := parse(file, func(ecosystem string) (string, error) { return "", nil })
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, t := range found {
+			packages = append(packages, t.pkg)
+		}
+		skipped = append(skipped, s...)
+	}
+	return packages, skipped, nil
+}
+
+// Run fetches the artifacts of every lockfile in files.
+func Run(opts Options, files []string) (Report, error) {
+	report := Report{Fetched: map[string]int{}}
+	targets := []target{}
+	for _, file := range files {
+		found, skipped, err := parse(file, func(ecosystem string) (string, error) {
+			repo := opts.Repos[ecosystem]
+			if repo == "" {
+				return "", fmt.Errorf("%s needs the %s repo to fetch through, set --%s", file, ecosystem, ecosystem)
+			}
+			return fmt.Sprintf("/%s/%s", ecosystem, repo), nil
+		})
+		if err != nil {
+			return report, err
+		}
+		opts.Logger.Infof("%s: %d packages, %d skipped", file, len(found), len(skipped))
+		targets = append(targets, found...)
+		report.Skipped = append(report.Skipped, skipped...)
+	}
+
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	c := newClient(opts.Server, "hub-warm", timeout)
+	concurrency := max(opts.Concurrency, 1)
+	work := make(chan target)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	seen := map[string]bool{}
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range work {
+				paths := t.paths
+				if t.resolve != nil {
+					resolved, err := t.resolve(c)
+					if err != nil {
+						opts.Logger.Errorf("[Warm] %s: %s", t.name, err)
+						mu.Lock()
+						report.Failed = append(report.Failed, t.name)
+						mu.Unlock()
+						continue
+					}
+					paths = append(paths, resolved...)
+				}
+				for _, p := range paths {
+					mu.Lock()
+					duplicate := seen[p]
+					seen[p] = true
+					mu.Unlock()
+					if duplicate {
+						continue
+					}
+					status, err := c.fetch(p)
+					mu.Lock()
+					if err != nil {
+						opts.Logger.Errorf("[Warm] %s: %s", p, err)
+						report.Failed = append(report.Failed, p)
+					} else {
+						opts.Logger.Debugf("[Warm] %s %s", p, status)
+						report.Fetched[status]++
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, t := range targets {
+		work <- t
+	}
+	close(work)
+	wg.Wait()
+	sort.Strings(report.Failed)
+	return report, nil
+}
+
+type client struct {
+	server    string
+	http      *http.Client
+	userAgent string
+}
+
+func newClient(server, userAgent string, timeout time.Duration) *client {
+	return &client{server: strings.TrimSuffix(server, "/"), http: &http.Client{Timeout: timeout}, userAgent: userAgent}
+}
+
+// get requests p from the server.
+func (c *client) get(p string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, c.server+p, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	response, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, fmt.Errorf("server returned %s (X-Cache-Status: %s)", response.Status, response.Header.Get("X-Cache-Status"))
+	}
+	return response, nil
+}
+
+// fetch downloads the artifact at p completely, so the server caches it,
+// and returns its X-Cache-Status.
+func (c *client) fetch(p string) (string, error) {
+	response, err := c.get(p, nil)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if _, err := io.Copy(io.Discard, response.Body); err != nil {
+		return "", err
+	}
+	status := response.Header.Get("X-Cache-Status")
+	if status == "" {
+		status = "UNKNOWN"
+	}
+	return status, nil
+}
+
+// getJSON requests p from the server and decodes the answer into v.
+func (c *client) getJSON(p string, header http.Header, v any) error {
+	response, err := c.get(p, header)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	return json.NewDecoder(response.Body).Decode(v)
+}